@@ -0,0 +1,391 @@
+package show
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+)
+
+// The types of cells that point features can be aggregated in to.
+const (
+	aggregate_hex  string = "hex"
+	aggregate_grid string = "grid"
+)
+
+// The default size, in pixels, of the cells that point features are aggregated in to.
+const default_aggregate_size float64 = 40
+
+// aggregateConfig defines configuration details for maps aggregating point features in to hexagonal or square cells.
+type aggregateConfig struct {
+	// A relative URI for requesting aggregated cells.
+	URL string `json:"url"`
+	// The type of cells ("hex" or "grid") to display when the map is loaded. If empty aggregated cells are not
+	// displayed until they are enabled in the web application.
+	Type string `json:"type,omitempty"`
+	// The size of cells, in pixels.
+	Size float64 `json:"size"`
+	// The name of the numeric property whose sum and mean are calculated for each cell.
+	Property string `json:"property,omitempty"`
+}
+
+// aggregateCell is a hexagonal or square cell in which point features are aggregated.
+type aggregateCell struct {
+	// The center of the cell, in pixels at a given zoom level.
+	x     float64
+	y     float64
+	count int
+	sum   float64
+	// values is the number of points with a numeric value for the property being summed.
+	values int
+}
+
+// aggregateHandler returns an `http.Handler` for serving the point features in 'features' aggregated in to hexagonal or
+// square cells as a GeoJSON FeatureCollection of polygons. Valid query parameters are: "type" ("hex" or "grid"), "z" (the zoom level,
+// required), "bbox" (minx,miny,maxx,maxy), "size" (the size of cells in pixels, defaulting to 'default_size'), "sum" (the name of a
+// numeric property, defaulting to 'default_property') and zero or more "property" parameters, in the form of {NAME}={VALUE}, used to
+// filter points as described in `featuresQueryFromRequest`. Each cell has "show:count" property and, if a property is being
+// aggregated, "show:sum" and "show:mean" properties. The FeatureCollection includes a "show:max_count" foreign member containing
+// the largest number of points in a single cell.
+func aggregateHandler(features *featureCollection, default_size float64, default_property string) http.Handler {
+
+	cache := newResponseCache()
+
+	fn := func(rsp http.ResponseWriter, req *http.Request) {
+
+		params := req.URL.Query()
+
+		cell_type := params.Get("type")
+
+		switch cell_type {
+		case aggregate_hex, aggregate_grid:
+			// pass
+		case "":
+			cell_type = aggregate_hex
+		default:
+			http.Error(rsp, "Invalid type parameter", http.StatusBadRequest)
+			return
+		}
+
+		q, err := featuresQueryFromRequest(req)
+
+		if err != nil {
+			http.Error(rsp, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if q.Zoom == nil {
+			http.Error(rsp, "Missing z parameter", http.StatusBadRequest)
+			return
+		}
+
+		size := default_size
+
+		if params.Has("size") {
+
+			v, err := strconv.ParseFloat(params.Get("size"), 64)
+
+			if err != nil || v < 2 {
+				http.Error(rsp, "Invalid size parameter", http.StatusBadRequest)
+				return
+			}
+
+			size = v
+		}
+
+		property := default_property
+
+		if params.Has("sum") {
+			property = params.Get("sum")
+		}
+
+		version := features.Version()
+		cache_key := params.Encode()
+
+		body, exists := cache.Get(version, cache_key)
+
+		if exists {
+			body.ServeHTTP(rsp, req)
+			return
+		}
+
+		fc := aggregatePoints(features, q, cell_type, size, property)
+
+		enc_json, err := fc.MarshalJSON()
+
+		if err != nil {
+			slog.Error("Failed to marshal aggregated cells", "error", err)
+			http.Error(rsp, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		body = newEncodedBody(enc_json, "application/json")
+		// Only cache the response if the features didn't change while it was being produced.
+
+		if features.Version() == version {
+			cache.Set(version, cache_key, body)
+		}
+
+		body.ServeHTTP(rsp, req)
+		return
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+// aggregatePoints aggregates the point features in 'features' which match 'query' in to cells of 'cell_type' and 'size' pixels at
+// the zoom level of 'query' (which is required). Only the bounding box and property criteria of 'query' are used. If 'property' is
+// not empty the sum and mean of its (numeric) values are calculated for each cell.
+func aggregatePoints(features *featureCollection, query *featuresQuery, cell_type string, size float64, property string) *geojson.FeatureCollection {
+
+	world := 256 * math.Pow(2, float64(*query.Zoom))
+
+	q := &featuresQuery{
+		Properties: query.Properties,
+	}
+
+	b := query.Bound
+
+	if b != nil {
+
+		// Include the points in cells which straddle the bounding box.
+
+		pad := size * 360 / world
+		padded := b.Pad(pad)
+
+		q.Bound = &padded
+	}
+
+	results := features.Query(q)
+
+	cells := make(map[[2]int]*aggregateCell)
+	keys := make([][2]int, 0)
+
+	add := func(pt orb.Point, props geojson.Properties) {
+
+		x := lngX(pt.Lon()) * world
+		y := latY(pt.Lat()) * world
+
+		var key [2]int
+		var cx, cy float64
+
+		switch cell_type {
+		case aggregate_grid:
+			key, cx, cy = gridCell(x, y, size)
+		default:
+			key, cx, cy = hexCell(x, y, size/2)
+		}
+
+		c, exists := cells[key]
+
+		if !exists {
+			c = &aggregateCell{x: cx, y: cy}
+			cells[key] = c
+			keys = append(keys, key)
+		}
+
+		c.count += 1
+
+		if property == "" {
+			return
+		}
+
+		v, ok := numericValue(props[property])
+
+		if ok {
+			c.sum += v
+			c.values += 1
+		}
+	}
+
+	for _, f := range results.Features {
+
+		switch geom := f.Geometry.(type) {
+		case orb.Point:
+			add(geom, f.Properties)
+		case orb.MultiPoint:
+
+			for _, pt := range geom {
+				add(pt, f.Properties)
+			}
+		}
+	}
+
+	fc := geojson.NewFeatureCollection()
+	max_count := 0
+
+	for _, k := range keys {
+
+		c := cells[k]
+
+		var ring orb.Ring
+
+		switch cell_type {
+		case aggregate_grid:
+			ring = gridRing(c.x, c.y, size, world)
+		default:
+			ring = hexRing(c.x, c.y, size/2, world)
+		}
+
+		f := geojson.NewFeature(orb.Polygon{ring})
+		f.Properties["show:count"] = c.count
+
+		if property != "" && c.values > 0 {
+			f.Properties["show:sum"] = c.sum
+			f.Properties["show:mean"] = c.sum / float64(c.values)
+		}
+
+		fc.Append(f)
+		max_count = max(max_count, c.count)
+	}
+
+	fc.ExtraMembers = geojson.Properties{
+		"show:max_count": max_count,
+	}
+
+	return fc
+}
+
+// gridCell returns the key and center of the square cell of 'size' pixels containing 'x' and 'y'.
+func gridCell(x float64, y float64, size float64) ([2]int, float64, float64) {
+
+	i := math.Floor(x / size)
+	j := math.Floor(y / size)
+
+	return [2]int{int(i), int(j)}, (i + 0.5) * size, (j + 0.5) * size
+}
+
+// hexCell returns the key and center of the (pointy-topped) hexagonal cell with radius 'r' pixels containing 'x' and 'y'.
+// Odd rows of cells are offset by half a cell. Since hexagonal cells are the Voronoi cells of their centers the cell containing
+// a point is the one whose center is nearest, which is found by comparing the nearest center in the row containing the point and
+// the rows above and below it.
+func hexCell(x float64, y float64, r float64) ([2]int, float64, float64) {
+
+	dx := r * 2 * math.Sin(math.Pi/3)
+	dy := r * 1.5
+
+	row := int(math.Round(y / dy))
+
+	var key [2]int
+	var cx, cy float64
+
+	best := math.Inf(1)
+
+	for j := row - 1; j <= row+1; j++ {
+
+		offset := float64(j&1) / 2
+		i := math.Round(x/dx - offset)
+
+		hx := (i + offset) * dx
+		hy := float64(j) * dy
+
+		d := (x-hx)*(x-hx) + (y-hy)*(y-hy)
+
+		if d < best {
+			best = d
+			key = [2]int{int(i), j}
+			cx = hx
+			cy = hy
+		}
+	}
+
+	return key, cx, cy
+}
+
+// gridRing returns the ring, in longitude and latitude, for the square cell of 'size' pixels centered on 'cx' and 'cy'
+// in a world which is 'world' pixels wide.
+func gridRing(cx float64, cy float64, size float64, world float64) orb.Ring {
+
+	h := size / 2
+
+	corners := [][2]float64{
+		{cx - h, cy - h},
+		{cx + h, cy - h},
+		{cx + h, cy + h},
+		{cx - h, cy + h},
+	}
+
+	return pixelRing(corners, world)
+}
+
+// hexRing returns the ring, in longitude and latitude, for the hexagonal cell with radius 'r' pixels centered on 'cx' and 'cy'
+// in a world which is 'world' pixels wide.
+func hexRing(cx float64, cy float64, r float64, world float64) orb.Ring {
+
+	corners := make([][2]float64, 6)
+
+	for i := range corners {
+		a := float64(i) * math.Pi / 3
+		corners[i] = [2]float64{cx + math.Sin(a)*r, cy - math.Cos(a)*r}
+	}
+
+	return pixelRing(corners, world)
+}
+
+// pixelRing converts 'corners', in pixels in a world which is 'world' pixels wide, in to a closed, counter-clockwise, ring.
+func pixelRing(corners [][2]float64, world float64) orb.Ring {
+
+	ring := make(orb.Ring, 0, len(corners)+1)
+
+	// Pixel coordinates increase southwards so iterate in reverse order to produce a counter-clockwise ring.
+
+	for i := len(corners) - 1; i >= 0; i-- {
+		c := corners[i]
+		ring = append(ring, orb.Point{xLng(c[0] / world), yLat(max(0, min(1, c[1]/world)))})
+	}
+
+	ring = append(ring, ring[0])
+	return ring
+}
+
+// numericValue returns 'v' as a float64 if it is a number or a string which can be parsed as a number. Values which
+// are not finite (NaN or infinite) are rejected since they can not be encoded as JSON.
+func numericValue(v any) (float64, bool) {
+
+	var f float64
+
+	switch n := v.(type) {
+	case float64:
+		f = n
+	case float32:
+		f = float64(n)
+	case int:
+		f = float64(n)
+	case int64:
+		f = float64(n)
+	case string:
+
+		v, err := strconv.ParseFloat(strings.TrimSpace(n), 64)
+
+		if err != nil {
+			return 0, false
+		}
+
+		f = v
+
+	default:
+		return 0, false
+	}
+
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, false
+	}
+
+	return f, true
+}
+
+// validateAggregateType returns an error if 't' is not a valid aggregation type. An empty string is valid.
+func validateAggregateType(t string) error {
+
+	switch t {
+	case "", aggregate_hex, aggregate_grid:
+		return nil
+	default:
+		return fmt.Errorf("Invalid aggregation type, '%s'", t)
+	}
+}
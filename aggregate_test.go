@@ -0,0 +1,182 @@
+package show
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+)
+
+func TestAggregateHandler(t *testing.T) {
+
+	ctx := context.Background()
+
+	points := []*geojson.Feature{}
+
+	// Values which are not finite are ignored rather than producing JSON which can not be encoded
+
+	elevations := []any{"NaN", "+Inf", 5}
+
+	for i, height := range []float64{10, 20, 30} {
+
+		f := geojson.NewFeature(orb.Point{-122.38 + float64(i)*0.0001, 37.62})
+		f.Properties["height"] = height
+		f.Properties["elevation"] = elevations[i]
+		f.Properties["type"] = "tower"
+
+		if i == 2 {
+			f.Properties["type"] = "gate"
+		}
+
+		points = append(points, f)
+	}
+
+	features, err := newFeatureCollection(ctx, points, nil, "")
+
+	if err != nil {
+		t.Fatalf("Failed to create feature collection, %v", err)
+	}
+
+	tests := []struct {
+		query  string
+		status int
+		count  int
+		sum    float64
+	}{
+		{"z=10", 200, 3, 0},
+		{"z=10&type=grid", 200, 3, 0},
+		{"z=10&sum=height", 200, 3, 60},
+		{"z=10&sum=height&property=type=tower", 200, 2, 30},
+		{"z=10&sum=elevation", 200, 3, 5},
+		{"z=10&property=type=gate", 200, 1, 0},
+		{"z=10&property=height", 400, 0, 0},
+		{"z=10&type=triangle", 400, 0, 0},
+		{"sum=height", 400, 0, 0},
+	}
+
+	h := aggregateHandler(features, default_aggregate_size, "")
+
+	for _, test := range tests {
+
+		t.Run(test.query, func(t *testing.T) {
+
+			rsp := httptest.NewRecorder()
+			h.ServeHTTP(rsp, httptest.NewRequest("GET", "/aggregate.geojson?"+test.query, nil))
+
+			if rsp.Code != test.status {
+				t.Fatalf("Expected status code %d, got %d", test.status, rsp.Code)
+			}
+
+			if test.status != 200 {
+				return
+			}
+
+			fc, err := geojson.UnmarshalFeatureCollection(rsp.Body.Bytes())
+
+			if err != nil {
+				t.Fatalf("Failed to decode response, %v", err)
+			}
+
+			// All the points are close enough to be aggregated in to a single cell at zoom level 10
+
+			if len(fc.Features) != 1 {
+				t.Fatalf("Expected a single cell, got %d", len(fc.Features))
+			}
+
+			props := fc.Features[0].Properties
+
+			if props.MustInt("show:count") != test.count {
+				t.Fatalf("Expected a count of %d, got %v", test.count, props["show:count"])
+			}
+
+			if test.sum == 0 {
+
+				_, exists := props["show:sum"]
+
+				if exists {
+					t.Fatalf("Unexpected show:sum property")
+				}
+
+				return
+			}
+
+			if props.MustFloat64("show:sum") != test.sum {
+				t.Fatalf("Expected a sum of %f, got %v", test.sum, props["show:sum"])
+			}
+		})
+	}
+}
+
+func TestAggregateHandlerMaxCount(t *testing.T) {
+
+	ctx := context.Background()
+
+	features, err := newFeatureCollection(ctx, []*geojson.Feature{geojson.NewFeature(orb.Point{0, 0})}, nil, "")
+
+	if err != nil {
+		t.Fatalf("Failed to create feature collection, %v", err)
+	}
+
+	rsp := httptest.NewRecorder()
+	aggregateHandler(features, default_aggregate_size, "").ServeHTTP(rsp, httptest.NewRequest("GET", "/aggregate.geojson?z=2", nil))
+
+	var fc struct {
+		MaxCount int `json:"show:max_count"`
+	}
+
+	err = json.Unmarshal(rsp.Body.Bytes(), &fc)
+
+	if err != nil {
+		t.Fatalf("Failed to decode response, %v", err)
+	}
+
+	if fc.MaxCount != 1 {
+		t.Fatalf("Expected a max count of 1, got %d", fc.MaxCount)
+	}
+}
+
+func TestNumericValue(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		value    any
+		expected float64
+		ok       bool
+	}{
+		{"float", 1.5, 1.5, true},
+		{"int", 2, 2, true},
+		{"int64", int64(3), 3, true},
+		{"string", " 4.5 ", 4.5, true},
+		{"exponent", "1e3", 1000, true},
+		{"not a number", "tower", 0, false},
+		{"nil", nil, 0, false},
+		{"NaN string", "NaN", 0, false},
+		{"Inf string", "Inf", 0, false},
+		{"+Inf string", "+Inf", 0, false},
+		{"-Infinity string", "-Infinity", 0, false},
+		{"out of range string", "1e400", 0, false},
+		{"NaN", math.NaN(), 0, false},
+		{"Inf", math.Inf(1), 0, false},
+		{"float32 Inf", float32(math.Inf(-1)), 0, false},
+	}
+
+	for _, test := range tests {
+
+		t.Run(test.name, func(t *testing.T) {
+
+			v, ok := numericValue(test.value)
+
+			if ok != test.ok {
+				t.Fatalf("Expected ok to be %t, got %t (%v)", test.ok, ok, v)
+			}
+
+			if ok && v != test.expected {
+				t.Fatalf("Expected %f, got %f", test.expected, v)
+			}
+		})
+	}
+}
@@ -0,0 +1,554 @@
+package show
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+)
+
+// The methods used to assign features to classes when colouring them by property value.
+const (
+	choropleth_quantile    string = "quantile"
+	choropleth_equal       string = "equal-interval"
+	choropleth_jenks       string = "jenks"
+	choropleth_categorical string = "categorical"
+)
+
+// The default number of classes used when colouring features by a numeric property.
+const default_choropleth_classes int = 5
+
+// The maximum number of classes used when colouring features by a numeric property.
+const choropleth_max_classes int = 12
+
+// The default colour ramps for numeric and categorical properties.
+const (
+	default_choropleth_ramp    string = "YlOrRd"
+	default_choropleth_palette string = "Tableau10"
+)
+
+// The colour assigned to categorical values which don't have a colour of their own.
+const choropleth_other_color string = "#cccccc"
+
+// The fill opacity for coloured features if the default style does not define one.
+const choropleth_fill_opacity float64 = 0.7
+
+// The maximum number of values used to calculate Jenks natural breaks. Larger sets of values are sampled since
+// the cost of the algorithm grows with the square of the number of values.
+const choropleth_jenks_max_values int = 2000
+
+// Named sequential and diverging colour ramps. Colours for a given number of classes are interpolated from these.
+// These are derived from ColorBrewer (https://colorbrewer2.org) and Matplotlib's Viridis colour map.
+var choropleth_ramps = map[string][]string{
+	"Blues":   {"#f7fbff", "#deebf7", "#c6dbef", "#9ecae1", "#6baed6", "#4292c6", "#2171b5", "#08519c", "#08306b"},
+	"Greens":  {"#f7fcf5", "#e5f5e0", "#c7e9c0", "#a1d99b", "#74c476", "#41ab5d", "#238b45", "#006d2c", "#00441b"},
+	"Greys":   {"#ffffff", "#f0f0f0", "#d9d9d9", "#bdbdbd", "#969696", "#737373", "#525252", "#252525", "#000000"},
+	"Oranges": {"#fff5eb", "#fee6ce", "#fdd0a2", "#fdae6b", "#fd8d3c", "#f16913", "#d94801", "#a63603", "#7f2704"},
+	"Purples": {"#fcfbfd", "#efedf5", "#dadaeb", "#bcbddc", "#9e9ac8", "#807dba", "#6a51a3", "#54278f", "#3f007d"},
+	"Reds":    {"#fff5f0", "#fee0d2", "#fcbba1", "#fc9272", "#fb6a4a", "#ef3b2c", "#cb181d", "#a50f15", "#67000d"},
+	"YlOrRd":  {"#ffffcc", "#ffeda0", "#fed976", "#feb24c", "#fd8d3c", "#fc4e2a", "#e31a1c", "#bd0026", "#800026"},
+	"YlGnBu":  {"#ffffd9", "#edf8b1", "#c7e9b4", "#7fcdbb", "#41b6c4", "#1d91c0", "#225ea8", "#253494", "#081d58"},
+	"RdYlBu":  {"#d73027", "#f46d43", "#fdae61", "#fee090", "#ffffbf", "#e0f3f8", "#abd9e9", "#74add1", "#4575b4"},
+	"Viridis": {"#440154", "#482878", "#3e4989", "#31688e", "#26828e", "#1f9e89", "#35b779", "#6ece58", "#b5de2b", "#fde725"},
+}
+
+// Named qualitative colour palettes used for categorical properties. Colours are assigned in order.
+var choropleth_palettes = map[string][]string{
+	"Tableau10": {"#4e79a7", "#f28e2b", "#e15759", "#76b7b2", "#59a14f", "#edc948", "#b07aa1", "#ff9da7", "#9c755f", "#bab0ac"},
+	"Set1":      {"#e41a1c", "#377eb8", "#4daf4a", "#984ea3", "#ff7f00", "#ffff33", "#a65628", "#f781bf", "#999999"},
+}
+
+// choroplethConfig defines configuration details for maps colouring features by property value. It is used by the
+// web application to draw a legend.
+type choroplethConfig struct {
+	// The name of the property used to colour features.
+	Property string `json:"property"`
+	// The method used to assign features to classes.
+	Method string `json:"method"`
+	// The list of classes, in order.
+	Classes []*choroplethClass `json:"classes"`
+}
+
+// choroplethClass is an individual class of features coloured by property value. Numeric classes have a minimum and
+// maximum value. Categorical classes have a value or, for the class of values which don't have a colour of their own,
+// are flagged as "other".
+type choroplethClass struct {
+	Color string   `json:"color"`
+	Min   *float64 `json:"min,omitempty"`
+	Max   *float64 `json:"max,omitempty"`
+	Value *string  `json:"value,omitempty"`
+	Other bool     `json:"other,omitempty"`
+	// The number of features in the class when the map was loaded.
+	Count int `json:"count"`
+}
+
+// choropleth assigns colours to features according to the value of a property.
+type choropleth struct {
+	config       *choroplethConfig
+	categories   map[string]*choroplethClass
+	other        *choroplethClass
+	fill_opacity *float64
+}
+
+// newChoropleth returns a new `choropleth` instance for colouring 'features' by the value of 'property'. If 'method' is
+// "categorical" each distinct value is assigned a colour from the palette named 'ramp', in descending order of frequency,
+// and any remaining values are grouped together. Otherwise features are assigned to 'classes' classes, using "quantile",
+// "equal-interval" or "jenks" breaks, which are coloured using the colour ramp named 'ramp'. If 'fill_opacity' is not
+// nil it is used as the fill opacity for coloured features.
+func newChoropleth(features []*geojson.Feature, property string, method string, classes int, ramp string, fill_opacity *float64) (*choropleth, error) {
+
+	if method == "" {
+		method = choropleth_quantile
+	}
+
+	if fill_opacity == nil {
+		fill_opacity = Float(choropleth_fill_opacity)
+	}
+
+	c := &choropleth{
+		config: &choroplethConfig{
+			Property: property,
+			Method:   method,
+			Classes:  make([]*choroplethClass, 0),
+		},
+		fill_opacity: fill_opacity,
+	}
+
+	if method == choropleth_categorical {
+
+		if ramp == "" {
+			ramp = default_choropleth_palette
+		}
+
+		err := c.categorize(features, ramp)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return c, nil
+	}
+
+	if classes == 0 {
+		classes = default_choropleth_classes
+	}
+
+	if classes < 2 || classes > choropleth_max_classes {
+		return nil, fmt.Errorf("Invalid number of classes, %d (must be between 2 and %d)", classes, choropleth_max_classes)
+	}
+
+	if ramp == "" {
+		ramp = default_choropleth_ramp
+	}
+
+	values := make([]float64, 0)
+
+	for _, f := range features {
+
+		v, ok := numericValue(f.Properties[property])
+
+		if ok && !math.IsNaN(v) && !math.IsInf(v, 0) {
+			values = append(values, v)
+		}
+	}
+
+	slices.Sort(values)
+
+	var breaks []float64
+
+	switch method {
+	case choropleth_quantile:
+		breaks = quantileBreaks(values, classes)
+	case choropleth_equal:
+		breaks = equalIntervalBreaks(values, classes)
+	case choropleth_jenks:
+		breaks = jenksBreaks(values, classes)
+	default:
+		return nil, fmt.Errorf("Invalid classification method, '%s'", method)
+	}
+
+	breaks = slices.Compact(breaks)
+
+	count_classes := max(len(breaks)-1, 1)
+
+	colors, err := rampColors(ramp, count_classes)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(breaks) == 1 {
+		breaks = append(breaks, breaks[0])
+	}
+
+	for i := 0; i < len(breaks)-1; i++ {
+
+		class := &choroplethClass{
+			Color: colors[i],
+			Min:   &breaks[i],
+			Max:   &breaks[i+1],
+		}
+
+		c.config.Classes = append(c.config.Classes, class)
+	}
+
+	for _, v := range values {
+
+		class := c.numericClass(v)
+
+		if class != nil {
+			class.Count += 1
+		}
+	}
+
+	return c, nil
+}
+
+// categorize assigns a colour from the palette named 'palette' to each distinct value of the choropleth property in
+// 'features', in descending order of frequency. Values beyond the number of colours in the palette are grouped together.
+func (c *choropleth) categorize(features []*geojson.Feature, palette string) error {
+
+	colors, ok := choropleth_palettes[palette]
+
+	if !ok {
+
+		// Sequential ramps may also be used for categorical values
+
+		_, is_ramp := choropleth_ramps[palette]
+
+		if !is_ramp {
+			return fmt.Errorf("Invalid colour palette, '%s'", palette)
+		}
+	}
+
+	counts := make(map[string]int)
+
+	for _, f := range features {
+
+		v, exists := f.Properties[c.config.Property]
+
+		if !exists || v == nil {
+			continue
+		}
+
+		counts[fmt.Sprintf("%v", v)] += 1
+	}
+
+	keys := make([]string, 0, len(counts))
+
+	for k := range counts {
+		keys = append(keys, k)
+	}
+
+	slices.SortFunc(keys, func(a string, b string) int {
+		return cmp.Or(cmp.Compare(counts[b], counts[a]), strings.Compare(a, b))
+	})
+
+	count_colors := len(colors)
+
+	if !ok {
+
+		count_colors = min(len(keys), choropleth_max_classes)
+
+		ramp_colors, err := rampColors(palette, max(count_colors, 1))
+
+		if err != nil {
+			return err
+		}
+
+		colors = ramp_colors
+	}
+
+	c.categories = make(map[string]*choroplethClass)
+
+	for i, k := range keys {
+
+		if i >= count_colors {
+
+			if c.other == nil {
+				c.other = &choroplethClass{
+					Color: choropleth_other_color,
+					Other: true,
+				}
+			}
+
+			c.categories[k] = c.other
+			c.other.Count += counts[k]
+			continue
+		}
+
+		value := k
+
+		class := &choroplethClass{
+			Color: colors[i],
+			Value: &value,
+			Count: counts[k],
+		}
+
+		c.categories[k] = class
+		c.config.Classes = append(c.config.Classes, class)
+	}
+
+	if c.other != nil {
+		c.config.Classes = append(c.config.Classes, c.other)
+	}
+
+	return nil
+}
+
+// Config returns the configuration details for 'c' used by the web application to draw a legend.
+func (c *choropleth) Config() *choroplethConfig {
+	return c.config
+}
+
+// Style returns the style for 'f' derived from the class it belongs to or nil if 'f' does not have a (valid) value
+// for the choropleth property. Lines are assigned a stroke colour and all other geometries a fill colour.
+func (c *choropleth) Style(f *geojson.Feature) *LeafletStyle {
+
+	v, exists := f.Properties[c.config.Property]
+
+	if !exists || v == nil {
+		return nil
+	}
+
+	var class *choroplethClass
+
+	if c.config.Method == choropleth_categorical {
+		class = c.categories[fmt.Sprintf("%v", v)]
+	} else {
+
+		n, ok := numericValue(v)
+
+		if ok {
+			class = c.numericClass(n)
+		}
+	}
+
+	if class == nil {
+		return nil
+	}
+
+	switch f.Geometry.(type) {
+	case orb.LineString, orb.MultiLineString:
+		return &LeafletStyle{Color: class.Color}
+	default:
+		return &LeafletStyle{FillColor: class.Color, FillOpacity: c.fill_opacity}
+	}
+}
+
+// numericClass returns the class that 'v' belongs to or nil if it is outside the range of all the classes.
+func (c *choropleth) numericClass(v float64) *choroplethClass {
+
+	for _, class := range c.config.Classes {
+
+		if v >= *class.Min && v <= *class.Max {
+			return class
+		}
+	}
+
+	return nil
+}
+
+// quantileBreaks returns the class boundaries (including the minimum and maximum values) dividing the sorted list
+// 'values' in to 'k' classes each containing (approximately) the same number of values.
+func quantileBreaks(values []float64, k int) []float64 {
+
+	n := len(values)
+
+	if n == 0 {
+		return []float64{0}
+	}
+
+	breaks := []float64{values[0]}
+
+	for i := 1; i < k; i++ {
+		idx := min(int(math.Ceil(float64(i*n)/float64(k)))-1, n-1)
+		breaks = append(breaks, values[max(idx, 0)])
+	}
+
+	breaks = append(breaks, values[n-1])
+	return breaks
+}
+
+// equalIntervalBreaks returns the class boundaries dividing the range of the sorted list 'values' in to 'k' classes
+// of equal size.
+func equalIntervalBreaks(values []float64, k int) []float64 {
+
+	n := len(values)
+
+	if n == 0 {
+		return []float64{0}
+	}
+
+	lo := values[0]
+	hi := values[n-1]
+
+	breaks := []float64{lo}
+
+	for i := 1; i < k; i++ {
+		breaks = append(breaks, lo+(hi-lo)*float64(i)/float64(k))
+	}
+
+	breaks = append(breaks, hi)
+	return breaks
+}
+
+// jenksBreaks returns the class boundaries dividing the sorted list 'values' in to 'k' classes using the Jenks natural
+// breaks (Fisher-Jenks) algorithm which minimizes the variance within each class. Lists longer than
+// choropleth_jenks_max_values are sampled at regular intervals.
+func jenksBreaks(values []float64, k int) []float64 {
+
+	n := len(values)
+
+	if n == 0 {
+		return []float64{0}
+	}
+
+	if n > choropleth_jenks_max_values {
+
+		sample := make([]float64, choropleth_jenks_max_values)
+
+		for i := range sample {
+			sample[i] = values[i*(n-1)/(choropleth_jenks_max_values-1)]
+		}
+
+		values = sample
+		n = len(values)
+	}
+
+	if k >= n {
+		return slices.Clone(values)
+	}
+
+	// lower[l][j] is the (1-based) index of the first value in the last class of the optimal division of the
+	// first l values in to j classes and variance[l][j] is the total variance of that division.
+
+	lower := make([][]int, n+1)
+	variance := make([][]float64, n+1)
+
+	for l := range lower {
+		lower[l] = make([]int, k+1)
+		variance[l] = make([]float64, k+1)
+	}
+
+	for j := 1; j <= k; j++ {
+
+		lower[1][j] = 1
+
+		for l := 2; l <= n; l++ {
+			variance[l][j] = math.Inf(1)
+		}
+	}
+
+	for l := 2; l <= n; l++ {
+
+		var sum, sum_sq, w, v float64
+
+		for m := 1; m <= l; m++ {
+
+			i := l - m + 1
+			val := values[i-1]
+
+			sum += val
+			sum_sq += val * val
+			w += 1
+
+			v = sum_sq - (sum*sum)/w
+
+			if i == 1 {
+				continue
+			}
+
+			for j := 2; j <= k; j++ {
+
+				if variance[l][j] >= v+variance[i-1][j-1] {
+					lower[l][j] = i
+					variance[l][j] = v + variance[i-1][j-1]
+				}
+			}
+		}
+
+		lower[l][1] = 1
+		variance[l][1] = v
+	}
+
+	breaks := make([]float64, k+1)
+	breaks[k] = values[n-1]
+	breaks[0] = values[0]
+
+	l := n
+
+	for j := k; j >= 2; j-- {
+		idx := lower[l][j] - 2
+		breaks[j-1] = values[idx]
+		l = lower[l][j] - 1
+	}
+
+	return breaks
+}
+
+// rampColors returns 'k' colours interpolated from the colour ramp named 'name' or, for qualitative palettes, the
+// first 'k' colours (repeating if necessary).
+func rampColors(name string, k int) ([]string, error) {
+
+	palette, ok := choropleth_palettes[name]
+
+	if ok {
+
+		colors := make([]string, k)
+
+		for i := range colors {
+			colors[i] = palette[i%len(palette)]
+		}
+
+		return colors, nil
+	}
+
+	ramp, ok := choropleth_ramps[name]
+
+	if !ok {
+		return nil, fmt.Errorf("Invalid colour ramp, '%s'", name)
+	}
+
+	anchors := make([][3]float64, len(ramp))
+
+	for i, str := range ramp {
+
+		c, err := parseColor(str, 1)
+
+		if err != nil {
+			return nil, err
+		}
+
+		anchors[i] = [3]float64{float64(c.R), float64(c.G), float64(c.B)}
+	}
+
+	colors := make([]string, k)
+
+	for i := range colors {
+
+		t := 0.5
+
+		if k > 1 {
+			t = float64(i) / float64(k-1)
+		}
+
+		pos := t * float64(len(anchors)-1)
+		idx := min(int(pos), len(anchors)-2)
+		frac := pos - float64(idx)
+
+		a := anchors[idx]
+		b := anchors[idx+1]
+
+		colors[i] = fmt.Sprintf("#%02x%02x%02x",
+			clampUint8(a[0]+(b[0]-a[0])*frac),
+			clampUint8(a[1]+(b[1]-a[1])*frac),
+			clampUint8(a[2]+(b[2]-a[2])*frac))
+	}
+
+	return colors, nil
+}
@@ -0,0 +1,482 @@
+package show
+
+import (
+	"log/slog"
+	"math"
+	"net/http"
+	"sync"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+)
+
+// The maximum zoom level at which points are clustered. Points are never clustered at higher zoom levels.
+const cluster_max_zoom int = 16
+
+// The cluster radius, in pixels.
+const cluster_radius float64 = 40
+
+// The tile extent, in pixels, that the cluster radius is calculated relative to.
+const cluster_extent float64 = 512
+
+// clustersConfig defines configuration details for maps rendering point features as clusters.
+type clustersConfig struct {
+	// A relative URI for requesting clustered features.
+	URL string `json:"url"`
+	// The maximum zoom level at which points are clustered.
+	MaxZoom int `json:"max_zoom"`
+}
+
+// clusterNode is a point, or a cluster of points, at a given zoom level in a clusterIndex.
+type clusterNode struct {
+	// x and y are the (weighted) spherical mercator coordinates of the node in the range of 0-1.
+	x float64
+	y float64
+	// count is the number of points in the node.
+	count int
+	// offset is the offset of the feature in a feature collection for single points. It is -1 for clusters.
+	offset int
+	// children are the offsets of the nodes at the next (higher) zoom level contained by this node.
+	children []int
+	// zoom is used to track whether the node has been processed when building the next (lower) zoom level.
+	zoom int
+}
+
+// clusterLevel is the list of nodes for a given zoom level and a spatial index of their locations.
+type clusterLevel struct {
+	nodes []*clusterNode
+	index *spatialIndex
+}
+
+// clusterIndex is a hierarchical clustering index of the point features in a featureCollection, modeled on
+// the "supercluster" library. Clusters are derived for every zoom level between 0 and cluster_max_zoom and the
+// individual points (which are never clustered) are stored at cluster_max_zoom + 1.
+type clusterIndex struct {
+	levels []*clusterLevel
+}
+
+// clusterResult is a point, or a cluster of points, returned by a clusterIndex query.
+type clusterResult struct {
+	// Point is the location of the point or cluster.
+	Point orb.Point
+	// Count is the number of points in the cluster.
+	Count int
+	// Offset is the offset of the feature in a feature collection for single points. It is -1 for clusters.
+	Offset int
+	// ExpansionZoom is the zoom level at which a cluster breaks apart in to multiple nodes.
+	ExpansionZoom int
+}
+
+// newClusterIndex returns a new `clusterIndex` for the point features in 'features'.
+func newClusterIndex(features []*geojson.Feature) *clusterIndex {
+
+	nodes := make([]*clusterNode, 0)
+
+	for idx, f := range features {
+
+		pt, ok := f.Geometry.(orb.Point)
+
+		if !ok {
+			continue
+		}
+
+		nodes = append(nodes, &clusterNode{
+			x:      lngX(pt.Lon()),
+			y:      latY(pt.Lat()),
+			count:  1,
+			offset: idx,
+			zoom:   math.MaxInt,
+		})
+	}
+
+	levels := make([]*clusterLevel, cluster_max_zoom+2)
+	levels[cluster_max_zoom+1] = newClusterLevel(nodes)
+
+	for z := cluster_max_zoom; z >= 0; z-- {
+		nodes = clusterNodes(nodes, z)
+		levels[z] = newClusterLevel(nodes)
+	}
+
+	idx := &clusterIndex{
+		levels: levels,
+	}
+
+	return idx
+}
+
+// Query returns the points and clusters at zoom level 'z' which intersect 'b'.
+func (idx *clusterIndex) Query(b orb.Bound, z int) []*clusterResult {
+
+	z = max(0, min(z, cluster_max_zoom+1))
+
+	level := idx.levels[z]
+	results := make([]*clusterResult, 0)
+
+	for _, i := range level.index.Search(b) {
+
+		n := level.nodes[i]
+
+		r := &clusterResult{
+			Point:         orb.Point{xLng(n.x), yLat(n.y)},
+			Count:         n.count,
+			Offset:        n.offset,
+			ExpansionZoom: idx.expansionZoom(z, i),
+		}
+
+		if n.count > 1 {
+			r.Offset = -1
+		}
+
+		results = append(results, r)
+	}
+
+	return results
+}
+
+// expansionZoom returns the zoom level at which the node at offset 'i' in zoom level 'z' breaks apart.
+func (idx *clusterIndex) expansionZoom(z int, i int) int {
+
+	for z <= cluster_max_zoom {
+
+		n := idx.levels[z].nodes[i]
+
+		if len(n.children) != 1 {
+			break
+		}
+
+		i = n.children[0]
+		z += 1
+	}
+
+	return z + 1
+}
+
+func newClusterLevel(nodes []*clusterNode) *clusterLevel {
+
+	bounds := make([]orb.Bound, len(nodes))
+	offsets := make([]int, len(nodes))
+
+	for i, n := range nodes {
+		bounds[i] = orb.Point{xLng(n.x), yLat(n.y)}.Bound()
+		offsets[i] = i
+	}
+
+	l := &clusterLevel{
+		nodes: nodes,
+		index: newSpatialIndex(bounds, offsets),
+	}
+
+	return l
+}
+
+// clusterNodes derives the list of nodes for zoom level 'z' from 'nodes' (the nodes for zoom level z + 1).
+func clusterNodes(nodes []*clusterNode, z int) []*clusterNode {
+
+	r := cluster_radius / (cluster_extent * math.Pow(2, float64(z)))
+	grid := newClusterGrid(nodes, r)
+
+	clusters := make([]*clusterNode, 0)
+
+	for i, n := range nodes {
+
+		if n.zoom <= z {
+			continue
+		}
+
+		n.zoom = z
+
+		neighbours := grid.Within(nodes, n.x, n.y, r)
+
+		count := n.count
+		wx := n.x * float64(n.count)
+		wy := n.y * float64(n.count)
+
+		children := []int{i}
+
+		for _, j := range neighbours {
+
+			b := nodes[j]
+
+			if b.zoom <= z {
+				continue
+			}
+
+			b.zoom = z
+
+			count += b.count
+			wx += b.x * float64(b.count)
+			wy += b.y * float64(b.count)
+
+			children = append(children, j)
+		}
+
+		clusters = append(clusters, &clusterNode{
+			x:        wx / float64(count),
+			y:        wy / float64(count),
+			count:    count,
+			offset:   n.offset,
+			children: children,
+			zoom:     math.MaxInt,
+		})
+	}
+
+	return clusters
+}
+
+// clusterGrid is a uniform grid of node offsets used to find neighbouring nodes.
+type clusterGrid struct {
+	size  float64
+	cells map[[2]int][]int
+}
+
+func newClusterGrid(nodes []*clusterNode, size float64) *clusterGrid {
+
+	g := &clusterGrid{
+		size:  size,
+		cells: make(map[[2]int][]int),
+	}
+
+	for i, n := range nodes {
+		k := g.key(n.x, n.y)
+		g.cells[k] = append(g.cells[k], i)
+	}
+
+	return g
+}
+
+func (g *clusterGrid) key(x float64, y float64) [2]int {
+	return [2]int{int(math.Floor(x / g.size)), int(math.Floor(y / g.size))}
+}
+
+// Within returns the offsets of the nodes within 'r' of 'x' and 'y'.
+func (g *clusterGrid) Within(nodes []*clusterNode, x float64, y float64, r float64) []int {
+
+	results := make([]int, 0)
+	k := g.key(x, y)
+
+	r2 := r * r
+
+	for dx := -1; dx <= 1; dx++ {
+
+		for dy := -1; dy <= 1; dy++ {
+
+			for _, i := range g.cells[[2]int{k[0] + dx, k[1] + dy}] {
+
+				n := nodes[i]
+
+				ddx := n.x - x
+				ddy := n.y - y
+
+				if ddx*ddx+ddy*ddy <= r2 {
+					results = append(results, i)
+				}
+			}
+		}
+	}
+
+	return results
+}
+
+// clusterCache is a thread-safe container for a clusterIndex which is rebuilt whenever the version of the
+// feature collection it is derived from changes.
+type clusterCache struct {
+	mu      *sync.Mutex
+	version int64
+	index   *clusterIndex
+}
+
+func newClusterCache() *clusterCache {
+
+	c := &clusterCache{
+		mu:      new(sync.Mutex),
+		version: -1,
+	}
+
+	return c
+}
+
+// Index returns the clusterIndex for the current version of 'features'.
+func (c *clusterCache) Index(features *featureCollection) *clusterIndex {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	version := features.Version()
+
+	if c.index == nil || c.version != version {
+		fc, _ := features.snapshot()
+		c.index = newClusterIndex(fc)
+		c.version = version
+	}
+
+	return c.index
+}
+
+// Spherical mercator helper functions, projecting to and from the range of 0-1.
+
+func lngX(lng float64) float64 {
+	return lng/360 + 0.5
+}
+
+func latY(lat float64) float64 {
+
+	sin := math.Sin(lat * math.Pi / 180)
+	y := 0.5 - 0.25*math.Log((1+sin)/(1-sin))/math.Pi
+
+	return max(0, min(1, y))
+}
+
+func xLng(x float64) float64 {
+	return (x - 0.5) * 360
+}
+
+func yLat(y float64) float64 {
+	y2 := (180 - y*360) * math.Pi / 180
+	return 360*math.Atan(math.Exp(y2))/math.Pi - 90
+}
+
+// clustersHandler returns an `http.Handler` for serving the point features in 'features' clustered for a given zoom
+// level as a GeoJSON FeatureCollection, encoded using 'transport' (see `marshalFeatureCollection` for details). The zoom level is read from the required "z" query parameter. The "bbox" and
+// "properties" query parameters are supported as described in `featuresQueryFromRequest`; other parameters are ignored.
+// If 'simplified' is not nil then non-point features are returned with the simplified geometries for the zoom level.
+// Clusters are returned as Point features with "show:cluster" (true), "show:count" and "show:expansion_zoom" (the zoom level
+// at which the cluster breaks apart) properties. Individual points, and any non-point features intersecting the bounding box,
+// are returned as-is. The FeatureCollection includes a "show:ids" foreign member containing the "show:id" identifier for each
+// feature (or an empty string for clusters). If 'styles' is not nil the FeatureCollection also includes a "show:styles" foreign
+// member containing the style for each feature (or null for clusters and features rendered using the default style).
+func clustersHandler(features *featureCollection, simplified *simplifiedGeometries, styles *featureStyles, transport string) http.Handler {
+
+	clusters := newClusterCache()
+	cache := newResponseCache()
+
+	fn := func(rsp http.ResponseWriter, req *http.Request) {
+
+		q, err := featuresQueryFromRequest(req)
+
+		if err != nil {
+			http.Error(rsp, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if q.Zoom == nil {
+			http.Error(rsp, "Missing z parameter", http.StatusBadRequest)
+			return
+		}
+
+		z := *q.Zoom
+
+		version := features.Version()
+		cache_key := req.URL.Query().Encode()
+
+		body, exists := cache.Get(version, cache_key)
+
+		if exists {
+			body.ServeHTTP(rsp, req)
+			return
+		}
+
+		b := orb.Bound{
+			Min: orb.Point{-180, -90},
+			Max: orb.Point{180, 90},
+		}
+
+		if q.Bound != nil {
+			b = *q.Bound
+		}
+
+		fc := geojson.NewFeatureCollection()
+		ids := make([]string, 0)
+		offsets := make([]int, 0)
+
+		// Non-point features are never clustered
+
+		others := features.Query(&featuresQuery{Bound: &b})
+
+		if simplified != nil {
+			simplified.Update(features)
+			others.Features = simplified.Features(others.Features, others.Offsets, z)
+		}
+
+		for i, f := range others.Features {
+
+			_, is_point := f.Geometry.(orb.Point)
+
+			if is_point {
+				continue
+			}
+
+			fc.Append(f)
+			ids = append(ids, others.Ids[i])
+			offsets = append(offsets, others.Offsets[i])
+		}
+
+		idx := clusters.Index(features)
+		all_features, all_ids := features.snapshot()
+
+		for _, r := range idx.Query(b, z) {
+
+			if r.Offset == -1 {
+
+				f := geojson.NewFeature(r.Point)
+				f.Properties["show:cluster"] = true
+				f.Properties["show:count"] = r.Count
+				f.Properties["show:expansion_zoom"] = r.ExpansionZoom
+
+				fc.Append(f)
+				ids = append(ids, "")
+				offsets = append(offsets, -1)
+				continue
+			}
+
+			fc.Append(all_features[r.Offset])
+			ids = append(ids, all_ids[r.Offset])
+			offsets = append(offsets, r.Offset)
+		}
+
+		if q.IncludeProperties != nil {
+
+			for i, f := range fc.Features {
+
+				if ids[i] == "" {
+					continue
+				}
+
+				fc.Features[i] = selectProperties(f, q.IncludeProperties)
+			}
+		}
+
+		fc.ExtraMembers = geojson.Properties{
+			"show:ids": ids,
+		}
+
+		if styles != nil {
+
+			styles.Update(features)
+			feature_styles := styles.Styles(offsets)
+
+			if feature_styles != nil {
+				fc.ExtraMembers["show:styles"] = feature_styles
+			}
+		}
+
+		enc_fc, content_type, err := marshalFeatureCollection(fc, transport)
+
+		if err != nil {
+			slog.Error("Failed to marshal clusters", "z", z, "transport", transport, "error", err)
+			http.Error(rsp, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		body = newEncodedBody(enc_fc, content_type)
+		// Only cache the response if the features didn't change while it was being produced.
+
+		if features.Version() == version {
+			cache.Set(version, cache_key, body)
+		}
+
+		body.ServeHTTP(rsp, req)
+		return
+	}
+
+	return http.HandlerFunc(fn)
+}
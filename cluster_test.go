@@ -0,0 +1,190 @@
+package show
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+)
+
+// testClusterFeatures returns two points at SFO, about 100 metres apart, a point in New York and a polygon.
+func testClusterFeatures() []*geojson.Feature {
+
+	return []*geojson.Feature{
+		geojson.NewFeature(orb.Point{-122.3866, 37.6164}),
+		geojson.NewFeature(orb.Point{-122.3856, 37.6170}),
+		geojson.NewFeature(orb.Point{-73.7781, 40.6413}),
+		geojson.NewFeature(orb.Polygon{{{0, 0}, {1, 0}, {1, 1}, {0, 1}, {0, 0}}}),
+	}
+}
+
+func TestClusterIndex(t *testing.T) {
+
+	idx := newClusterIndex(testClusterFeatures())
+
+	world := orb.Bound{Min: orb.Point{-180, -90}, Max: orb.Point{180, 90}}
+
+	tests := []struct {
+		z      int
+		counts []int
+	}{
+		{0, []int{1, 2}},
+		{8, []int{1, 2}},
+		{cluster_max_zoom, []int{1, 1, 1}},
+		{cluster_max_zoom + 1, []int{1, 1, 1}},
+		{cluster_max_zoom + 10, []int{1, 1, 1}},
+	}
+
+	for _, test := range tests {
+
+		results := idx.Query(world, test.z)
+
+		counts := make([]int, len(results))
+
+		for i, r := range results {
+
+			counts[i] = r.Count
+
+			if r.Count > 1 && r.Offset != -1 {
+				t.Fatalf("Expected clusters at zoom %d to have an offset of -1, got %d", test.z, r.Offset)
+			}
+
+			if r.Count == 1 && r.Offset == -1 {
+				t.Fatalf("Expected points at zoom %d to have an offset", test.z)
+			}
+		}
+
+		slices.Sort(counts)
+
+		if !slices.Equal(counts, test.counts) {
+			t.Fatalf("Expected counts %v at zoom %d, got %v", test.counts, test.z, counts)
+		}
+	}
+
+	// The SFO cluster breaks apart at its expansion zoom level but not before
+
+	var expansion_zoom int
+
+	for _, r := range idx.Query(world, 0) {
+
+		if r.Count == 2 {
+			expansion_zoom = r.ExpansionZoom
+		}
+	}
+
+	if expansion_zoom <= 8 || expansion_zoom > cluster_max_zoom+1 {
+		t.Fatalf("Unexpected expansion zoom %d", expansion_zoom)
+	}
+
+	if len(idx.Query(world, expansion_zoom-1)) != 2 {
+		t.Fatalf("Expected SFO points to be clustered at zoom %d", expansion_zoom-1)
+	}
+
+	if len(idx.Query(world, expansion_zoom)) != 3 {
+		t.Fatalf("Expected SFO points not to be clustered at zoom %d", expansion_zoom)
+	}
+
+	// Queries only return nodes inside the bounding box
+
+	sfo := orb.Bound{Min: orb.Point{-123, 37}, Max: orb.Point{-122, 38}}
+
+	if len(idx.Query(sfo, cluster_max_zoom)) != 2 {
+		t.Fatalf("Expected two points inside SFO bounding box")
+	}
+}
+
+func TestClustersHandler(t *testing.T) {
+
+	ctx := context.Background()
+
+	features, err := newFeatureCollection(ctx, testClusterFeatures(), nil, "")
+
+	if err != nil {
+		t.Fatalf("Failed to create feature collection, %v", err)
+	}
+
+	h := clustersHandler(features, nil, nil, transport_geojson)
+
+	tests := []struct {
+		query    string
+		status   int
+		clusters int
+		features int
+	}{
+		{"z=0", 200, 1, 3},
+		{"z=16", 200, 0, 4},
+		{"z=0&bbox=-123,37,-122,38", 200, 1, 1},
+		{"z=16&bbox=-123,37,-122,38", 200, 0, 2},
+		{"z=0&bbox=-1,-1,2,2", 200, 0, 1},
+		{"", 400, 0, 0},
+		{"z=-1", 400, 0, 0},
+	}
+
+	for _, test := range tests {
+
+		t.Run(test.query, func(t *testing.T) {
+
+			rsp := httptest.NewRecorder()
+			h.ServeHTTP(rsp, httptest.NewRequest("GET", "/clusters.geojson?"+test.query, nil))
+
+			if rsp.Code != test.status {
+				t.Fatalf("Expected status code %d, got %d", test.status, rsp.Code)
+			}
+
+			if test.status != 200 {
+				return
+			}
+
+			var fc struct {
+				Features []*geojson.Feature `json:"features"`
+				Ids      []string           `json:"show:ids"`
+			}
+
+			err := json.Unmarshal(rsp.Body.Bytes(), &fc)
+
+			if err != nil {
+				t.Fatalf("Failed to decode response, %v", err)
+			}
+
+			if len(fc.Features) != test.features || len(fc.Ids) != test.features {
+				t.Fatalf("Expected %d features (and ids), got %d (%d)", test.features, len(fc.Features), len(fc.Ids))
+			}
+
+			clusters := 0
+
+			for i, f := range fc.Features {
+
+				if !f.Properties.MustBool("show:cluster", false) {
+
+					if fc.Ids[i] == "" {
+						t.Fatalf("Expected feature at offset %d to have an id", i)
+					}
+
+					continue
+				}
+
+				clusters += 1
+
+				if fc.Ids[i] != "" {
+					t.Fatalf("Expected cluster at offset %d to have an empty id", i)
+				}
+
+				if f.Properties.MustInt("show:count") != 2 {
+					t.Fatalf("Expected cluster to contain 2 points, got %v", f.Properties["show:count"])
+				}
+
+				if f.Properties.MustInt("show:expansion_zoom") <= 0 {
+					t.Fatalf("Missing show:expansion_zoom property")
+				}
+			}
+
+			if clusters != test.clusters {
+				t.Fatalf("Expected %d clusters, got %d", test.clusters, clusters)
+			}
+		})
+	}
+}
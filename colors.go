@@ -0,0 +1,472 @@
+package show
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// The numbers that may be used as the arguments of CSS colour functions. This is stricter than `strconv.ParseFloat`
+// which also accepts values like "Inf", "NaN" or hexadecimal floating-point numbers.
+var re_color_number = regexp.MustCompile(`^[+-]?(?:[0-9]+(?:\.[0-9]*)?|\.[0-9]+)(?:e[+-]?[0-9]+)?$`)
+
+// The CSS colour names (as defined by the CSS Color Module Level 4) and their hexadecimal values.
+var named_colors = map[string]string{
+	"aliceblue":            "#f0f8ff",
+	"antiquewhite":         "#faebd7",
+	"aqua":                 "#00ffff",
+	"aquamarine":           "#7fffd4",
+	"azure":                "#f0ffff",
+	"beige":                "#f5f5dc",
+	"bisque":               "#ffe4c4",
+	"black":                "#000000",
+	"blanchedalmond":       "#ffebcd",
+	"blue":                 "#0000ff",
+	"blueviolet":           "#8a2be2",
+	"brown":                "#a52a2a",
+	"burlywood":            "#deb887",
+	"cadetblue":            "#5f9ea0",
+	"chartreuse":           "#7fff00",
+	"chocolate":            "#d2691e",
+	"coral":                "#ff7f50",
+	"cornflowerblue":       "#6495ed",
+	"cornsilk":             "#fff8dc",
+	"crimson":              "#dc143c",
+	"cyan":                 "#00ffff",
+	"darkblue":             "#00008b",
+	"darkcyan":             "#008b8b",
+	"darkgoldenrod":        "#b8860b",
+	"darkgray":             "#a9a9a9",
+	"darkgreen":            "#006400",
+	"darkgrey":             "#a9a9a9",
+	"darkkhaki":            "#bdb76b",
+	"darkmagenta":          "#8b008b",
+	"darkolivegreen":       "#556b2f",
+	"darkorange":           "#ff8c00",
+	"darkorchid":           "#9932cc",
+	"darkred":              "#8b0000",
+	"darksalmon":           "#e9967a",
+	"darkseagreen":         "#8fbc8f",
+	"darkslateblue":        "#483d8b",
+	"darkslategray":        "#2f4f4f",
+	"darkslategrey":        "#2f4f4f",
+	"darkturquoise":        "#00ced1",
+	"darkviolet":           "#9400d3",
+	"deeppink":             "#ff1493",
+	"deepskyblue":          "#00bfff",
+	"dimgray":              "#696969",
+	"dimgrey":              "#696969",
+	"dodgerblue":           "#1e90ff",
+	"firebrick":            "#b22222",
+	"floralwhite":          "#fffaf0",
+	"forestgreen":          "#228b22",
+	"fuchsia":              "#ff00ff",
+	"gainsboro":            "#dcdcdc",
+	"ghostwhite":           "#f8f8ff",
+	"gold":                 "#ffd700",
+	"goldenrod":            "#daa520",
+	"gray":                 "#808080",
+	"green":                "#008000",
+	"greenyellow":          "#adff2f",
+	"grey":                 "#808080",
+	"honeydew":             "#f0fff0",
+	"hotpink":              "#ff69b4",
+	"indianred":            "#cd5c5c",
+	"indigo":               "#4b0082",
+	"ivory":                "#fffff0",
+	"khaki":                "#f0e68c",
+	"lavender":             "#e6e6fa",
+	"lavenderblush":        "#fff0f5",
+	"lawngreen":            "#7cfc00",
+	"lemonchiffon":         "#fffacd",
+	"lightblue":            "#add8e6",
+	"lightcoral":           "#f08080",
+	"lightcyan":            "#e0ffff",
+	"lightgoldenrodyellow": "#fafad2",
+	"lightgray":            "#d3d3d3",
+	"lightgreen":           "#90ee90",
+	"lightgrey":            "#d3d3d3",
+	"lightpink":            "#ffb6c1",
+	"lightsalmon":          "#ffa07a",
+	"lightseagreen":        "#20b2aa",
+	"lightskyblue":         "#87cefa",
+	"lightslategray":       "#778899",
+	"lightslategrey":       "#778899",
+	"lightsteelblue":       "#b0c4de",
+	"lightyellow":          "#ffffe0",
+	"lime":                 "#00ff00",
+	"limegreen":            "#32cd32",
+	"linen":                "#faf0e6",
+	"magenta":              "#ff00ff",
+	"maroon":               "#800000",
+	"mediumaquamarine":     "#66cdaa",
+	"mediumblue":           "#0000cd",
+	"mediumorchid":         "#ba55d3",
+	"mediumpurple":         "#9370db",
+	"mediumseagreen":       "#3cb371",
+	"mediumslateblue":      "#7b68ee",
+	"mediumspringgreen":    "#00fa9a",
+	"mediumturquoise":      "#48d1cc",
+	"mediumvioletred":      "#c71585",
+	"midnightblue":         "#191970",
+	"mintcream":            "#f5fffa",
+	"mistyrose":            "#ffe4e1",
+	"moccasin":             "#ffe4b5",
+	"navajowhite":          "#ffdead",
+	"navy":                 "#000080",
+	"oldlace":              "#fdf5e6",
+	"olive":                "#808000",
+	"olivedrab":            "#6b8e23",
+	"orange":               "#ffa500",
+	"orangered":            "#ff4500",
+	"orchid":               "#da70d6",
+	"palegoldenrod":        "#eee8aa",
+	"palegreen":            "#98fb98",
+	"paleturquoise":        "#afeeee",
+	"palevioletred":        "#db7093",
+	"papayawhip":           "#ffefd5",
+	"peachpuff":            "#ffdab9",
+	"peru":                 "#cd853f",
+	"pink":                 "#ffc0cb",
+	"plum":                 "#dda0dd",
+	"powderblue":           "#b0e0e6",
+	"purple":               "#800080",
+	"rebeccapurple":        "#663399",
+	"red":                  "#ff0000",
+	"rosybrown":            "#bc8f8f",
+	"royalblue":            "#4169e1",
+	"saddlebrown":          "#8b4513",
+	"salmon":               "#fa8072",
+	"sandybrown":           "#f4a460",
+	"seagreen":             "#2e8b57",
+	"seashell":             "#fff5ee",
+	"sienna":               "#a0522d",
+	"silver":               "#c0c0c0",
+	"skyblue":              "#87ceeb",
+	"slateblue":            "#6a5acd",
+	"slategray":            "#708090",
+	"slategrey":            "#708090",
+	"snow":                 "#fffafa",
+	"springgreen":          "#00ff7f",
+	"steelblue":            "#4682b4",
+	"tan":                  "#d2b48c",
+	"teal":                 "#008080",
+	"thistle":              "#d8bfd8",
+	"tomato":               "#ff6347",
+	"turquoise":            "#40e0d0",
+	"violet":               "#ee82ee",
+	"wheat":                "#f5deb3",
+	"white":                "#ffffff",
+	"whitesmoke":           "#f5f5f5",
+	"yellow":               "#ffff00",
+	"yellowgreen":          "#9acd32",
+	"transparent":          "#00000000",
+}
+
+// parseColor parses the CSS colour 's' and returns a `color.NRGBA` instance whose alpha value is multiplied
+// by 'opacity'. Valid colours are hexadecimal colours (#rgb, #rgba, #rrggbb or #rrggbbaa), the CSS colour names
+// (including "transparent") and the rgb(), rgba(), hsl() and hsla() functions using either the legacy (comma separated)
+// or modern (space separated, with an optional "/ alpha" value) syntax.
+func parseColor(s string, opacity float64) (color.NRGBA, error) {
+
+	var c color.NRGBA
+
+	s = strings.ToLower(strings.TrimSpace(s))
+
+	hex, is_named := named_colors[s]
+
+	if is_named {
+		s = hex
+	}
+
+	name, args, is_func := strings.Cut(s, "(")
+
+	switch {
+	case strings.HasPrefix(s, "#"):
+
+		v, err := parseHexColor(s[1:])
+
+		if err != nil {
+			return c, fmt.Errorf("Invalid hexadecimal colour, '%s'", s)
+		}
+
+		c = v
+
+	case is_func && (name == "rgb" || name == "rgba"):
+
+		v, err := parseRGBColor(args)
+
+		if err != nil {
+			return c, fmt.Errorf("Invalid rgb colour, '%s', %w", s, err)
+		}
+
+		c = v
+
+	case is_func && (name == "hsl" || name == "hsla"):
+
+		v, err := parseHSLColor(args)
+
+		if err != nil {
+			return c, fmt.Errorf("Invalid hsl colour, '%s', %w", s, err)
+		}
+
+		c = v
+
+	default:
+		return c, fmt.Errorf("Unsupported colour, '%s'", s)
+	}
+
+	c.A = clampUint8(float64(c.A) * opacity)
+	return c, nil
+}
+
+// colorHex returns the hexadecimal representation of 'c' (#rrggbb or, if it is not opaque, #rrggbbaa).
+func colorHex(c color.NRGBA) string {
+
+	if c.A == 255 {
+		return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+	}
+
+	return fmt.Sprintf("#%02x%02x%02x%02x", c.R, c.G, c.B, c.A)
+}
+
+// parseHexColor parses the hexadecimal colour 'hex' (without a leading "#").
+func parseHexColor(hex string) (color.NRGBA, error) {
+
+	var c color.NRGBA
+
+	if len(hex) == 3 || len(hex) == 4 {
+
+		var expanded strings.Builder
+
+		for _, r := range hex {
+			expanded.WriteRune(r)
+			expanded.WriteRune(r)
+		}
+
+		hex = expanded.String()
+	}
+
+	if len(hex) == 6 {
+		hex = hex + "ff"
+	}
+
+	if len(hex) != 8 {
+		return c, fmt.Errorf("Invalid length")
+	}
+
+	v, err := strconv.ParseUint(hex, 16, 32)
+
+	if err != nil {
+		return c, fmt.Errorf("Invalid hexadecimal value")
+	}
+
+	c = color.NRGBA{R: uint8(v >> 24), G: uint8(v >> 16), B: uint8(v >> 8), A: uint8(v)}
+	return c, nil
+}
+
+// parseRGBColor parses the arguments 'args' (including the closing parenthesis) of a CSS rgb() or rgba() function. Each
+// channel may be a number between 0 and 255 or a percentage.
+func parseRGBColor(args string) (color.NRGBA, error) {
+
+	var c color.NRGBA
+
+	values, err := colorArguments(args)
+
+	if err != nil {
+		return c, err
+	}
+
+	channels := make([]uint8, 3)
+
+	for i, v := range values[:3] {
+
+		n, unit, err := parseColorNumber(v, "%")
+
+		if err != nil {
+			return c, err
+		}
+
+		if unit == "%" {
+			n = n * 255 / 100
+		}
+
+		channels[i] = clampUint8(n)
+	}
+
+	alpha, err := parseColorAlpha(values)
+
+	if err != nil {
+		return c, err
+	}
+
+	c = color.NRGBA{R: channels[0], G: channels[1], B: channels[2], A: alpha}
+	return c, nil
+}
+
+// parseHSLColor parses the arguments 'args' (including the closing parenthesis) of a CSS hsl() or hsla() function. The hue
+// may be a number of degrees or an angle using the "deg", "grad", "rad" or "turn" units and the saturation and lightness
+// are percentages.
+func parseHSLColor(args string) (color.NRGBA, error) {
+
+	var c color.NRGBA
+
+	values, err := colorArguments(args)
+
+	if err != nil {
+		return c, err
+	}
+
+	hue, unit, err := parseColorNumber(values[0], "deg", "grad", "rad", "turn")
+
+	if err != nil {
+		return c, err
+	}
+
+	switch unit {
+	case "grad":
+		hue = hue * 360 / 400
+	case "rad":
+		hue = hue * 180 / math.Pi
+	case "turn":
+		hue = hue * 360
+	}
+
+	sl := make([]float64, 2)
+
+	for i, v := range values[1:3] {
+
+		n, _, err := parseColorNumber(v, "%")
+
+		if err != nil {
+			return c, err
+		}
+
+		sl[i] = max(0, min(100, n)) / 100
+	}
+
+	alpha, err := parseColorAlpha(values)
+
+	if err != nil {
+		return c, err
+	}
+
+	r, g, b := hslToRGB(hue, sl[0], sl[1])
+
+	c = color.NRGBA{R: clampUint8(r * 255), G: clampUint8(g * 255), B: clampUint8(b * 255), A: alpha}
+	return c, nil
+}
+
+// colorArguments splits the arguments 'args' (including the closing parenthesis) of a CSS colour function in to a list of
+// three or four values. Arguments are either separated by commas or by spaces with an optional alpha value following a "/".
+func colorArguments(args string) ([]string, error) {
+
+	args, ok := strings.CutSuffix(strings.TrimSpace(args), ")")
+
+	if !ok {
+		return nil, fmt.Errorf("Missing closing parenthesis")
+	}
+
+	var values []string
+
+	if strings.Contains(args, ",") {
+
+		values = strings.Split(args, ",")
+
+		for i, v := range values {
+			values[i] = strings.TrimSpace(v)
+		}
+
+	} else {
+
+		channels, alpha, has_alpha := strings.Cut(args, "/")
+		values = strings.Fields(channels)
+
+		if has_alpha {
+			values = append(values, strings.TrimSpace(alpha))
+		}
+	}
+
+	if len(values) != 3 && len(values) != 4 {
+		return nil, fmt.Errorf("Expected three or four arguments")
+	}
+
+	return values, nil
+}
+
+// parseColorNumber parses the CSS colour function argument 'v' which may be followed by one of 'units'. It returns the
+// number and the unit (or an empty string if there is no unit).
+func parseColorNumber(v string, units ...string) (float64, string, error) {
+
+	unit := ""
+
+	for _, u := range units {
+
+		n, ok := strings.CutSuffix(v, u)
+
+		if ok {
+			v = n
+			unit = u
+			break
+		}
+	}
+
+	if !re_color_number.MatchString(v) {
+		return 0, "", fmt.Errorf("Invalid number, '%s'", v)
+	}
+
+	n, err := strconv.ParseFloat(v, 64)
+
+	if err != nil || math.IsInf(n, 0) {
+		return 0, "", fmt.Errorf("Invalid number, '%s'", v)
+	}
+
+	return n, unit, nil
+}
+
+// parseColorAlpha returns the alpha value (which may be a number between 0 and 1 or a percentage) of the CSS colour
+// function arguments 'values' or 255 if there is no alpha value.
+func parseColorAlpha(values []string) (uint8, error) {
+
+	if len(values) < 4 {
+		return 255, nil
+	}
+
+	n, unit, err := parseColorNumber(values[3], "%")
+
+	if err != nil {
+		return 0, err
+	}
+
+	if unit == "%" {
+		n = n / 100
+	}
+
+	return clampUint8(n * 255), nil
+}
+
+// hslToRGB converts the hue 'h' (in degrees), saturation 's' and lightness 'l' (both between 0 and 1) to red, green
+// and blue values between 0 and 1.
+func hslToRGB(h float64, s float64, l float64) (float64, float64, float64) {
+
+	h = math.Mod(h, 360)
+
+	if h < 0 {
+		h += 360
+	}
+
+	f := func(n float64) float64 {
+		k := math.Mod(n+h/30, 12)
+		a := s * min(l, 1-l)
+		return l - a*max(-1, min(k-3, 9-k, 1))
+	}
+
+	return f(0), f(8), f(4)
+}
+
+func clampUint8(v float64) uint8 {
+	return uint8(max(0, min(255, math.Round(v))))
+}
@@ -0,0 +1,75 @@
+package show
+
+import (
+	"image/color"
+	"testing"
+)
+
+func TestParseColor(t *testing.T) {
+
+	tests := []struct {
+		color    string
+		opacity  float64
+		expected color.NRGBA
+		ok       bool
+	}{
+		{"#f00", 1, color.NRGBA{255, 0, 0, 255}, true},
+		{"#f008", 1, color.NRGBA{255, 0, 0, 136}, true},
+		{"#3388FF", 1, color.NRGBA{51, 136, 255, 255}, true},
+		{"#3388ff80", 1, color.NRGBA{51, 136, 255, 128}, true},
+		{"#3388ff", 0.5, color.NRGBA{51, 136, 255, 128}, true},
+		{"red", 1, color.NRGBA{255, 0, 0, 255}, true},
+		{" CornflowerBlue ", 1, color.NRGBA{100, 149, 237, 255}, true},
+		{"rebeccapurple", 1, color.NRGBA{102, 51, 153, 255}, true},
+		{"lightgoldenrodyellow", 1, color.NRGBA{250, 250, 210, 255}, true},
+		{"transparent", 1, color.NRGBA{0, 0, 0, 0}, true},
+		{"rgb(255, 0, 0)", 1, color.NRGBA{255, 0, 0, 255}, true},
+		{"rgba(255, 0, 0, 0.5)", 1, color.NRGBA{255, 0, 0, 128}, true},
+		{"rgb(100%, 50%, 0%)", 1, color.NRGBA{255, 128, 0, 255}, true},
+		{"rgb(255 0 0 / 50%)", 1, color.NRGBA{255, 0, 0, 128}, true},
+		{"rgb(300, -10, 0)", 1, color.NRGBA{255, 0, 0, 255}, true},
+		{"hsl(0, 100%, 50%)", 1, color.NRGBA{255, 0, 0, 255}, true},
+		{"hsl(120deg 100% 25%)", 1, color.NRGBA{0, 128, 0, 255}, true},
+		{"hsl(240, 100%, 50%)", 1, color.NRGBA{0, 0, 255, 255}, true},
+		{"hsl(0.5turn 100% 50%)", 1, color.NRGBA{0, 255, 255, 255}, true},
+		{"hsl(-120, 100%, 50%)", 1, color.NRGBA{0, 0, 255, 255}, true},
+		{"hsla(0, 0%, 100%, 0.25)", 1, color.NRGBA{255, 255, 255, 64}, true},
+		{"hsl(0 0% 0% / 100%)", 1, color.NRGBA{0, 0, 0, 255}, true},
+		{"#ff", 1, color.NRGBA{}, false},
+		{"#gggggg", 1, color.NRGBA{}, false},
+		{"rgb(255, 0)", 1, color.NRGBA{}, false},
+		{"rgb(255, 0, 0", 1, color.NRGBA{}, false},
+		{"rgb(Inf, 0, 0)", 1, color.NRGBA{}, false},
+		{"rgb(NaN, 0, 0)", 1, color.NRGBA{}, false},
+		{"rgb(0x10, 0, 0)", 1, color.NRGBA{}, false},
+		{"hsl(120px, 100%, 50%)", 1, color.NRGBA{}, false},
+		{"url(#gradient)", 1, color.NRGBA{}, false},
+		{"notacolour", 1, color.NRGBA{}, false},
+		{"", 1, color.NRGBA{}, false},
+	}
+
+	for _, test := range tests {
+
+		t.Run(test.color, func(t *testing.T) {
+
+			c, err := parseColor(test.color, test.opacity)
+
+			if !test.ok {
+
+				if err == nil {
+					t.Fatalf("Expected '%s' to fail to parse, got %v", test.color, c)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Failed to parse '%s', %v", test.color, err)
+			}
+
+			if c != test.expected {
+				t.Fatalf("Expected %v, got %v", test.expected, c)
+			}
+		})
+	}
+}
@@ -11,6 +11,46 @@ type mapConfig struct {
 	Style           *LeafletStyle    `json:"style,omitempty"`
 	PointStyle      *LeafletStyle    `json:"point_style,omitempty"`
 	LabelProperties []string         `json:"label_properties"`
+	// Optional label configuration details. If present the web application will display a popup containing the
+	// (server-rendered) label for features when they are clicked.
+	Labels *labelsConfig `json:"labels,omitempty"`
+	// Optional configuration details for drawing permanent text labels for features on the map.
+	MapLabels *mapLabelsConfig `json:"map_labels,omitempty"`
+	// Optional styles merged on top of Style for line and polygon geometries.
+	LineStyle    *LeafletStyle `json:"line_style,omitempty"`
+	PolygonStyle *LeafletStyle `json:"polygon_style,omitempty"`
+	// Optional style applied to features when the pointer is over them.
+	HoverStyle *LeafletStyle `json:"hover_style,omitempty"`
+	// The style applied to the currently selected feature.
+	SelectedStyle *LeafletStyle `json:"selected_style,omitempty"`
+	// Optional vector tile configuration details. If present the web application will render
+	// features using vector tiles rather than fetching all the features at once.
+	VectorTiles *vectorTilesConfig `json:"vector_tiles,omitempty"`
+	// Optional raster tile configuration details. If present the web application will render features
+	// using raster tiles rendered by the server.
+	Raster *rasterConfig `json:"raster,omitempty"`
+	// Optional cluster configuration details. If present the web application will render point features
+	// as clusters, requested for the current map viewport and zoom level.
+	Clusters *clustersConfig `json:"clusters,omitempty"`
+	// Optional simplification configuration details. If present the web application will request
+	// simplified geometries for the current zoom level.
+	Simplify *simplifyConfig `json:"simplify,omitempty"`
+	// If true the web application will request features without properties (other than label properties)
+	// and fetch the complete record for individual features on demand.
+	LazyProperties bool `json:"lazy_properties,omitempty"`
+	// If true the web application will only request the features inside the current map viewport.
+	ViewportQueries bool `json:"viewport_queries,omitempty"`
+	// Configuration details for aggregating point features in to hexagonal or square cells.
+	Aggregate *aggregateConfig `json:"aggregate,omitempty"`
+	// Optional details about the classes, and their colours, used to colour features by property value. If present
+	// the web application will draw a legend.
+	Choropleth *choroplethConfig `json:"choropleth,omitempty"`
+	// Details about the icons that can be used to render point features.
+	Icons *iconConfig `json:"icons,omitempty"`
+	// The transport used to request features. Valid options are: geojson, geobuf.
+	Transport string `json:"transport"`
+	// The bounding box (minx, miny, maxx, maxy) of all the features being served.
+	Bounds []float64 `json:"bounds,omitempty"`
 }
 
 // protomapsConfig defines configuration details for maps using Protomaps.
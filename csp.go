@@ -0,0 +1,98 @@
+package show
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// The Content-Security-Policy source expressions that can be used as image hosts: a scheme ("https:" or "http:") or a
+// host, with an optional scheme, "*." subdomain wildcard and port.
+var re_image_host = regexp.MustCompile(`^(?:https?:|(?:https?://)?(?:\*\.)?[a-zA-Z0-9-]+(?:\.[a-zA-Z0-9-]+)*(?::(?:[0-9]+|\*))?)$`)
+
+// The Content-Security-Policy for pages, like the datasets index, which do not load any scripts, styles or images.
+const static_content_security_policy string = "default-src 'none'; base-uri 'none'; form-action 'none'"
+
+// contentSecurityPolicy returns the (restrictive) Content-Security-Policy for the web application displaying map tiles from
+// 'tile_url'. Scripts, stylesheets, fonts and requests are limited to the same origin as the web application, and the origin
+// of 'tile_url' if it is an absolute URL, so that markup in feature properties or labels can not load or run scripts from
+// anywhere else. Images are limited to the same sources, and the (validated) Content-Security-Policy source expressions in
+// 'image_hosts', so that label thumbnails from other hosts are blocked unless they are listed explicitly. Compiling
+// WebAssembly (used to format the raw GeoJSON for features) is allowed, as are inline styles (used by Leaflet). Plugins,
+// forms and <base> elements are disallowed.
+func contentSecurityPolicy(tile_url string, image_hosts []string) string {
+
+	img_src := []string{"'self'", "data:", "blob:"}
+	connect_src := []string{"'self'"}
+
+	tile_src := tileSource(tile_url)
+
+	if tile_src != "" {
+		img_src = append(img_src, tile_src)
+		connect_src = append(connect_src, tile_src)
+	}
+
+	for _, host := range image_hosts {
+
+		if !slices.Contains(img_src, host) {
+			img_src = append(img_src, host)
+		}
+	}
+
+	directives := []string{
+		"default-src 'self'",
+		"script-src 'self' 'wasm-unsafe-eval'",
+		"style-src 'self' 'unsafe-inline'",
+		"img-src " + strings.Join(img_src, " "),
+		"connect-src " + strings.Join(connect_src, " "),
+		"font-src 'self' data:",
+		"object-src 'none'",
+		"base-uri 'none'",
+		"form-action 'none'",
+	}
+
+	return strings.Join(directives, "; ")
+}
+
+// tileSource returns the Content-Security-Policy source expression for the origin of the (absolute) tile URL template
+// 'tile_url', or an empty string if 'tile_url' is relative. Leaflet "{s}" subdomain placeholders are replaced by
+// a wildcard and hosts containing any other placeholders are reduced to their scheme.
+func tileSource(tile_url string) string {
+
+	scheme, rest, ok := strings.Cut(tile_url, "://")
+
+	if !ok {
+		return ""
+	}
+
+	scheme = strings.ToLower(scheme)
+
+	if scheme != "http" && scheme != "https" {
+		return ""
+	}
+
+	host, _, _ := strings.Cut(rest, "/")
+	host, _, _ = strings.Cut(host, "?")
+
+	if strings.HasPrefix(host, "{s}.") {
+		host = "*" + strings.TrimPrefix(host, "{s}")
+	}
+
+	if host == "" || strings.ContainsAny(host, "{}; '\"") {
+		return scheme + ":"
+	}
+
+	return scheme + "://" + host
+}
+
+// validateImageHost returns an error if 'host' is not a Content-Security-Policy source expression that can be used to
+// allow images from a remote host. Hosts may not contain paths, quotes or separators so they can't alter the policy.
+func validateImageHost(host string) error {
+
+	if !re_image_host.MatchString(host) {
+		return fmt.Errorf("'%s' is not a valid host, expected a value like 'https://images.example.com'", host)
+	}
+
+	return nil
+}
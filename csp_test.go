@@ -0,0 +1,178 @@
+package show
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTileSource(t *testing.T) {
+
+	tests := []struct {
+		tile_url string
+		expected string
+	}{
+		{"https://tile.openstreetmap.org/{z}/{x}/{y}.png", "https://tile.openstreetmap.org"},
+		{"https://{s}.tile.openstreetmap.org/{z}/{x}/{y}.png", "https://*.tile.openstreetmap.org"},
+		{"HTTP://tiles.example.com:8080/{z}/{x}/{y}.png", "http://tiles.example.com:8080"},
+		{"https://tiles.example.com?key=abc", "https://tiles.example.com"},
+		{"https://tiles-{r}.example.com/{z}/{x}/{y}.png", "https:"},
+		{"https://tiles.example.com';script-src */{z}/{x}/{y}.png", "https:"},
+		{"tiles/{z}/{x}/{y}.png", ""},
+		{"file:///usr/local/data/tiles.pmtiles", ""},
+		{"javascript://tiles.example.com", ""},
+	}
+
+	for _, test := range tests {
+
+		t.Run(test.tile_url, func(t *testing.T) {
+
+			src := tileSource(test.tile_url)
+
+			if src != test.expected {
+				t.Fatalf("Expected '%s', got '%s'", test.expected, src)
+			}
+		})
+	}
+}
+
+func TestContentSecurityPolicy(t *testing.T) {
+
+	tests := []struct {
+		tile_url    string
+		image_hosts []string
+		includes    []string
+	}{
+		{
+			tile_url: "https://tile.openstreetmap.org/{z}/{x}/{y}.png",
+			includes: []string{
+				"img-src 'self' data: blob: https://tile.openstreetmap.org;",
+				"connect-src 'self' https://tile.openstreetmap.org;",
+			},
+		},
+		{
+			tile_url: "tiles/{z}/{x}/{y}.png",
+			includes: []string{
+				"img-src 'self' data: blob:;",
+				"connect-src 'self';",
+			},
+		},
+		{
+			tile_url:    "https://tile.openstreetmap.org/{z}/{x}/{y}.png",
+			image_hosts: []string{"https://images.example.com", "https://tile.openstreetmap.org"},
+			includes: []string{
+				"img-src 'self' data: blob: https://tile.openstreetmap.org https://images.example.com;",
+				"connect-src 'self' https://tile.openstreetmap.org;",
+			},
+		},
+		{
+			tile_url:    "tiles/{z}/{x}/{y}.png",
+			image_hosts: []string{"https:"},
+			includes: []string{
+				"img-src 'self' data: blob: https:;",
+			},
+		},
+	}
+
+	for _, test := range tests {
+
+		t.Run(test.tile_url, func(t *testing.T) {
+
+			csp := contentSecurityPolicy(test.tile_url, test.image_hosts)
+
+			for _, directive := range append(test.includes, "script-src 'self' 'wasm-unsafe-eval'", "object-src 'none'", "base-uri 'none'") {
+
+				if !strings.Contains(csp, directive) {
+					t.Fatalf("Expected policy to include \"%s\", got %s", directive, csp)
+				}
+			}
+
+			if strings.Contains(csp, "'unsafe-eval'") {
+				t.Fatalf("Policy allows eval, %s", csp)
+			}
+		})
+	}
+}
+
+func TestValidateImageHost(t *testing.T) {
+
+	tests := []struct {
+		host string
+		ok   bool
+	}{
+		{"https://images.example.com", true},
+		{"https://*.example.com", true},
+		{"http://localhost:8080", true},
+		{"images.example.com", true},
+		{"https:", true},
+		{"", false},
+		{"*", false},
+		{"data:", false},
+		{"https://images.example.com/thumbnails/", false},
+		{"https://images.example.com; script-src *", false},
+		{"'unsafe-inline'", false},
+		{"https://images.example.com https://other.example.com", false},
+	}
+
+	for _, test := range tests {
+
+		t.Run(test.host, func(t *testing.T) {
+
+			err := validateImageHost(test.host)
+
+			if (err == nil) != test.ok {
+				t.Fatalf("Expected valid to be %t, got %v", test.ok, err)
+			}
+		})
+	}
+}
+
+func TestHandlerContentSecurityPolicy(t *testing.T) {
+
+	ctx := context.Background()
+
+	tests := []struct {
+		name        string
+		policy      string
+		image_hosts []string
+		expected    string
+	}{
+		{"default", "", nil, contentSecurityPolicy(leaflet_osm_tile_url, nil)},
+		{"image hosts", "", []string{"https://images.example.com"}, contentSecurityPolicy(leaflet_osm_tile_url, []string{"https://images.example.com"})},
+		{"invalid image host", "", []string{"https://images.example.com; script-src *"}, ""},
+		{"custom", "default-src 'self'", nil, "default-src 'self'"},
+	}
+
+	for _, test := range tests {
+
+		t.Run(test.name, func(t *testing.T) {
+
+			opts := testRunOptions()
+			opts.ContentSecurityPolicy = test.policy
+			opts.ImageHosts = test.image_hosts
+
+			h, err := NewHandler(ctx, opts)
+
+			if test.expected == "" {
+
+				if err == nil {
+					t.Fatalf("Expected invalid image host to fail")
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Failed to create handler, %v", err)
+			}
+
+			rsp := httptest.NewRecorder()
+			h.ServeHTTP(rsp, httptest.NewRequest("GET", "/", nil))
+
+			if rsp.Header().Get("Content-Security-Policy") != test.expected {
+				t.Fatalf("Expected policy %s, got %s", test.expected, rsp.Header().Get("Content-Security-Policy"))
+			}
+		})
+	}
+}
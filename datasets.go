@@ -0,0 +1,189 @@
+package show
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/paulmach/orb/geojson"
+)
+
+// The path prefix that named datasets are served from by `RunWithOptions`.
+const datasets_prefix string = "/maps"
+
+var re_dataset_name = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_\-\.]*$`)
+
+// The names which can not be assigned to datasets because they are served by `Datasets` itself.
+var reserved_dataset_names = []string{
+	"datasets.json",
+}
+
+var datasets_index_t = template.Must(template.New("index").Parse(`<html>
+    <head>
+	<title>Datasets</title>
+    </head>
+    <body>
+	<ul>
+	{{ range $name := . }}
+	    <li><a href="{{ $name }}/">{{ $name }}</a></li>
+	{{ end }}
+	</ul>
+    </body>
+</html>
+`))
+
+// Datasets is an `http.Handler` for serving multiple, independent, named datasets from a single web server. Each
+// dataset is defined by its own `RunOptions` (features and map configuration) and is served under a "/{name}/" path
+// relative to wherever the `Datasets` instance is mounted. An index of all the datasets is served from "/" and
+// "/datasets.json".
+type Datasets struct {
+	mu       *sync.RWMutex
+	handlers map[string]*Handler
+}
+
+// NewDatasets returns a new (empty) `Datasets` instance.
+func NewDatasets() *Datasets {
+
+	d := &Datasets{
+		mu:       new(sync.RWMutex),
+		handlers: make(map[string]*Handler),
+	}
+
+	return d
+}
+
+// Add registers a new dataset named 'name' derived from 'opts'. If a dataset with the same name already
+// exists it will be replaced. Dataset names may only contain letters, numbers, "-", "_" and "." characters and
+// may not be "datasets.json".
+func (d *Datasets) Add(ctx context.Context, name string, opts *RunOptions) error {
+
+	if !re_dataset_name.MatchString(name) {
+		return fmt.Errorf("Invalid dataset name, '%s'", name)
+	}
+
+	if slices.Contains(reserved_dataset_names, name) {
+		return fmt.Errorf("Invalid dataset name, '%s' is reserved", name)
+	}
+
+	h, err := NewHandler(ctx, opts)
+
+	if err != nil {
+		return fmt.Errorf("Failed to create handler for dataset '%s', %w", name, err)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.handlers[name] = h
+	return nil
+}
+
+// AddFeatures appends 'features' to the dataset named 'name'.
+func (d *Datasets) AddFeatures(ctx context.Context, name string, features ...*geojson.Feature) error {
+
+	d.mu.RLock()
+	h, exists := d.handlers[name]
+	d.mu.RUnlock()
+
+	if !exists {
+		return fmt.Errorf("Unknown dataset, '%s'", name)
+	}
+
+	return h.AddFeatures(ctx, features...)
+}
+
+// Remove unregisters the dataset named 'name'.
+func (d *Datasets) Remove(name string) {
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	delete(d.handlers, name)
+}
+
+// Names returns the sorted list of dataset names currently registered.
+func (d *Datasets) Names() []string {
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	names := make([]string, 0, len(d.handlers))
+
+	for n, _ := range d.handlers {
+		names = append(names, n)
+	}
+
+	slices.Sort(names)
+	return names
+}
+
+// ServeHTTP routes requests to the handler for the dataset named in the first segment of the request path.
+func (d *Datasets) ServeHTTP(rsp http.ResponseWriter, req *http.Request) {
+
+	path := strings.TrimLeft(req.URL.Path, "/")
+
+	switch path {
+	case "":
+		d.serveIndex(rsp, req)
+		return
+	case "datasets.json":
+		d.serveIndexJSON(rsp, req)
+		return
+	}
+
+	name, _, has_slash := strings.Cut(path, "/")
+
+	d.mu.RLock()
+	h, exists := d.handlers[name]
+	d.mu.RUnlock()
+
+	if !exists {
+		http.NotFound(rsp, req)
+		return
+	}
+
+	// All the URLs requested by the web application are relative so make sure
+	// there is a trailing slash after the dataset name.
+
+	if !has_slash {
+		rsp.Header().Set("Location", name+"/")
+		rsp.WriteHeader(http.StatusMovedPermanently)
+		return
+	}
+
+	prefix := fmt.Sprintf("/%s", name)
+	http.StripPrefix(prefix, h).ServeHTTP(rsp, req)
+}
+
+func (d *Datasets) serveIndex(rsp http.ResponseWriter, req *http.Request) {
+
+	rsp.Header().Set("Content-type", "text/html")
+	rsp.Header().Set("Content-Security-Policy", static_content_security_policy)
+
+	err := datasets_index_t.Execute(rsp, d.Names())
+
+	if err != nil {
+		slog.Error("Failed to render datasets index", "error", err)
+		http.Error(rsp, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+func (d *Datasets) serveIndexJSON(rsp http.ResponseWriter, req *http.Request) {
+
+	rsp.Header().Set("Content-type", "application/json")
+
+	enc := json.NewEncoder(rsp)
+	err := enc.Encode(d.Names())
+
+	if err != nil {
+		slog.Error("Failed to encode datasets index", "error", err)
+		http.Error(rsp, "Internal server error", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,69 @@
+package show
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+)
+
+func TestDatasetsAdd(t *testing.T) {
+
+	ctx := context.Background()
+
+	tests := []struct {
+		name  string
+		valid bool
+	}{
+		{"postcards", true},
+		{"sfomuseum-data.architecture_1", true},
+		{"datasets.json", false},
+		{".hidden", false},
+		{"", false},
+		{"a/b", false},
+		{"a b", false},
+	}
+
+	d := NewDatasets()
+
+	for _, test := range tests {
+
+		t.Run(test.name, func(t *testing.T) {
+
+			opts := &RunOptions{
+				Features: []*geojson.Feature{geojson.NewFeature(orb.Point{-122.38, 37.62})},
+			}
+
+			err := d.Add(ctx, test.name, opts)
+
+			if test.valid && err != nil {
+				t.Fatalf("Expected %s to be a valid dataset name, %v", test.name, err)
+			}
+
+			if !test.valid && err == nil {
+				t.Fatalf("Expected %s to be an invalid dataset name", test.name)
+			}
+		})
+	}
+
+	// Reserved names are served by Datasets itself and only valid datasets are listed in the index
+
+	rsp := httptest.NewRecorder()
+	d.ServeHTTP(rsp, httptest.NewRequest("GET", "/datasets.json", nil))
+
+	var names []string
+
+	err := json.Unmarshal(rsp.Body.Bytes(), &names)
+
+	if err != nil {
+		t.Fatalf("Failed to decode datasets index, %v", err)
+	}
+
+	if !slices.Equal(names, []string{"postcards", "sfomuseum-data.architecture_1"}) {
+		t.Fatalf("Unexpected datasets index, %v", names)
+	}
+}
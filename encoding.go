@@ -0,0 +1,324 @@
+package show
+
+import (
+	"bytes"
+	"compress/gzip"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+)
+
+// The maximum number of encoded responses to cache for each version of a feature collection.
+const response_cache_size int = 32
+
+// The compression level used for Brotli-encoded responses.
+const brotli_level int = 6
+
+// encodedBody is a response body, its ETag and its compressed variants which are derived on demand.
+type encodedBody struct {
+	mu           *sync.Mutex
+	etag         string
+	content_type string
+	// compress is false for content types, like images, which are already compressed.
+	compress bool
+	variants map[string][]byte
+}
+
+// newEncodedBody returns a new `encodedBody` instance for 'body' with a (weak) ETag derived from its contents.
+func newEncodedBody(body []byte, content_type string) *encodedBody {
+
+	sum := sha256.Sum256(body)
+	etag := fmt.Sprintf(`W/"%s"`, hex.EncodeToString(sum[:16]))
+
+	b := &encodedBody{
+		mu:           new(sync.Mutex),
+		etag:         etag,
+		content_type: content_type,
+		compress:     !strings.HasPrefix(content_type, "image/"),
+		variants: map[string][]byte{
+			"": body,
+		},
+	}
+
+	return b
+}
+
+// Variant returns the body encoded using 'encoding' which may be "br", "gzip" or "" (no encoding).
+func (b *encodedBody) Variant(encoding string) ([]byte, error) {
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	v, exists := b.variants[encoding]
+
+	if exists {
+		return v, nil
+	}
+
+	var buf bytes.Buffer
+	var err error
+
+	switch encoding {
+	case "br":
+
+		wr := brotli.NewWriterLevel(&buf, brotli_level)
+		_, err = wr.Write(b.variants[""])
+
+		if err == nil {
+			err = wr.Close()
+		}
+
+	case "gzip":
+
+		wr := gzip.NewWriter(&buf)
+		_, err = wr.Write(b.variants[""])
+
+		if err == nil {
+			err = wr.Close()
+		}
+
+	default:
+		return nil, fmt.Errorf("Unsupported encoding, '%s'", encoding)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to encode body, %w", err)
+	}
+
+	v = buf.Bytes()
+	b.variants[encoding] = v
+
+	return v, nil
+}
+
+// ServeHTTP writes the variant of 'b' best suited to the request's "Accept-Encoding" header to 'rsp'. If the request's
+// "If-None-Match" header matches the ETag for 'b' a "304 Not Modified" response is sent instead.
+func (b *encodedBody) ServeHTTP(rsp http.ResponseWriter, req *http.Request) {
+
+	rsp.Header().Set("ETag", b.etag)
+	rsp.Header().Set("Vary", "Accept-Encoding")
+
+	if matchesETag(req.Header.Get("If-None-Match"), b.etag) {
+		rsp.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	encoding := ""
+
+	if b.compress {
+		encoding = negotiateEncoding(req.Header.Get("Accept-Encoding"))
+	}
+
+	body, err := b.Variant(encoding)
+
+	if err != nil {
+		slog.Error("Failed to encode response", "encoding", encoding, "error", err)
+		encoding = ""
+		body, _ = b.Variant(encoding)
+	}
+
+	rsp.Header().Set("Content-type", b.content_type)
+
+	if encoding != "" {
+		rsp.Header().Set("Content-Encoding", encoding)
+	}
+
+	rsp.Header().Set("Content-Length", strconv.Itoa(len(body)))
+
+	if req.Method == http.MethodHead {
+		return
+	}
+
+	rsp.Write(body)
+}
+
+// negotiateEncoding returns the preferred encoding ("br", "gzip" or "") supported by an "Accept-Encoding" header value
+// following RFC 9110 (section 12.5.3). Encodings are chosen by their quality values, preferring Brotli to gzip when they
+// are equal, and a "*" entry applies to any encoding which isn't listed explicitly. If neither "br" or "gzip" is acceptable
+// the response is not encoded, even if "identity" has been excluded (using "identity;q=0" or "*;q=0"), since RFC 9110
+// allows servers to disregard the header rather than sending a "406 Not Acceptable" response.
+func negotiateEncoding(accept string) string {
+
+	qualities := map[string]float64{}
+
+	for _, part := range strings.Split(accept, ",") {
+
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+
+		if name == "" {
+			continue
+		}
+
+		q := 1.0
+
+		for _, param := range strings.Split(params, ";") {
+
+			k, v, _ := strings.Cut(strings.TrimSpace(param), "=")
+
+			if strings.ToLower(strings.TrimSpace(k)) != "q" {
+				continue
+			}
+
+			v_q, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+
+			if err == nil {
+				q = max(0, min(1, v_q))
+			}
+		}
+
+		qualities[name] = q
+	}
+
+	quality := func(name string) float64 {
+
+		q, exists := qualities[name]
+
+		if exists {
+			return q
+		}
+
+		q, exists = qualities["*"]
+
+		if exists {
+			return q
+		}
+
+		return 0
+	}
+
+	encoding := ""
+	best := 0.0
+
+	for _, name := range []string{"br", "gzip"} {
+
+		q := quality(name)
+
+		if q > best {
+			encoding = name
+			best = q
+		}
+	}
+
+	return encoding
+}
+
+// matchesETag returns true if the "If-None-Match" header value 'if_none_match' matches 'etag' using weak comparison.
+func matchesETag(if_none_match string, etag string) bool {
+
+	if if_none_match == "" {
+		return false
+	}
+
+	etag = strings.TrimPrefix(etag, "W/")
+
+	for _, candidate := range strings.Split(if_none_match, ",") {
+
+		candidate = strings.TrimSpace(candidate)
+
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// responseCache is a thread-safe, least recently used, cache of `encodedBody` instances for a given version of a feature
+// collection. It holds a fixed number of bodies and is emptied whenever a newer version is cached.
+type responseCache struct {
+	mu      *sync.Mutex
+	version int64
+	// size is the maximum number of bodies to cache.
+	size int
+	// order is the list of cached entries, most recently used first.
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+// responseCacheEntry is an individual `encodedBody` in a `responseCache`.
+type responseCacheEntry struct {
+	key  string
+	body *encodedBody
+}
+
+// newResponseCache returns a new `responseCache` instance holding up to `response_cache_size` bodies.
+func newResponseCache() *responseCache {
+	return newSizedResponseCache(response_cache_size)
+}
+
+// newSizedResponseCache returns a new `responseCache` instance holding up to 'size' bodies.
+func newSizedResponseCache(size int) *responseCache {
+
+	c := &responseCache{
+		mu:      new(sync.Mutex),
+		size:    size,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+
+	return c
+}
+
+// Get returns the `encodedBody` for 'key' if it was cached for 'version'.
+func (c *responseCache) Get(version int64, key string) (*encodedBody, bool) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.version != version {
+		return nil, false
+	}
+
+	el, exists := c.entries[key]
+
+	if !exists {
+		return nil, false
+	}
+
+	c.order.MoveToFront(el)
+	return el.Value.(*responseCacheEntry).body, true
+}
+
+// Set caches 'b' as the `encodedBody` for 'key' for 'version', evicting the least recently used body if the
+// cache is full. Bodies for versions older than the version currently being cached are discarded so that a slow
+// request can't replace the cache for a newer version.
+func (c *responseCache) Set(version int64, key string, b *encodedBody) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if version < c.version {
+		return
+	}
+
+	if version > c.version {
+		c.version = version
+		c.order.Init()
+		c.entries = make(map[string]*list.Element)
+	}
+
+	el, exists := c.entries[key]
+
+	if exists {
+		el.Value.(*responseCacheEntry).body = b
+		c.order.MoveToFront(el)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&responseCacheEntry{key: key, body: b})
+
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*responseCacheEntry).key)
+	}
+}
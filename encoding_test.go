@@ -0,0 +1,120 @@
+package show
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestResponseCache(t *testing.T) {
+
+	c := newResponseCache()
+
+	for i := 0; i < response_cache_size; i++ {
+		c.Set(1, fmt.Sprintf("key-%d", i), newEncodedBody([]byte("body"), "text/plain"))
+	}
+
+	// Use the oldest entry so that the second oldest entry is evicted instead
+
+	_, exists := c.Get(1, "key-0")
+
+	if !exists {
+		t.Fatalf("Expected key-0 to be cached")
+	}
+
+	c.Set(1, "key-new", newEncodedBody([]byte("body"), "text/plain"))
+
+	tests := []struct {
+		key    string
+		cached bool
+	}{
+		{"key-0", true},
+		{"key-1", false},
+		{"key-2", true},
+		{"key-new", true},
+	}
+
+	for _, test := range tests {
+
+		_, exists := c.Get(1, test.key)
+
+		if exists != test.cached {
+			t.Fatalf("Expected %s cached to be %t", test.key, test.cached)
+		}
+	}
+
+	if c.order.Len() != response_cache_size || len(c.entries) != response_cache_size {
+		t.Fatalf("Expected cache to contain %d entries, got %d", response_cache_size, c.order.Len())
+	}
+
+	// Changing the version empties the cache
+
+	_, exists = c.Get(2, "key-0")
+
+	if exists {
+		t.Fatalf("Expected key-0 not to be cached for a new version")
+	}
+
+	c.Set(2, "key-0", newEncodedBody([]byte("body"), "text/plain"))
+
+	if c.order.Len() != 1 {
+		t.Fatalf("Expected cache to contain a single entry, got %d", c.order.Len())
+	}
+}
+
+func TestResponseCacheStaleVersion(t *testing.T) {
+
+	c := newResponseCache()
+
+	c.Set(2, "key-new", newEncodedBody([]byte("new"), "text/plain"))
+
+	// A response produced for an older version must not replace the cache for a newer one
+
+	c.Set(1, "key-old", newEncodedBody([]byte("old"), "text/plain"))
+
+	_, exists := c.Get(2, "key-new")
+
+	if !exists {
+		t.Fatalf("Expected key-new to be cached for version 2")
+	}
+
+	_, exists = c.Get(1, "key-old")
+
+	if exists {
+		t.Fatalf("Expected key-old not to be cached for version 1")
+	}
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+
+	tests := []struct {
+		accept   string
+		expected string
+	}{
+		{"", ""},
+		{"gzip, deflate, br", "br"},
+		{"gzip", "gzip"},
+		{"br;q=0.5, gzip", "gzip"},
+		{"br;q=0, gzip;q=0", ""},
+		{"GZIP;Q=0.8", "gzip"},
+		{"*", "br"},
+		{"*;q=0.5, br;q=0.1", "gzip"},
+		{"*;q=0", ""},
+		{"identity", ""},
+		{"identity;q=0", ""},
+		{"identity;q=0, *;q=0.5", "br"},
+		{"deflate", ""},
+		{"gzip;q=bogus", "gzip"},
+	}
+
+	for _, test := range tests {
+
+		t.Run(test.accept, func(t *testing.T) {
+
+			encoding := negotiateEncoding(test.accept)
+
+			if encoding != test.expected {
+				t.Fatalf("Expected '%s', got '%s'", test.expected, encoding)
+			}
+		})
+	}
+}
@@ -0,0 +1,200 @@
+package show
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+)
+
+// featureCollection is a thread-safe container for the features served by a `Handler`. Features added to
+// the collection are passed through its list of `FeatureTransformer` instances first.
+type featureCollection struct {
+	mu           *sync.RWMutex
+	features     []*geojson.Feature
+	transformers []FeatureTransformer
+	// id_property is the (optional) name of the property used to derive stable identifiers for features.
+	id_property string
+	// ids is the list of stable identifiers for each feature in the collection.
+	ids []string
+	// offsets is a lookup table of stable identifiers and their offset in the collection.
+	offsets map[string]int
+	// bound is the union of the bounding boxes for all the features in the collection.
+	bound *orb.Bound
+	// index is a spatial index of the bounding boxes for all the features in the collection.
+	index *spatialIndex
+	// version is incremented every time the list of features changes.
+	version int64
+}
+
+func newFeatureCollection(ctx context.Context, features []*geojson.Feature, transformers []FeatureTransformer, id_property string) (*featureCollection, error) {
+
+	c := &featureCollection{
+		mu:           new(sync.RWMutex),
+		features:     make([]*geojson.Feature, 0),
+		transformers: transformers,
+		id_property:  id_property,
+		ids:          make([]string, 0),
+		offsets:      make(map[string]int),
+		index:        newSpatialIndex(nil, nil),
+	}
+
+	err := c.Add(ctx, features...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Add transforms 'features' and appends the results to the collection.
+func (c *featureCollection) Add(ctx context.Context, features ...*geojson.Feature) error {
+
+	features, err := TransformFeatures(ctx, features, c.transformers...)
+
+	if err != nil {
+		return fmt.Errorf("Failed to transform features, %w", err)
+	}
+
+	ids := make([]string, len(features))
+
+	for i, f := range features {
+
+		id, err := featureId(f, c.id_property)
+
+		if err != nil {
+			return fmt.Errorf("Failed to derive identifier for feature, %w", err)
+		}
+
+		ids[i] = id
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, id := range ids {
+
+		// Identifiers must be unique so disambiguate duplicates with a numeric suffix.
+
+		unique_id := id
+
+		for i := 2; ; i++ {
+
+			_, exists := c.offsets[unique_id]
+
+			if !exists {
+				break
+			}
+
+			unique_id = fmt.Sprintf("%s-%d", id, i)
+		}
+
+		c.offsets[unique_id] = len(c.ids)
+		c.ids = append(c.ids, unique_id)
+	}
+
+	c.features = append(c.features, features...)
+	c.version += 1
+
+	bounds := make([]orb.Bound, 0, len(c.features))
+	offsets := make([]int, 0, len(c.features))
+
+	for idx, f := range c.features {
+
+		if f.Geometry == nil {
+			continue
+		}
+
+		bounds = append(bounds, f.Geometry.Bound())
+		offsets = append(offsets, idx)
+	}
+
+	c.index = newSpatialIndex(bounds, offsets)
+
+	for _, f := range features {
+
+		if f.Geometry == nil {
+			continue
+		}
+
+		b := f.Geometry.Bound()
+
+		if c.bound == nil {
+			c.bound = &b
+		} else {
+			b = c.bound.Union(b)
+			c.bound = &b
+		}
+	}
+
+	return nil
+}
+
+// Intersects returns the sorted list of offsets for features whose bounding boxes intersect 'b'.
+func (c *featureCollection) Intersects(b orb.Bound) []int {
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.index.Search(b)
+}
+
+// Feature returns the feature whose stable identifier is 'id'.
+func (c *featureCollection) Feature(id string) (*geojson.Feature, bool) {
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	idx, exists := c.offsets[id]
+
+	if !exists {
+		return nil, false
+	}
+
+	return c.features[idx], true
+}
+
+// snapshot returns the current list of features in the collection and their stable identifiers.
+func (c *featureCollection) snapshot() ([]*geojson.Feature, []string) {
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	count := len(c.features)
+	return c.features[:count:count], c.ids[:count:count]
+}
+
+// Count returns the number of features in the collection.
+func (c *featureCollection) Count() int {
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return len(c.features)
+}
+
+// Bound returns the union of the bounding boxes for all the features in the collection. It returns
+// false if the collection does not contain any features with geometries.
+func (c *featureCollection) Bound() (orb.Bound, bool) {
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.bound == nil {
+		return orb.Bound{}, false
+	}
+
+	return *c.bound, true
+}
+
+// Version returns the current version of the collection.
+func (c *featureCollection) Version() int64 {
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.version
+}
@@ -11,22 +11,66 @@ import (
 	"github.com/sfomuseum/go-www-show/v2"
 )
 
-var port int
+// DefaultFlagSet returns a new `flag.FlagSet` instance with the default flags for the `show` tool. Flag values are
+// bound to state owned by the flag set itself so multiple flag sets can be created and parsed independently in the
+// same process. Use `RunOptionsFromParsedFlagSet` to derive a `RunOptions` instance from a parsed flag set.
+func DefaultFlagSet() *flag.FlagSet {
 
-var browser_uri string
+	fs := flagset.NewFlagSet("show")
 
-var map_provider string
-var map_tile_uri string
-var protomaps_theme string
+	var port int
 
-var style string
-var point_style string
+	var browser_uri string
 
-var label_properties multi.MultiString
+	var map_provider string
+	var map_tile_uri string
+	var protomaps_theme string
 
-func DefaultFlagSet() *flag.FlagSet {
+	var style string
+	var point_style string
+	var line_style string
+	var polygon_style string
+	var hover_style string
+	var selected_style string
+	var simplestyle string
 
-	fs := flagset.NewFlagSet("show")
+	var color_property string
+	var color_method string
+	var color_classes int
+	var color_ramp string
+
+	var icons_path string
+	var icon string
+	var icon_property string
+	var icon_size int
+
+	var label_properties multi.MultiString
+	var label_template string
+	var trusted_html multi.MultiString
+	var image_hosts multi.MultiString
+	var map_label string
+
+	var vector_tile_threshold int
+	var viewport_threshold int
+	var cluster_threshold int
+	var raster_threshold int
+
+	var lazy_properties bool
+	var simplify bool
+
+	var transport string
+
+	var aggregate string
+	var aggregate_size float64
+	var aggregate_property string
+
+	var id_property string
+
+	var dataset_uris multi.KeyValueString
+
+	var config_path string
+	var profile_name string
+	var no_default_config bool
 
 	browser_schemes := show.BrowserSchemes()
 	str_schemes := strings.Join(browser_schemes, ",")
@@ -35,23 +79,97 @@ func DefaultFlagSet() *flag.FlagSet {
 
 	fs.StringVar(&browser_uri, "browser-uri", "web://", browser_desc)
 
+	config_desc := fmt.Sprintf("The path to a JSON, YAML or TOML config file containing named profiles. If empty the first config.{json,yaml,yml,toml} file found in the \"%s\" folder of the current user's config directory will be used (unless -no-default-config is set).", config_dirname)
+
+	fs.StringVar(&config_path, "config", "", config_desc)
+	fs.BoolVar(&no_default_config, "no-default-config", false, "If true the default config file, in the current user's config directory, will not be used. Config files defined by the -config flag are still used.")
+	fs.StringVar(&profile_name, "profile", "", "The name of a profile, defined in the config file, used to assign default flag values. Flags set explicitly on the command line take precedence. If empty the config file's \"default_profile\" will be used.")
+
 	fs.StringVar(&map_provider, "map-provider", "leaflet", "Valid options are: leaflet, protomaps")
 	fs.StringVar(&map_tile_uri, "map-tile-uri", leaflet_osm_tile_url, "A valid Leaflet tile layer URI. See documentation for special-case (interpolated tile) URIs.")
 	fs.StringVar(&protomaps_theme, "protomaps-theme", "white", "A valid Protomaps theme label.")
 
-	fs.StringVar(&style, "style", "", "A custom Leaflet style definition for geometries. This may either be a JSON-encoded string or a path on disk.")
+	fs.StringVar(&style, "style", "", "A custom Leaflet style definition for geometries, or a rules-based style definition assigning styles to features according to their properties. This may either be a JSON-encoded string or a path on disk.")
 	fs.StringVar(&point_style, "point-style", "", "A custom Leaflet style definition for point geometries. This may either be a JSON-encoded string or a path on disk.")
+	fs.StringVar(&line_style, "line-style", "", "A custom Leaflet style definition for line geometries, merged on top of -style. This may either be a JSON-encoded string or a path on disk.")
+	fs.StringVar(&polygon_style, "polygon-style", "", "A custom Leaflet style definition for polygon geometries, merged on top of -style. This may either be a JSON-encoded string or a path on disk.")
+	fs.StringVar(&hover_style, "hover-style", "", "A custom Leaflet style definition applied to features when the pointer is over them. This may either be a JSON-encoded string or a path on disk.")
+	fs.StringVar(&selected_style, "selected-style", "", "A custom Leaflet style definition applied to the currently selected feature. This may either be a JSON-encoded string or a path on disk. If empty a default (orange) style is used.")
+	fs.StringVar(&simplestyle, "simplestyle", "none", "How simplestyle-spec properties (stroke, stroke-width, stroke-opacity, fill, fill-opacity, marker-color and marker-size) in features are applied. Valid options are: none (they are ignored), feature (they take precedence over -style and -point-style), style (they are only used for options not defined by -style and -point-style).")
+
+	fs.StringVar(&color_property, "color-property", "", "The name of a property used to colour features. Breaks (or categories) are calculated from the features being displayed and a legend is drawn on the map.")
+	fs.StringVar(&color_method, "color-method", "quantile", "The method used to assign features to classes when colouring them by -color-property. Valid options are: quantile, equal-interval, jenks (for numeric properties), categorical (to assign a colour to each distinct value).")
+	fs.IntVar(&color_classes, "color-classes", 5, "The number of classes used to colour features by a numeric -color-property.")
+	fs.StringVar(&color_ramp, "color-ramp", "", "The name of the colour ramp used to colour features by -color-property. Valid options are: Blues, Greens, Greys, Oranges, Purples, Reds, YlOrRd, YlGnBu, RdYlBu, Viridis and, for categorical properties, the palettes Tableau10 and Set1. If empty YlOrRd is used for numeric properties and Tableau10 for categorical properties.")
+
+	fs.StringVar(&icons_path, "icons", "", "The path to an image file, or a directory of image files (png, jpg, gif, webp or svg), to use as icons for point features. Icons are named after their file name without its extension. SVG icons containing \"currentColor\" values are filled using the colour of each feature. The SVG symbols circle, square, triangle, diamond, star and pin are always available.")
+	fs.StringVar(&icon, "icon", "", "The name of the icon used to render point features which are not assigned an icon of their own. If -icons is a single image file it is used by default.")
+	fs.StringVar(&icon_property, "icon-property", "", "The name of a property whose value is used to assign point features the icon with the same name, for example \"sfomuseum:object_type\" and a directory containing postcard.png and photograph.png files.")
+	fs.IntVar(&icon_size, "icon-size", default_icon_size, "The size, in pixels, of the icons used to render point features.")
+
 	fs.IntVar(&port, "port", 0, "The port number to listen for requests on (on localhost). If 0 then a random port number will be chosen.")
 
-	fs.Var(&label_properties, "label", "Zero or more (GeoJSON Feature) properties to use to construct a label for a feature's popup menu when it is clicked on.")
+	fs.IntVar(&vector_tile_threshold, "vector-tile-threshold", default_vector_tile_threshold, "The number of features above which features will be rendered using (server-side) vector tiles rather than being loaded in to the browser all at once. If 0 vector tiles are never used.")
+
+	fs.IntVar(&viewport_threshold, "viewport-threshold", default_viewport_threshold, "The number of features above which only the features inside the current map viewport will be loaded in to the browser. If 0 all the features are always loaded at once.")
+
+	fs.IntVar(&cluster_threshold, "cluster-threshold", 0, "The number of features above which point features will be rendered as (server-side) clusters, updated as the map is zoomed and moved. If 0 points are never clustered. Clustering takes precedence over both -vector-tile-threshold and -viewport-threshold.")
+
+	fs.IntVar(&raster_threshold, "raster-threshold", 0, "The number of features above which features will be rendered as (server-side) PNG raster tiles, using the colours defined by -style and -point-style, rather than being loaded in to the browser. If 0 raster tiles are never used. Raster tiles take precedence over all other rendering modes.")
+
+	fs.BoolVar(&lazy_properties, "lazy-properties", false, "If true features will be loaded in to the browser without properties and the complete record for individual features will only be fetched when they are clicked on or scrolled in to view.")
+
+	fs.BoolVar(&simplify, "simplify", false, "If true simplified versions of each geometry will be precomputed for a set of zoom bands and the version matching the current zoom level will be loaded in to the browser. The raw pane still shows each feature's original geometry.")
+
+	fs.StringVar(&transport, "transport", "geojson", "The encoding used to send features to the browser. Valid options are: geojson, geobuf. Geobuf is a compact binary encoding of GeoJSON which is faster to decode for large collections.")
+
+	fs.StringVar(&aggregate, "aggregate", "", "Aggregate point features in to hexagonal or square cells when the map is loaded. Valid options are: hex, grid. If empty aggregated cells can still be enabled in the web application.")
+	fs.Float64Var(&aggregate_size, "aggregate-size", default_aggregate_size, "The size, in pixels, of the cells that point features are aggregated in to.")
+	fs.StringVar(&aggregate_property, "aggregate-property", "", "The (GeoJSON Feature) property whose numeric values are summed and averaged for each aggregated cell.")
+
+	fs.StringVar(&id_property, "id-property", "", "The (GeoJSON Feature) property used to derive a stable identifier for each feature, for example \"wof:id\". If empty, or if a feature does not have a matching property, the feature's GeoJSON \"id\" member is used. Failing both a hash of the feature's contents is used.")
+
+	fs.Var(&label_properties, "label", "Zero or more (GeoJSON Feature) properties to use to construct a label for a feature's popup menu when it is clicked on. Properties may be top-level property names or gjson paths for nested values, for example \"wof:hierarchy.0.country_id\".")
+	fs.StringVar(&label_template, "label-template", "", "The path to a Go (text/template syntax) template used to render the label for a feature's popup menu when it is clicked on, instead of listing -label properties. Values are HTML-escaped. Use {{ .Get \"path\" }} to output the value of a property or gjson path.")
+	fs.Var(&trusted_html, "trusted-html", "Zero or more (GeoJSON Feature) properties, or gjson paths, whose values are trusted to contain HTML and are rendered in labels without being escaped. Only use this for data you control.")
+	fs.Var(&image_hosts, "image-host", "Zero or more remote hosts, for example \"https://images.example.com\" or \"https://*.example.com\", that images in labels (like thumbnails) may be loaded from. By default images are only loaded from the same origin as the web application and the map tiles. Use \"https:\" to allow images from any HTTPS URL.")
+	fs.StringVar(&map_label, "map-label", "", "A (GeoJSON Feature) property, gjson path or Go (text/template syntax) template, for example '{{ .Get \"wof:name\" }}', used to draw a permanent text label for each feature on the map. Labels are drawn at points, inside polygons and along lines and labels which would overlap are skipped.")
+	fs.Var(&dataset_uris, "dataset", "Zero or more {NAME}={PATH} pairs defining named datasets to serve from a single instance. Each dataset is served at /maps/{NAME}/. Multiple paths may be assigned to the same name. This flag can not be combined with positional path arguments.")
 
 	fs.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Command-line tool for serving GeoJSON features from an on-demand web server.\n")
 		fmt.Fprintf(os.Stderr, "Usage:\n\t %s path(N) path(N)\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "Valid options are:\n")
 		fs.PrintDefaults()
-		fmt.Fprintf(os.Stderr, "\nIf the only path as input is \"-\" then data will be read from STDIN.\n\n")
+		fmt.Fprintf(os.Stderr, "\nIf the only path as input is \"-\" then data will be read from STDIN.\n")
+		fmt.Fprintf(os.Stderr, "Alternately, use one or more -dataset flags to serve multiple named datasets from a single instance.\n\n")
 	}
 
 	return fs
 }
+
+// flagValue returns the value of the flag named 'name' in 'fs' as type T.
+func flagValue[T any](fs *flag.FlagSet, name string) (T, error) {
+
+	var v T
+
+	fl := fs.Lookup(name)
+
+	if fl == nil {
+		return v, fmt.Errorf("Undefined flag, '%s'", name)
+	}
+
+	getter, ok := fl.Value.(flag.Getter)
+
+	if !ok {
+		return v, fmt.Errorf("Flag '%s' does not implement flag.Getter", name)
+	}
+
+	v, ok = getter.Get().(T)
+
+	if !ok {
+		return v, fmt.Errorf("Flag '%s' has unexpected type %T", name, getter.Get())
+	}
+
+	return v, nil
+}
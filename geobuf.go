@@ -0,0 +1,515 @@
+package show
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+)
+
+// The transports used to send features to the web application.
+const (
+	transport_geojson string = "geojson"
+	transport_geobuf  string = "geobuf"
+)
+
+// The maximum precision (as a power of 10) used to encode coordinates in Geobuf messages.
+const geobuf_max_precision float64 = 1e6
+
+// Geobuf geometry types.
+const (
+	geobuf_point uint64 = iota
+	geobuf_multipoint
+	geobuf_linestring
+	geobuf_polygon
+	geobuf_multilinestring
+	geobuf_multipolygon
+	geobuf_geometrycollection
+)
+
+// Protocol buffer wire types.
+const (
+	pbf_varint  uint64 = 0
+	pbf_fixed64 uint64 = 1
+	pbf_bytes   uint64 = 2
+)
+
+// geobufEncoder encodes GeoJSON FeatureCollections as Geobuf messages. Geobuf is a compact binary encoding of
+// GeoJSON data using Protocol Buffers. For details see: https://github.com/mapbox/geobuf
+type geobufEncoder struct {
+	keys      []string
+	key_index map[string]int
+	e         float64
+}
+
+// marshalGeobuf encodes 'fc' as a Geobuf message. Coordinates are encoded with the number of decimal places (up to six)
+// needed to represent them exactly. FeatureCollection foreign members are encoded as Geobuf "custom properties".
+func marshalGeobuf(fc *geojson.FeatureCollection) ([]byte, error) {
+
+	enc := &geobufEncoder{
+		keys:      make([]string, 0),
+		key_index: make(map[string]int),
+		e:         1,
+	}
+
+	for _, k := range slices.Sorted(maps.Keys(fc.ExtraMembers)) {
+		enc.addKey(k)
+	}
+
+	for _, f := range fc.Features {
+
+		for _, k := range slices.Sorted(maps.Keys(f.Properties)) {
+			enc.addKey(k)
+		}
+
+		enc.updatePrecision(f.Geometry)
+	}
+
+	fc_body, err := enc.featureCollection(fc)
+
+	if err != nil {
+		return nil, err
+	}
+
+	body := make([]byte, 0)
+
+	for _, k := range enc.keys {
+		body = appendString(body, 1, k)
+	}
+
+	precision := uint64(math.Round(math.Log10(enc.e)))
+
+	if precision != 6 {
+		body = appendVarint(body, 3, precision)
+	}
+
+	body = appendBytes(body, 4, fc_body)
+	return body, nil
+}
+
+func (enc *geobufEncoder) addKey(k string) {
+
+	_, exists := enc.key_index[k]
+
+	if exists {
+		return
+	}
+
+	enc.key_index[k] = len(enc.keys)
+	enc.keys = append(enc.keys, k)
+}
+
+// updatePrecision increases the precision used to encode coordinates, up to geobuf_max_precision, until all
+// the coordinates in 'g' can be represented exactly.
+func (enc *geobufEncoder) updatePrecision(g orb.Geometry) {
+
+	if g == nil {
+		return
+	}
+
+	update := func(n float64) {
+
+		for enc.e < geobuf_max_precision && math.Round(n*enc.e)/enc.e != n {
+			enc.e *= 10
+		}
+	}
+
+	for _, pt := range geometryPoints(g) {
+		update(pt[0])
+		update(pt[1])
+	}
+}
+
+func (enc *geobufEncoder) featureCollection(fc *geojson.FeatureCollection) ([]byte, error) {
+
+	body := make([]byte, 0)
+
+	for _, f := range fc.Features {
+
+		f_body, err := enc.feature(f)
+
+		if err != nil {
+			return nil, err
+		}
+
+		body = appendBytes(body, 1, f_body)
+	}
+
+	body, err := enc.properties(body, fc.ExtraMembers, 15)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return body, nil
+}
+
+func (enc *geobufEncoder) feature(f *geojson.Feature) ([]byte, error) {
+
+	body := make([]byte, 0)
+
+	if f.Geometry != nil {
+
+		geom_body, err := enc.geometry(f.Geometry)
+
+		if err != nil {
+			return nil, err
+		}
+
+		body = appendBytes(body, 1, geom_body)
+	}
+
+	switch id := f.ID.(type) {
+	case nil:
+		// pass
+	case string:
+		body = appendString(body, 11, id)
+	case float64:
+
+		if id == math.Trunc(id) && math.Abs(id) < 1<<53 {
+			body = appendVarint(body, 12, zigzag(int64(id)))
+		} else {
+			body = appendString(body, 11, fmt.Sprintf("%v", id))
+		}
+
+	case int:
+		body = appendVarint(body, 12, zigzag(int64(id)))
+	case int64:
+		body = appendVarint(body, 12, zigzag(id))
+	default:
+		body = appendString(body, 11, fmt.Sprintf("%v", id))
+	}
+
+	body, err := enc.properties(body, f.Properties, 14)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return body, nil
+}
+
+// properties appends each value in 'props' as a Geobuf "Value" message followed by a packed list of key and value
+// indices written to the field 'field_number'.
+func (enc *geobufEncoder) properties(body []byte, props map[string]any, field_number uint64) ([]byte, error) {
+
+	if len(props) == 0 {
+		return body, nil
+	}
+
+	indices := make([]uint64, 0)
+	value_index := 0
+
+	for _, k := range slices.Sorted(maps.Keys(props)) {
+
+		v_body, err := geobufValue(props[k])
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to encode value for '%s', %w", k, err)
+		}
+
+		body = appendBytes(body, 13, v_body)
+
+		indices = append(indices, uint64(enc.key_index[k]), uint64(value_index))
+		value_index += 1
+	}
+
+	body = appendPacked(body, field_number, indices)
+	return body, nil
+}
+
+func (enc *geobufEncoder) geometry(g orb.Geometry) ([]byte, error) {
+
+	body := make([]byte, 0)
+
+	switch geom := g.(type) {
+	case orb.Point:
+		body = appendVarint(body, 1, geobuf_point)
+		body = appendPacked(body, 3, enc.coords(nil, []orb.Point{geom}, false, false))
+	case orb.MultiPoint:
+		body = appendVarint(body, 1, geobuf_multipoint)
+		body = appendPacked(body, 3, enc.coords(nil, geom, false, true))
+	case orb.LineString:
+		body = appendVarint(body, 1, geobuf_linestring)
+		body = appendPacked(body, 3, enc.coords(nil, geom, false, true))
+	case orb.MultiLineString:
+
+		lines := make([][]orb.Point, len(geom))
+
+		for i, ls := range geom {
+			lines[i] = ls
+		}
+
+		body = appendVarint(body, 1, geobuf_multilinestring)
+		body = enc.multiLine(body, lines, false)
+
+	case orb.Ring:
+		return enc.geometry(orb.Polygon{geom})
+	case orb.Bound:
+		return enc.geometry(geom.ToPolygon())
+	case orb.Polygon:
+
+		rings := make([][]orb.Point, len(geom))
+
+		for i, r := range geom {
+			rings[i] = r
+		}
+
+		body = appendVarint(body, 1, geobuf_polygon)
+		body = enc.multiLine(body, rings, true)
+
+	case orb.MultiPolygon:
+
+		body = appendVarint(body, 1, geobuf_multipolygon)
+
+		if len(geom) != 1 || len(geom[0]) != 1 {
+
+			lengths := []uint64{uint64(len(geom))}
+
+			for _, p := range geom {
+
+				lengths = append(lengths, uint64(len(p)))
+
+				for _, r := range p {
+					lengths = append(lengths, uint64(max(len(r)-1, 0)))
+				}
+			}
+
+			body = appendPacked(body, 2, lengths)
+		}
+
+		coords := make([]uint64, 0)
+
+		for _, p := range geom {
+
+			for _, r := range p {
+				coords = enc.coords(coords, r, true, true)
+			}
+		}
+
+		body = appendPacked(body, 3, coords)
+
+	case orb.Collection:
+
+		body = appendVarint(body, 1, geobuf_geometrycollection)
+
+		for _, cg := range geom {
+
+			cg_body, err := enc.geometry(cg)
+
+			if err != nil {
+				return nil, err
+			}
+
+			body = appendBytes(body, 4, cg_body)
+		}
+
+	default:
+		return nil, fmt.Errorf("Unsupported geometry type, %T", g)
+	}
+
+	return body, nil
+}
+
+// multiLine appends the lengths (if there is more than one line) and the coordinates of 'lines' to 'body'.
+// If 'closed' is true the last coordinate of each line (which is the same as the first) is omitted.
+func (enc *geobufEncoder) multiLine(body []byte, lines [][]orb.Point, closed bool) []byte {
+
+	if len(lines) != 1 {
+
+		lengths := make([]uint64, len(lines))
+
+		for i, l := range lines {
+
+			if closed {
+				lengths[i] = uint64(max(len(l)-1, 0))
+			} else {
+				lengths[i] = uint64(len(l))
+			}
+		}
+
+		body = appendPacked(body, 2, lengths)
+	}
+
+	coords := make([]uint64, 0)
+
+	for _, l := range lines {
+		coords = enc.coords(coords, l, closed, true)
+	}
+
+	return appendPacked(body, 3, coords)
+}
+
+// coords appends the zigzag-encoded coordinates of 'points' to 'coords'. If 'delta' is true coordinates are
+// encoded as the difference from the previous coordinate. If 'closed' is true the last point is omitted.
+func (enc *geobufEncoder) coords(coords []uint64, points []orb.Point, closed bool, delta bool) []uint64 {
+
+	count := len(points)
+
+	if closed && count > 0 {
+		count -= 1
+	}
+
+	var sum [2]int64
+
+	for _, pt := range points[:count] {
+
+		for j := 0; j < 2; j++ {
+
+			n := int64(math.Round(pt[j] * enc.e))
+
+			if delta {
+				n = n - sum[j]
+				sum[j] += n
+			}
+
+			coords = append(coords, zigzag(n))
+		}
+	}
+
+	return coords
+}
+
+// geobufValue encodes 'v' as a Geobuf "Value" message. Values which are not strings, numbers or booleans
+// are encoded as JSON.
+func geobufValue(v any) ([]byte, error) {
+
+	body := make([]byte, 0)
+
+	switch value := v.(type) {
+	case string:
+		body = appendString(body, 1, value)
+	case bool:
+
+		var b uint64
+
+		if value {
+			b = 1
+		}
+
+		body = appendVarint(body, 5, b)
+
+	case float64:
+
+		if value != math.Trunc(value) || math.Abs(value) >= 1<<53 {
+			body = binary.AppendUvarint(body, 2<<3|pbf_fixed64)
+			body = binary.LittleEndian.AppendUint64(body, math.Float64bits(value))
+		} else if value >= 0 {
+			body = appendVarint(body, 3, uint64(value))
+		} else {
+			body = appendVarint(body, 4, uint64(-value))
+		}
+
+	case int:
+		return geobufValue(float64(value))
+	case int64:
+		return geobufValue(float64(value))
+	default:
+
+		enc_v, err := json.Marshal(value)
+
+		if err != nil {
+			return nil, err
+		}
+
+		body = appendString(body, 6, string(enc_v))
+	}
+
+	return body, nil
+}
+
+// geometryPoints returns all the points in 'g'.
+func geometryPoints(g orb.Geometry) []orb.Point {
+
+	switch geom := g.(type) {
+	case orb.Point:
+		return []orb.Point{geom}
+	case orb.MultiPoint:
+		return geom
+	case orb.LineString:
+		return geom
+	case orb.Ring:
+		return geom
+	case orb.MultiLineString:
+
+		points := make([]orb.Point, 0)
+
+		for _, ls := range geom {
+			points = append(points, ls...)
+		}
+
+		return points
+
+	case orb.Polygon:
+
+		points := make([]orb.Point, 0)
+
+		for _, r := range geom {
+			points = append(points, r...)
+		}
+
+		return points
+
+	case orb.MultiPolygon:
+
+		points := make([]orb.Point, 0)
+
+		for _, p := range geom {
+			points = append(points, geometryPoints(p)...)
+		}
+
+		return points
+
+	case orb.Collection:
+
+		points := make([]orb.Point, 0)
+
+		for _, cg := range geom {
+			points = append(points, geometryPoints(cg)...)
+		}
+
+		return points
+
+	case orb.Bound:
+		return []orb.Point{geom.Min, geom.Max}
+	}
+
+	return nil
+}
+
+// Protocol buffer helper functions
+
+func zigzag(n int64) uint64 {
+	return uint64((n << 1) ^ (n >> 63))
+}
+
+func appendVarint(body []byte, field_number uint64, v uint64) []byte {
+	body = binary.AppendUvarint(body, field_number<<3|pbf_varint)
+	return binary.AppendUvarint(body, v)
+}
+
+func appendBytes(body []byte, field_number uint64, b []byte) []byte {
+	body = binary.AppendUvarint(body, field_number<<3|pbf_bytes)
+	body = binary.AppendUvarint(body, uint64(len(b)))
+	return append(body, b...)
+}
+
+func appendString(body []byte, field_number uint64, s string) []byte {
+	return appendBytes(body, field_number, []byte(s))
+}
+
+func appendPacked(body []byte, field_number uint64, values []uint64) []byte {
+
+	packed := make([]byte, 0, len(values))
+
+	for _, v := range values {
+		packed = binary.AppendUvarint(packed, v)
+	}
+
+	return appendBytes(body, field_number, packed)
+}
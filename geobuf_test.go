@@ -0,0 +1,501 @@
+package show
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+)
+
+// pbfField is a single field read from a Protocol Buffers message by readPbf.
+type pbfField struct {
+	number uint64
+	varint uint64
+	bytes  []byte
+}
+
+// readPbf returns the fields in the Protocol Buffers message 'body'.
+func readPbf(t *testing.T, body []byte) []*pbfField {
+
+	fields := make([]*pbfField, 0)
+
+	for len(body) > 0 {
+
+		tag, n := binary.Uvarint(body)
+
+		if n <= 0 {
+			t.Fatalf("Invalid field tag")
+		}
+
+		body = body[n:]
+		f := &pbfField{number: tag >> 3}
+
+		switch tag & 0x7 {
+		case pbf_varint:
+
+			f.varint, n = binary.Uvarint(body)
+
+			if n <= 0 {
+				t.Fatalf("Invalid varint for field %d", f.number)
+			}
+
+			body = body[n:]
+
+		case pbf_fixed64:
+			f.bytes = body[:8]
+			body = body[8:]
+		case pbf_bytes:
+
+			length, n := binary.Uvarint(body)
+
+			if n <= 0 || int(length) > len(body[n:]) {
+				t.Fatalf("Invalid length for field %d", f.number)
+			}
+
+			f.bytes = body[n : n+int(length)]
+			body = body[n+int(length):]
+
+		default:
+			t.Fatalf("Unsupported wire type %d for field %d", tag&0x7, f.number)
+		}
+
+		fields = append(fields, f)
+	}
+
+	return fields
+}
+
+// readPacked returns the varints in the packed field 'b'.
+func readPacked(b []byte) []uint64 {
+
+	values := make([]uint64, 0)
+
+	for len(b) > 0 {
+		v, n := binary.Uvarint(b)
+		values = append(values, v)
+		b = b[n:]
+	}
+
+	return values
+}
+
+func unzigzag(v uint64) int64 {
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+// geobufDecoder decodes the Geobuf messages produced by marshalGeobuf, following the same rules as the
+// sfomuseum.geobuf.js decoder used by the web application.
+type geobufDecoder struct {
+	t    *testing.T
+	keys []string
+	e    float64
+}
+
+func decodeGeobuf(t *testing.T, body []byte) *geojson.FeatureCollection {
+
+	dec := &geobufDecoder{
+		t:    t,
+		keys: make([]string, 0),
+		e:    1e6,
+	}
+
+	var fc *geojson.FeatureCollection
+
+	for _, f := range readPbf(t, body) {
+
+		switch f.number {
+		case 1:
+			dec.keys = append(dec.keys, string(f.bytes))
+		case 3:
+			dec.e = math.Pow(10, float64(f.varint))
+		case 4:
+			fc = dec.featureCollection(f.bytes)
+		default:
+			t.Fatalf("Unexpected field %d in data message", f.number)
+		}
+	}
+
+	if fc == nil {
+		t.Fatalf("Missing feature collection")
+	}
+
+	return fc
+}
+
+func (dec *geobufDecoder) featureCollection(body []byte) *geojson.FeatureCollection {
+
+	fc := geojson.NewFeatureCollection()
+	values := make([]any, 0)
+
+	for _, f := range readPbf(dec.t, body) {
+
+		switch f.number {
+		case 1:
+			fc.Append(dec.feature(f.bytes))
+		case 13:
+			values = append(values, dec.value(f.bytes))
+		case 15:
+			fc.ExtraMembers = dec.properties(readPacked(f.bytes), values)
+		}
+	}
+
+	return fc
+}
+
+func (dec *geobufDecoder) feature(body []byte) *geojson.Feature {
+
+	f := &geojson.Feature{
+		Type:       "Feature",
+		Properties: geojson.Properties{},
+	}
+
+	values := make([]any, 0)
+
+	for _, field := range readPbf(dec.t, body) {
+
+		switch field.number {
+		case 1:
+			f.Geometry = dec.geometry(field.bytes)
+		case 11:
+			f.ID = string(field.bytes)
+		case 12:
+			f.ID = float64(unzigzag(field.varint))
+		case 13:
+			values = append(values, dec.value(field.bytes))
+		case 14:
+			f.Properties = dec.properties(readPacked(field.bytes), values)
+		}
+	}
+
+	return f
+}
+
+func (dec *geobufDecoder) properties(indices []uint64, values []any) geojson.Properties {
+
+	props := geojson.Properties{}
+
+	for i := 0; i < len(indices); i += 2 {
+		props[dec.keys[indices[i]]] = values[indices[i+1]]
+	}
+
+	return props
+}
+
+func (dec *geobufDecoder) value(body []byte) any {
+
+	f := readPbf(dec.t, body)[0]
+
+	switch f.number {
+	case 1:
+		return string(f.bytes)
+	case 2:
+		return math.Float64frombits(binary.LittleEndian.Uint64(f.bytes))
+	case 3:
+		return float64(f.varint)
+	case 4:
+		return -float64(f.varint)
+	case 5:
+		return f.varint == 1
+	case 6:
+
+		var v any
+		err := json.Unmarshal(f.bytes, &v)
+
+		if err != nil {
+			dec.t.Fatalf("Failed to decode JSON value, %v", err)
+		}
+
+		return v
+	}
+
+	dec.t.Fatalf("Unexpected field %d in value message", f.number)
+	return nil
+}
+
+// points returns the points encoded in 'coords', which are delta-encoded if 'delta' is true. If 'closed' is
+// true the first point is appended to the end of the list.
+func (dec *geobufDecoder) points(coords []uint64, delta bool, closed bool) []orb.Point {
+
+	points := make([]orb.Point, 0)
+	var sum [2]int64
+
+	for i := 0; i < len(coords); i += 2 {
+
+		var pt orb.Point
+
+		for j := 0; j < 2; j++ {
+
+			n := unzigzag(coords[i+j])
+
+			if delta {
+				sum[j] += n
+				n = sum[j]
+			}
+
+			pt[j] = float64(n) / dec.e
+		}
+
+		points = append(points, pt)
+	}
+
+	if closed && len(points) > 0 {
+		points = append(points, points[0])
+	}
+
+	return points
+}
+
+// lines splits 'coords' in to lines with the number of points defined by 'lengths'.
+func (dec *geobufDecoder) lines(coords []uint64, lengths []uint64, closed bool) [][]orb.Point {
+
+	if lengths == nil {
+		lengths = []uint64{uint64(len(coords) / 2)}
+	}
+
+	lines := make([][]orb.Point, 0)
+
+	for _, length := range lengths {
+		lines = append(lines, dec.points(coords[:length*2], true, closed))
+		coords = coords[length*2:]
+	}
+
+	return lines
+}
+
+func (dec *geobufDecoder) geometry(body []byte) orb.Geometry {
+
+	var geom_type uint64
+	var lengths []uint64
+	var coords []uint64
+
+	collection := orb.Collection{}
+
+	for _, f := range readPbf(dec.t, body) {
+
+		switch f.number {
+		case 1:
+			geom_type = f.varint
+		case 2:
+			lengths = readPacked(f.bytes)
+		case 3:
+			coords = readPacked(f.bytes)
+		case 4:
+			collection = append(collection, dec.geometry(f.bytes))
+		}
+	}
+
+	switch geom_type {
+	case geobuf_point:
+		return dec.points(coords, false, false)[0]
+	case geobuf_multipoint:
+		return orb.MultiPoint(dec.points(coords, true, false))
+	case geobuf_linestring:
+		return orb.LineString(dec.points(coords, true, false))
+	case geobuf_multilinestring:
+
+		mls := orb.MultiLineString{}
+
+		for _, l := range dec.lines(coords, lengths, false) {
+			mls = append(mls, orb.LineString(l))
+		}
+
+		return mls
+
+	case geobuf_polygon:
+
+		poly := orb.Polygon{}
+
+		for _, l := range dec.lines(coords, lengths, true) {
+			poly = append(poly, orb.Ring(l))
+		}
+
+		return poly
+
+	case geobuf_multipolygon:
+
+		if lengths == nil {
+			return orb.MultiPolygon{orb.Polygon{orb.Ring(dec.points(coords, true, true))}}
+		}
+
+		mp := orb.MultiPolygon{}
+		count_polygons := lengths[0]
+		lengths = lengths[1:]
+
+		for i := uint64(0); i < count_polygons; i++ {
+
+			count_rings := lengths[0]
+			ring_lengths := lengths[1 : 1+count_rings]
+			lengths = lengths[1+count_rings:]
+
+			poly := orb.Polygon{}
+
+			for _, length := range ring_lengths {
+				poly = append(poly, orb.Ring(dec.points(coords[:length*2], true, true)))
+				coords = coords[length*2:]
+			}
+
+			mp = append(mp, poly)
+		}
+
+		return mp
+
+	case geobuf_geometrycollection:
+		return collection
+	}
+
+	dec.t.Fatalf("Unexpected geometry type %d", geom_type)
+	return nil
+}
+
+func TestMarshalGeobuf(t *testing.T) {
+
+	square := orb.Ring{{0, 0}, {1, 0}, {1, 1}, {0, 1}, {0, 0}}
+	hole := orb.Ring{{0.25, 0.25}, {0.25, 0.75}, {0.75, 0.75}, {0.75, 0.25}, {0.25, 0.25}}
+
+	tests := []struct {
+		name       string
+		geom       orb.Geometry
+		id         any
+		properties geojson.Properties
+	}{
+		{"integer point", orb.Point{-122, 37}, nil, nil},
+		{"decimal point", orb.Point{-122.386166, 37.616407}, nil, nil},
+		{"negative coordinates", orb.Point{-0.5, -89.999999}, nil, nil},
+		{"multipoint", orb.MultiPoint{{0, 0}, {-1.5, 2.25}}, nil, nil},
+		{"linestring", orb.LineString{{0, 0}, {1, 1}, {2, 0.5}}, nil, nil},
+		{"multilinestring", orb.MultiLineString{{{0, 0}, {1, 1}}, {{2, 2}, {3, 3}, {4, 2}}}, nil, nil},
+		{"polygon", orb.Polygon{square}, nil, nil},
+		{"polygon with hole", orb.Polygon{square, hole}, nil, nil},
+		{"multipolygon", orb.MultiPolygon{{square}}, nil, nil},
+		{"multipolygon with holes", orb.MultiPolygon{{square, hole}, {{{2, 2}, {3, 2}, {3, 3}, {2, 2}}}}, nil, nil},
+		{"collection", orb.Collection{orb.Point{1, 2}, orb.LineString{{0, 0}, {1, 1}}}, nil, nil},
+		{"string id", orb.Point{0, 0}, "sfo", nil},
+		{"numeric id", orb.Point{0, 0}, float64(102527513), nil},
+		{"negative id", orb.Point{0, 0}, float64(-1), nil},
+		{
+			name: "properties",
+			geom: orb.Point{0, 0},
+			properties: geojson.Properties{
+				"name":          "SFO",
+				"wof:id":        float64(102527513),
+				"elevation":     float64(-3),
+				"height":        12.5,
+				"is_current":    true,
+				"is_deprecated": false,
+				"hierarchy":     []any{map[string]any{"country_id": float64(85633793)}},
+				"nothing":       nil,
+			},
+		},
+	}
+
+	for _, test := range tests {
+
+		t.Run(test.name, func(t *testing.T) {
+
+			f := geojson.NewFeature(test.geom)
+			f.ID = test.id
+
+			if test.properties != nil {
+				f.Properties = test.properties
+			}
+
+			fc := geojson.NewFeatureCollection()
+			fc.Append(f)
+			fc.ExtraMembers = geojson.Properties{
+				"show:ids": []any{"abc"},
+			}
+
+			body, err := marshalGeobuf(fc)
+
+			if err != nil {
+				t.Fatalf("Failed to marshal Geobuf, %v", err)
+			}
+
+			decoded := decodeGeobuf(t, body)
+
+			if len(decoded.Features) != 1 {
+				t.Fatalf("Expected a single feature, got %d", len(decoded.Features))
+			}
+
+			decoded_f := decoded.Features[0]
+
+			if !orb.Equal(decoded_f.Geometry, test.geom) {
+				t.Fatalf("Expected geometry %v, got %v", test.geom, decoded_f.Geometry)
+			}
+
+			if decoded_f.ID != test.id {
+				t.Fatalf("Expected id %v, got %v", test.id, decoded_f.ID)
+			}
+
+			assertJSONEqual(t, decoded_f.Properties, f.Properties)
+			assertJSONEqual(t, decoded.ExtraMembers, fc.ExtraMembers)
+		})
+	}
+}
+
+// assertJSONEqual fails 't' if the JSON encodings of 'a' and 'b' are not the same.
+func assertJSONEqual(t *testing.T, a any, b any) {
+
+	enc_a, err := json.Marshal(a)
+
+	if err != nil {
+		t.Fatalf("Failed to marshal value, %v", err)
+	}
+
+	enc_b, err := json.Marshal(b)
+
+	if err != nil {
+		t.Fatalf("Failed to marshal value, %v", err)
+	}
+
+	if string(enc_a) != string(enc_b) {
+		t.Fatalf("Expected %s, got %s", enc_b, enc_a)
+	}
+}
+
+func TestMarshalGeobufPrecision(t *testing.T) {
+
+	tests := []struct {
+		coords    []orb.Point
+		precision uint64
+	}{
+		{[]orb.Point{{1, 2}}, 0},
+		{[]orb.Point{{1.5, 2}}, 1},
+		{[]orb.Point{{1.5, 2.25}, {0.125, 0}}, 3},
+		{[]orb.Point{{-122.386166, 37.616407}}, 6},
+		{[]orb.Point{{0.123456789, 0}}, 6},
+	}
+
+	for _, test := range tests {
+
+		fc := geojson.NewFeatureCollection()
+
+		for _, pt := range test.coords {
+			fc.Append(geojson.NewFeature(pt))
+		}
+
+		body, err := marshalGeobuf(fc)
+
+		if err != nil {
+			t.Fatalf("Failed to marshal Geobuf, %v", err)
+		}
+
+		// The precision field is omitted when it is the default (6)
+
+		precision := uint64(6)
+
+		for _, f := range readPbf(t, body) {
+
+			if f.number == 3 {
+				precision = f.varint
+			}
+		}
+
+		if precision != test.precision {
+			t.Fatalf("Expected precision %d for %v, got %d", test.precision, test.coords, precision)
+		}
+	}
+}
@@ -3,12 +3,16 @@ module github.com/sfomuseum/go-geojson-show
 go 1.23.0
 
 require (
+	github.com/BurntSushi/toml v1.4.0
+	github.com/andybalholm/brotli v1.1.1
 	github.com/paulmach/orb v0.11.1
 	github.com/sfomuseum/go-flags v0.10.0
 	github.com/sfomuseum/go-http-protomaps v0.3.0
 	github.com/sfomuseum/go-www-show/v2 v2.0.0
 	github.com/tidwall/gjson v1.18.0
 	github.com/whosonfirst/go-whosonfirst-format-wasm v0.0.1
+	golang.org/x/image v0.24.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -16,6 +20,8 @@ require (
 	github.com/aaronland/go-http-rewrite v1.1.0 // indirect
 	github.com/aaronland/go-http-static v0.0.3 // indirect
 	github.com/aaronland/go-roster v1.0.0 // indirect
+	github.com/gogo/protobuf v1.3.2 // indirect
+	github.com/paulmach/protoscan v0.2.1 // indirect
 	github.com/pkg/browser v0.0.0-20240102092130-5ac0b6a4141c // indirect
 	github.com/sfomuseum/go-http-rollup v0.0.3 // indirect
 	github.com/tdewolff/minify/v2 v2.20.32 // indirect
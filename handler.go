@@ -0,0 +1,598 @@
+package show
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/paulmach/orb/geojson"
+	"github.com/sfomuseum/go-geojson-show/static/www"
+	"github.com/sfomuseum/go-http-protomaps"
+	wasm_js "github.com/whosonfirst/go-whosonfirst-format-wasm/static/javascript"
+	"github.com/whosonfirst/go-whosonfirst-format-wasm/static/wasm"
+)
+
+const leaflet_osm_tile_url = "https://tile.openstreetmap.org/{z}/{x}/{y}.png"
+const protomaps_api_tile_url string = "https://api.protomaps.com/tiles/v3/{z}/{x}/{y}.mvt?key={key}"
+
+// Handler is an `http.Handler` for serving a map of GeoJSON features.
+type Handler struct {
+	mux        *http.ServeMux
+	features   *featureCollection
+	simplified *simplifiedGeometries
+	styles     *featureStyles
+	// The value of the Content-Security-Policy header sent with every response.
+	csp string
+}
+
+// NewHandler returns a new `Handler` instance for serving the map, features and map configuration defined by 'opts'.
+// All of the URLs requested by the web application are relative so the handler can be mounted under any path prefix,
+// for example using `http.StripPrefix`, or served from behind a reverse proxy. For example:
+//
+//	h, _ := show.NewHandler(ctx, opts)
+//	mux.Handle("/debug/map/", http.StripPrefix("/debug/map", h))
+//
+// Note that the path the handler is mounted under must end in a trailing slash.
+func NewHandler(ctx context.Context, opts *RunOptions) (*Handler, error) {
+
+	features, err := newFeatureCollection(ctx, opts.Features, opts.Transformers, opts.IdProperty)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load features, %w", err)
+	}
+
+	var simplified *simplifiedGeometries
+
+	if opts.Simplify {
+		simplified = newSimplifiedGeometries(features)
+	}
+
+	// The default style for features which are not assigned a style of their own
+
+	style := opts.Style
+
+	if style == nil && opts.StyleRules != nil {
+		style = opts.StyleRules.Default
+	}
+
+	var choropleth *choropleth
+
+	if opts.ColorProperty != "" {
+
+		var fill_opacity *float64
+
+		if style != nil {
+			fill_opacity = style.FillOpacity
+		}
+
+		fc, _ := features.snapshot()
+
+		choropleth, err = newChoropleth(fc, opts.ColorProperty, opts.ColorMethod, opts.ColorClasses, opts.ColorRamp, fill_opacity)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to derive colours for '%s', %w", opts.ColorProperty, err)
+		}
+	}
+
+	icons, err := newIconSet(opts.IconsPath)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load icons, %w", err)
+	}
+
+	// The default icon for point features is merged in to the point style
+
+	point_style := opts.PointStyle
+	default_icon := opts.Icon
+
+	if default_icon == "" {
+		default_icon = icons.file_icon
+	}
+
+	if default_icon != "" {
+		point_style = mergeStyles(point_style, &LeafletStyle{Icon: default_icon})
+	}
+
+	icon_styles := []*LeafletStyle{style, point_style, opts.LineStyle, opts.PolygonStyle, opts.HoverStyle, opts.SelectedStyle}
+
+	if opts.StyleRules != nil {
+
+		for _, rule := range opts.StyleRules.Rules {
+			icon_styles = append(icon_styles, rule.Style)
+		}
+	}
+
+	for _, s := range icon_styles {
+
+		err := icons.validateStyle(s)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	styler, err := newFeatureStyler(opts, style, point_style, choropleth, icons)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create feature styler, %w", err)
+	}
+
+	var styles *featureStyles
+
+	if styler != nil {
+		styles = newFeatureStyles(features, styler)
+	}
+
+	mux := http.NewServeMux()
+
+	www_fs := http.FS(www.FS)
+	mux.Handle("/", http.FileServer(www_fs))
+
+	wasm_fs := http.FS(wasm.FS)
+	wasm_handler := http.FileServer(wasm_fs)
+
+	wasm_js_fs := http.FS(wasm_js.FS)
+	wasm_js_handler := http.FileServer(wasm_js_fs)
+
+	mux.Handle("/javascript/wasm/", http.StripPrefix("/javascript/wasm/", wasm_js_handler))
+	mux.Handle("/wasm/", http.StripPrefix("/wasm/", wasm_handler))
+
+	data_handler := dataHandler(features, simplified, styles, transport_geojson)
+	mux.Handle("/features.geojson", data_handler)
+
+	geobuf_handler := dataHandler(features, simplified, styles, transport_geobuf)
+	mux.Handle("/features.pbf", geobuf_handler)
+
+	feature_handler := featureHandler(features)
+	mux.Handle("/features/{id}", feature_handler)
+
+	labeler, err := newFeatureLabeler(opts.LabelProperties, opts.LabelTemplate, opts.TrustedHTMLProperties)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create feature labeler, %w", err)
+	}
+
+	//
+
+	map_cfg := &mapConfig{
+		Provider:        opts.MapProvider,
+		TileURL:         opts.MapTileURI,
+		LazyProperties:  opts.LazyProperties,
+		Style:           style,
+		PointStyle:      point_style,
+		LineStyle:       opts.LineStyle,
+		PolygonStyle:    opts.PolygonStyle,
+		HoverStyle:      opts.HoverStyle,
+		SelectedStyle:   opts.SelectedStyle,
+		LabelProperties: opts.LabelProperties,
+		Transport:       opts.Transport,
+	}
+
+	if labeler != nil {
+
+		label_handler := labelHandler(features, labeler)
+		mux.Handle("/labels/{id}", label_handler)
+
+		map_cfg.Labels = &labelsConfig{
+			URL: "labels/",
+		}
+	}
+
+	if opts.MapLabel != "" {
+
+		map_labeler, err := newMapLabeler(opts.MapLabel)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to create map labeler, %w", err)
+		}
+
+		map_labels := newMapLabels(features, map_labeler)
+
+		map_labels_handler := mapLabelsHandler(features, map_labels)
+		mux.Handle("/map-labels.geojson", map_labels_handler)
+
+		map_cfg.MapLabels = &mapLabelsConfig{
+			URL: "map-labels.geojson",
+		}
+	}
+
+	if map_cfg.SelectedStyle == nil {
+		map_cfg.SelectedStyle = default_selected_style
+	}
+
+	if choropleth != nil {
+		map_cfg.Choropleth = choropleth.Config()
+	}
+
+	icon_size := opts.IconSize
+
+	if icon_size <= 0 {
+		icon_size = default_icon_size
+	}
+
+	map_cfg.Icons = icons.Config(icon_size)
+
+	icons_handler := iconsHandler(icons)
+	mux.Handle("/icons/{name}", icons_handler)
+
+	switch opts.Transport {
+	case transport_geojson, transport_geobuf:
+		// pass
+	case "":
+		map_cfg.Transport = transport_geojson
+	default:
+		return nil, fmt.Errorf("Invalid transport, '%s'", opts.Transport)
+	}
+
+	if opts.Simplify {
+
+		map_cfg.Simplify = &simplifyConfig{
+			Zooms:   simplify_zooms,
+			MaxZoom: simplify_max_zoom,
+		}
+	}
+
+	if opts.MapProvider == "protomaps" {
+
+		u, err := url.Parse(opts.MapTileURI)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse Protomaps tile URL, %w", err)
+		}
+
+		switch u.Scheme {
+		case "file":
+
+			mux_url, mux_handler, err := protomaps.FileHandlerFromPath(u.Path, "")
+
+			if err != nil {
+				return nil, fmt.Errorf("Failed to determine absolute path for '%s', %w", opts.MapTileURI, err)
+			}
+
+			mux.Handle(mux_url, mux_handler)
+
+			// Tile URLs are relative to the (possibly prefixed) page so that the handler
+			// can be mounted anywhere.
+			map_cfg.TileURL = strings.TrimLeft(mux_url, "/")
+
+		case "api":
+			key := u.Host
+			map_cfg.TileURL = strings.Replace(protomaps_api_tile_url, "{key}", key, 1)
+		}
+
+		map_cfg.Protomaps = &protomapsConfig{
+			Theme: opts.ProtomapsTheme,
+		}
+	}
+
+	tiles_handler := tilesHandler(features, styles, opts.LabelProperties)
+	mux.Handle("/tiles/{z}/{x}/{y}", tiles_handler)
+
+	if opts.RasterThreshold > 0 {
+
+		raster_styles, err := newRasterStyles(style, opts.LineStyle, opts.PolygonStyle, opts.PointStyle, styles)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to derive raster style, %w", err)
+		}
+
+		raster_handler := rasterHandler(features, simplified, raster_styles)
+		mux.Handle("/raster/{z}/{x}/{y}", raster_handler)
+
+		nearest_handler := nearestHandler(features, raster_styles)
+		mux.Handle("/nearest.geojson", nearest_handler)
+	}
+
+	err = validateAggregateType(opts.Aggregate)
+
+	if err != nil {
+		return nil, err
+	}
+
+	aggregate_size := opts.AggregateSize
+
+	if aggregate_size <= 0 {
+		aggregate_size = default_aggregate_size
+	}
+
+	aggregate_handler := aggregateHandler(features, aggregate_size, opts.AggregateProperty)
+	mux.Handle("/aggregate.geojson", aggregate_handler)
+
+	map_cfg.Aggregate = &aggregateConfig{
+		URL:      "aggregate.geojson",
+		Type:     opts.Aggregate,
+		Size:     aggregate_size,
+		Property: opts.AggregateProperty,
+	}
+
+	clusters_handler := clustersHandler(features, simplified, styles, transport_geojson)
+	mux.Handle("/clusters.geojson", clusters_handler)
+
+	clusters_geobuf_handler := clustersHandler(features, simplified, styles, transport_geobuf)
+	mux.Handle("/clusters.pbf", clusters_geobuf_handler)
+
+	map_cfg_handler := mapConfigHandler(map_cfg, features, opts.RasterThreshold, opts.ClusterThreshold, opts.VectorTileThreshold, opts.ViewportThreshold)
+
+	mux.Handle("/map.json", map_cfg_handler)
+
+	csp := opts.ContentSecurityPolicy
+
+	if csp == "" {
+
+		for _, host := range opts.ImageHosts {
+
+			err := validateImageHost(host)
+
+			if err != nil {
+				return nil, fmt.Errorf("Invalid image host, %w", err)
+			}
+		}
+
+		csp = contentSecurityPolicy(map_cfg.TileURL, opts.ImageHosts)
+	}
+
+	h := &Handler{
+		mux:        mux,
+		features:   features,
+		simplified: simplified,
+		styles:     styles,
+		csp:        csp,
+	}
+
+	return h, nil
+}
+
+// ServeHTTP routes requests to the handlers for the web application, features and map configuration. All responses
+// include a Content-Security-Policy header so that any markup in feature properties can not load or run scripts.
+func (h *Handler) ServeHTTP(rsp http.ResponseWriter, req *http.Request) {
+	rsp.Header().Set("Content-Security-Policy", h.csp)
+	rsp.Header().Set("X-Content-Type-Options", "nosniff")
+	h.mux.ServeHTTP(rsp, req)
+}
+
+// AddFeatures appends 'features' to the list of features being served by 'h'. Features are passed through
+// any `FeatureTransformer` instances defined in the `RunOptions` used to create 'h' before being added.
+func (h *Handler) AddFeatures(ctx context.Context, features ...*geojson.Feature) error {
+
+	err := h.features.Add(ctx, features...)
+
+	if err != nil {
+		return err
+	}
+
+	if h.simplified != nil {
+		h.simplified.Update(h.features)
+	}
+
+	if h.styles != nil {
+		h.styles.Update(h.features)
+	}
+
+	return nil
+}
+
+// dataHandler returns an `http.Handler` for serving the features in 'features' as a GeoJSON FeatureCollection, encoded
+// using 'transport' (see `marshalFeatureCollection` for details). Features
+// may be filtered by bounding box, property values and paginated (see `featuresQueryFromRequest` for details). The
+// FeatureCollection includes a "show:ids" foreign member containing the "show:id" identifier for each feature and a
+// "show:total" foreign member containing the total number of features matching the query. If 'simplified' is not nil, and
+// the request includes a "z" query parameter, features are returned with the simplified geometries for that zoom level. If 'styles'
+// is not nil the FeatureCollection also includes a "show:styles" foreign member containing the style for each feature (or null for
+// features rendered using the default style). Responses are compressed (using Brotli or gzip) according to the request's "Accept-Encoding" header and include an ETag header.
+func dataHandler(features *featureCollection, simplified *simplifiedGeometries, styles *featureStyles, transport string) http.Handler {
+
+	cache := newResponseCache()
+
+	fn := func(rsp http.ResponseWriter, req *http.Request) {
+
+		q, err := featuresQueryFromRequest(req)
+
+		if err != nil {
+			http.Error(rsp, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Encoded responses are cached per version of the feature collection (and query) so that
+		// they are only marshaled and compressed once.
+
+		version := features.Version()
+		cache_key := req.URL.Query().Encode()
+
+		body, exists := cache.Get(version, cache_key)
+
+		if exists {
+			body.ServeHTTP(rsp, req)
+			return
+		}
+
+		results := features.Query(q)
+
+		if simplified != nil && q.Zoom != nil {
+			simplified.Update(features)
+			results.Features = simplified.Features(results.Features, results.Offsets, *q.Zoom)
+		}
+
+		fc := geojson.NewFeatureCollection()
+		fc.Features = results.Features
+
+		if q.IncludeProperties != nil {
+
+			for i, f := range fc.Features {
+				fc.Features[i] = selectProperties(f, q.IncludeProperties)
+			}
+		}
+
+		fc.ExtraMembers = geojson.Properties{
+			"show:ids":   results.Ids,
+			"show:total": results.Total,
+		}
+
+		if styles != nil {
+
+			styles.Update(features)
+			feature_styles := styles.Styles(results.Offsets)
+
+			if feature_styles != nil {
+				fc.ExtraMembers["show:styles"] = feature_styles
+			}
+		}
+
+		enc_fc, content_type, err := marshalFeatureCollection(fc, transport)
+
+		if err != nil {
+			slog.Error("Failed to marshal features", "transport", transport, "error", err)
+			http.Error(rsp, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		body = newEncodedBody(enc_fc, content_type)
+		// Only cache the response if the features didn't change while it was being produced.
+
+		if features.Version() == version {
+			cache.Set(version, cache_key, body)
+		}
+
+		body.ServeHTTP(rsp, req)
+		return
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+// featureHandler returns an `http.Handler` for serving the complete GeoJSON record for an individual feature in 'features'
+// identified by its (stable) "show:id" identifier. The handler expects to be registered with a "/features/{id}" pattern
+// where the final "{id}" element ends in ".geojson".
+func featureHandler(features *featureCollection) http.Handler {
+
+	fn := func(rsp http.ResponseWriter, req *http.Request) {
+
+		id, ok := strings.CutSuffix(req.PathValue("id"), ".geojson")
+
+		if !ok {
+			http.NotFound(rsp, req)
+			return
+		}
+
+		f, exists := features.Feature(id)
+
+		if !exists {
+			http.NotFound(rsp, req)
+			return
+		}
+
+		enc_json, err := f.MarshalJSON()
+
+		if err != nil {
+			slog.Error("Failed to marshal feature", "id", id, "error", err)
+			http.Error(rsp, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		body := newEncodedBody(enc_json, "application/geo+json")
+		body.ServeHTTP(rsp, req)
+		return
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+// mapConfigHandler returns an `http.Handler` for serving 'cfg' updated with details about the current state of 'features'.
+// If the number of features exceeds 'raster_threshold' (and it is greater than 0) then the web application will be instructed
+// to render features using (server-rendered) raster tiles. Otherwise, if the number of features exceeds 'cluster_threshold'
+// (and it is greater than 0) then the web application will be instructed to render point features as clusters. Otherwise, if the number of features exceeds 'vector_tile_threshold' (and it is greater
+// than 0) then the web application will be instructed to render features using vector tiles. Otherwise, if the number of features
+// exceeds 'viewport_threshold' (and it is greater than 0) the web application will be instructed to only request the features
+// inside the current map viewport.
+func mapConfigHandler(cfg *mapConfig, features *featureCollection, raster_threshold int, cluster_threshold int, vector_tile_threshold int, viewport_threshold int) http.Handler {
+
+	fn := func(rsp http.ResponseWriter, req *http.Request) {
+
+		req_cfg := *cfg
+
+		b, ok := features.Bound()
+
+		if ok {
+			req_cfg.Bounds = []float64{b.Min.X(), b.Min.Y(), b.Max.X(), b.Max.Y()}
+		}
+
+		count := features.Count()
+
+		if raster_threshold > 0 && count > raster_threshold {
+
+			req_cfg.Raster = &rasterConfig{
+				TileURL:    "raster/{z}/{x}/{y}.png",
+				NearestURL: "nearest.geojson",
+				MaxZoom:    int(raster_max_zoom),
+			}
+
+		} else if cluster_threshold > 0 && count > cluster_threshold {
+
+			clusters_url := "clusters.geojson"
+
+			if cfg.Transport == transport_geobuf {
+				clusters_url = "clusters.pbf"
+			}
+
+			req_cfg.Clusters = &clustersConfig{
+				URL:     clusters_url,
+				MaxZoom: cluster_max_zoom,
+			}
+
+		} else if vector_tile_threshold > 0 && count > vector_tile_threshold {
+
+			req_cfg.VectorTiles = &vectorTilesConfig{
+				TileURL:     "tiles/{z}/{x}/{y}.mvt",
+				Layer:       tiles_layer_name,
+				MaxDataZoom: int(tiles_max_zoom),
+			}
+
+		} else if viewport_threshold > 0 && count > viewport_threshold {
+			req_cfg.ViewportQueries = true
+		}
+
+		rsp.Header().Set("Content-type", "application/json")
+
+		enc := json.NewEncoder(rsp)
+		err := enc.Encode(req_cfg)
+
+		if err != nil {
+			slog.Error("Failed to encode map config", "error", err)
+			http.Error(rsp, "Internal server error", http.StatusInternalServerError)
+		}
+
+		return
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+// marshalFeatureCollection encodes 'fc' using 'transport' returning the encoded body and its content type. Valid
+// transports are "geojson" (the default) and "geobuf" (see https://github.com/mapbox/geobuf).
+func marshalFeatureCollection(fc *geojson.FeatureCollection, transport string) ([]byte, string, error) {
+
+	switch transport {
+	case transport_geobuf:
+
+		body, err := marshalGeobuf(fc)
+
+		if err != nil {
+			return nil, "", fmt.Errorf("Failed to marshal Geobuf, %w", err)
+		}
+
+		return body, "application/x-protobuf", nil
+
+	default:
+
+		body, err := fc.MarshalJSON()
+
+		if err != nil {
+			return nil, "", fmt.Errorf("Failed to marshal GeoJSON, %w", err)
+		}
+
+		return body, "application/json", nil
+	}
+}
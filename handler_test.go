@@ -0,0 +1,185 @@
+package show
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+)
+
+// testRunOptions returns a `RunOptions` instance for serving a small collection of features, with labels, map labels and
+// vector tiles enabled, for testing handlers.
+func testRunOptions() *RunOptions {
+
+	sfo := geojson.NewFeature(orb.Point{-122.386166, 37.616407})
+	sfo.ID = "sfo"
+	sfo.Properties["name"] = "SFO"
+
+	oak := geojson.NewFeature(orb.Point{-122.221044, 37.712453})
+	oak.ID = "oak"
+	oak.Properties["name"] = "OAK"
+
+	return &RunOptions{
+		MapProvider:         "leaflet",
+		MapTileURI:          leaflet_osm_tile_url,
+		Features:            []*geojson.Feature{sfo, oak},
+		LabelProperties:     []string{"name"},
+		MapLabel:            "name",
+		VectorTileThreshold: 1,
+	}
+}
+
+func TestNewHandlerPrefix(t *testing.T) {
+
+	ctx := context.Background()
+
+	h, err := NewHandler(ctx, testRunOptions())
+
+	if err != nil {
+		t.Fatalf("Failed to create handler, %v", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/debug/map/", http.StripPrefix("/debug/map", h))
+
+	tests := []struct {
+		path         string
+		status       int
+		content_type string
+	}{
+		{"/debug/map/", 200, "text/html"},
+		{"/debug/map/javascript/show.js", 200, "text/javascript"},
+		{"/debug/map/css/show.css", 200, "text/css"},
+		{"/debug/map/map.json", 200, "application/json"},
+		{"/debug/map/features.geojson", 200, "application/json"},
+		{"/debug/map/features.pbf", 200, "application/x-protobuf"},
+		{"/debug/map/features/sfo.geojson", 200, "application/geo+json"},
+		{"/debug/map/features/lax.geojson", 404, ""},
+		{"/debug/map/labels/sfo.html", 200, "text/html"},
+		{"/debug/map/map-labels.geojson?z=10", 200, "application/json"},
+		{"/debug/map/tiles/0/0/0.mvt", 200, "application/vnd.mapbox-vector-tile"},
+		{"/debug/map/clusters.geojson?z=10", 200, "application/json"},
+		{"/debug/map/aggregate.geojson?z=10", 200, "application/json"},
+		{"/debug/map/icons/circle", 200, "image/svg+xml"},
+		{"/map.json", 404, ""},
+	}
+
+	for _, test := range tests {
+
+		t.Run(test.path, func(t *testing.T) {
+
+			rsp := httptest.NewRecorder()
+			mux.ServeHTTP(rsp, httptest.NewRequest("GET", test.path, nil))
+
+			if rsp.Code != test.status {
+				t.Fatalf("Expected status code %d, got %d", test.status, rsp.Code)
+			}
+
+			if test.status != 200 {
+				return
+			}
+
+			if !strings.HasPrefix(rsp.Header().Get("Content-Type"), test.content_type) {
+				t.Fatalf("Expected content type %s, got %s", test.content_type, rsp.Header().Get("Content-Type"))
+			}
+
+			if rsp.Header().Get("Content-Security-Policy") == "" {
+				t.Fatalf("Missing Content-Security-Policy header")
+			}
+
+			if rsp.Header().Get("X-Content-Type-Options") != "nosniff" {
+				t.Fatalf("Missing X-Content-Type-Options header")
+			}
+		})
+	}
+
+	// All the URLs in the map config must be relative so they resolve under the prefix
+
+	rsp := httptest.NewRecorder()
+	mux.ServeHTTP(rsp, httptest.NewRequest("GET", "/debug/map/map.json", nil))
+
+	var cfg map[string]any
+
+	err = json.Unmarshal(rsp.Body.Bytes(), &cfg)
+
+	if err != nil {
+		t.Fatalf("Failed to decode map config, %v", err)
+	}
+
+	urls := configURLs(cfg, "")
+
+	for _, k := range []string{"labels.url", "map_labels.url", "vector_tiles.tile_url", "aggregate.url"} {
+
+		u, exists := urls[k]
+
+		if !exists {
+			t.Fatalf("Expected map config to contain %s, got %v", k, urls)
+		}
+
+		if strings.HasPrefix(u, "/") {
+			t.Fatalf("Expected %s to be a relative URL, got %s", k, u)
+		}
+	}
+}
+
+// configURLs returns the values of all the "url" and "*_url" keys in 'cfg' indexed by their (dot-separated) path.
+func configURLs(cfg map[string]any, prefix string) map[string]string {
+
+	urls := make(map[string]string)
+
+	for k, v := range cfg {
+
+		path := prefix + k
+
+		switch value := v.(type) {
+		case map[string]any:
+
+			for sub_k, sub_v := range configURLs(value, path+".") {
+				urls[sub_k] = sub_v
+			}
+
+		case string:
+
+			if k == "url" || strings.HasSuffix(k, "_url") {
+				urls[path] = value
+			}
+		}
+	}
+
+	return urls
+}
+
+func TestNewHandlerInvalidOptions(t *testing.T) {
+
+	ctx := context.Background()
+
+	tests := []struct {
+		name   string
+		update func(opts *RunOptions)
+	}{
+		{"transport", func(opts *RunOptions) { opts.Transport = "xml" }},
+		{"aggregate", func(opts *RunOptions) { opts.Aggregate = "triangle" }},
+		{"map label", func(opts *RunOptions) { opts.MapLabel = "{{ .Get " }},
+		{"label template", func(opts *RunOptions) { opts.LabelTemplate = "/does/not/exist.html" }},
+	}
+
+	for _, test := range tests {
+
+		t.Run(test.name, func(t *testing.T) {
+
+			opts := testRunOptions()
+			test.update(opts)
+
+			_, err := NewHandler(ctx, opts)
+
+			if err == nil {
+				t.Fatalf("Expected an error for invalid %s option", test.name)
+			}
+		})
+	}
+}
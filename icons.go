@@ -0,0 +1,348 @@
+package show
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+)
+
+// The default size, in pixels, of the icons used to render point features.
+const default_icon_size int = 24
+
+// The maximum size, in bytes, of an icon file.
+const icon_max_bytes int64 = 1024 * 1024
+
+// The placeholder in SVG icons which is replaced by the colour of individual features.
+const icon_color_placeholder string = "currentColor"
+
+// The content types for each of the image file extensions that can be used as icons.
+var icon_content_types = map[string]string{
+	".png":  "image/png",
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".gif":  "image/gif",
+	".webp": "image/webp",
+	".svg":  "image/svg+xml",
+}
+
+// The SVG symbols which are always available as icons. They are filled using the colour of individual features.
+var icon_symbols = map[string]string{
+	"circle":   `<circle cx="12" cy="12" r="9" fill="currentColor" stroke="#ffffff" stroke-width="2"/>`,
+	"square":   `<rect x="3.5" y="3.5" width="17" height="17" fill="currentColor" stroke="#ffffff" stroke-width="2"/>`,
+	"triangle": `<polygon points="12,2.5 22,20.5 2,20.5" fill="currentColor" stroke="#ffffff" stroke-width="2" stroke-linejoin="round"/>`,
+	"diamond":  `<polygon points="12,1.5 22.5,12 12,22.5 1.5,12" fill="currentColor" stroke="#ffffff" stroke-width="2" stroke-linejoin="round"/>`,
+	"star":     `<polygon points="12,1.5 15.1,8.2 22.5,9 17,14 18.5,21.3 12,17.6 5.5,21.3 7,14 1.5,9 8.9,8.2" fill="currentColor" stroke="#ffffff" stroke-width="1.5" stroke-linejoin="round"/>`,
+	"pin":      `<path d="M12 23s-8-7.6-8-13.5a8 8 0 0 1 16 0C20 15.4 12 23 12 23z" fill="currentColor" stroke="#ffffff" stroke-width="1.5"/><circle cx="12" cy="9.5" r="3" fill="#ffffff"/>`,
+}
+
+// iconConfig defines configuration details for maps rendering point features using icons.
+type iconConfig struct {
+	// A relative URI for requesting icons, to which the (URL-escaped) name of an icon is appended.
+	URL string `json:"url"`
+	// The size of icons, in pixels.
+	Size int `json:"size"`
+	// The details for each of the icons that can be requested, indexed by name.
+	Icons map[string]*iconDetails `json:"icons"`
+}
+
+// iconDetails defines configuration details for an individual icon.
+type iconDetails struct {
+	// If true the icon is an SVG image which can be assigned a colour using the "color" query parameter.
+	Colorable bool `json:"colorable,omitempty"`
+	// The point of the icon, as fractions of its width and height, that is placed at a feature's location.
+	Anchor [2]float64 `json:"anchor"`
+}
+
+// icon is an image used to render point features.
+type icon struct {
+	body         []byte
+	content_type string
+	details      *iconDetails
+}
+
+// iconSet is the set of icons, indexed by name, that can be used to render point features. It contains the built-in
+// SVG symbols and any images loaded from disk.
+type iconSet struct {
+	icons map[string]*icon
+	// The name of the icon loaded from a single image file, rather than a directory, if any.
+	file_icon string
+}
+
+// newIconSet returns a new `iconSet` instance containing the built-in SVG symbols and, if 'path' is not empty, the
+// image file or directory of image files in 'path'. Icons loaded from disk are named after their file name, without
+// its extension, and replace any built-in symbols with the same name. SVG icons containing "currentColor" values are
+// filled using the colour of individual features.
+func newIconSet(path string) (*iconSet, error) {
+
+	s := &iconSet{
+		icons: make(map[string]*icon),
+	}
+
+	for name, symbol := range icon_symbols {
+
+		body := fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 24 24" width="24" height="24">%s</svg>`, symbol)
+
+		details := &iconDetails{
+			Colorable: true,
+			Anchor:    [2]float64{0.5, 0.5},
+		}
+
+		// Pins point at the location of a feature
+
+		if name == "pin" {
+			details.Anchor = [2]float64{0.5, 23.0 / 24.0}
+		}
+
+		s.icons[name] = &icon{
+			body:         []byte(body),
+			content_type: icon_content_types[".svg"],
+			details:      details,
+		}
+	}
+
+	if path == "" {
+		return s, nil
+	}
+
+	info, err := os.Stat(path)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to stat icons, %w", err)
+	}
+
+	if !info.IsDir() {
+
+		err := s.load(path)
+
+		if err != nil {
+			return nil, err
+		}
+
+		s.file_icon = iconName(path)
+		return s, nil
+	}
+
+	entries, err := os.ReadDir(path)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read icons directory, %w", err)
+	}
+
+	for _, e := range entries {
+
+		if e.IsDir() {
+			continue
+		}
+
+		_, is_image := icon_content_types[strings.ToLower(filepath.Ext(e.Name()))]
+
+		if !is_image {
+			continue
+		}
+
+		err := s.load(filepath.Join(path, e.Name()))
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// iconName returns the name of the icon for the image file at 'path'.
+func iconName(path string) string {
+	fname := filepath.Base(path)
+	return strings.TrimSuffix(fname, filepath.Ext(fname))
+}
+
+// load adds the image file at 'path' to 's'.
+func (s *iconSet) load(path string) error {
+
+	ext := strings.ToLower(filepath.Ext(path))
+	content_type, ok := icon_content_types[ext]
+
+	if !ok {
+		return fmt.Errorf("Unsupported icon type for '%s'. Valid extensions are: png, jpg, jpeg, gif, webp, svg", path)
+	}
+
+	info, err := os.Stat(path)
+
+	if err != nil {
+		return fmt.Errorf("Failed to stat icon '%s', %w", path, err)
+	}
+
+	if info.Size() > icon_max_bytes {
+		return fmt.Errorf("Icon '%s' exceeds the maximum size of %d bytes", path, icon_max_bytes)
+	}
+
+	body, err := os.ReadFile(path)
+
+	if err != nil {
+		return fmt.Errorf("Failed to read icon '%s', %w", path, err)
+	}
+
+	details := &iconDetails{
+		Anchor: [2]float64{0.5, 0.5},
+	}
+
+	if ext == ".svg" && strings.Contains(string(body), icon_color_placeholder) {
+		details.Colorable = true
+	}
+
+	s.icons[iconName(path)] = &icon{
+		body:         body,
+		content_type: content_type,
+		details:      details,
+	}
+
+	return nil
+}
+
+// Has returns true if 's' contains an icon named 'name'.
+func (s *iconSet) Has(name string) bool {
+	_, exists := s.icons[name]
+	return exists
+}
+
+// Names returns the sorted list of icon names in 's'.
+func (s *iconSet) Names() []string {
+
+	names := make([]string, 0, len(s.icons))
+
+	for name := range s.icons {
+		names = append(names, name)
+	}
+
+	slices.Sort(names)
+	return names
+}
+
+// Config returns the `iconConfig` for the icons in 's' rendered at 'size' pixels.
+func (s *iconSet) Config(size int) *iconConfig {
+
+	cfg := &iconConfig{
+		URL:   "icons/",
+		Size:  size,
+		Icons: make(map[string]*iconDetails),
+	}
+
+	for name, i := range s.icons {
+		cfg.Icons[name] = i.details
+	}
+
+	return cfg
+}
+
+// Resolve returns the name of the icon in 's' matching the property value 'v', or an empty string if there is
+// no matching icon. Values are matched against icon names exactly and then in lower case.
+func (s *iconSet) Resolve(v any) string {
+
+	if v == nil {
+		return ""
+	}
+
+	name := strings.TrimSpace(fmt.Sprintf("%v", v))
+
+	if name == "" {
+		return ""
+	}
+
+	if s.Has(name) {
+		return name
+	}
+
+	name = strings.ToLower(name)
+
+	if s.Has(name) {
+		return name
+	}
+
+	return ""
+}
+
+// styler returns a `featureStyler` which assigns point features the icon matching the value of their 'property'
+// property, or nil if there is no matching icon.
+func (s *iconSet) styler(property string) featureStyler {
+
+	styles := make(map[string]*LeafletStyle)
+
+	for name := range s.icons {
+		styles[name] = &LeafletStyle{Icon: name}
+	}
+
+	fn := func(f *geojson.Feature) *LeafletStyle {
+
+		switch f.Geometry.(type) {
+		case orb.Point, orb.MultiPoint:
+			// pass
+		default:
+			return nil
+		}
+
+		name := s.Resolve(f.Properties[property])
+
+		if name == "" {
+			return nil
+		}
+
+		return styles[name]
+	}
+
+	return fn
+}
+
+// validateStyle returns an error if 'style' (which may be nil) refers to an icon which is not in 's'.
+func (s *iconSet) validateStyle(style *LeafletStyle) error {
+
+	if style == nil || style.Icon == "" || s.Has(style.Icon) {
+		return nil
+	}
+
+	return fmt.Errorf("Unknown icon '%s'. Valid options are: %s", style.Icon, strings.Join(s.Names(), ", "))
+}
+
+// iconsHandler returns an `http.Handler` for serving the icons in 'icons'. The handler expects to be registered
+// with a "/icons/{name}" pattern. SVG icons containing "currentColor" values are filled with the colour in the
+// optional "color" query parameter, which must be a hexadecimal colour, a CSS colour name or a CSS rgb(), rgba(), hsl()
+// or hsla() colour. Colours are written to SVG documents in their hexadecimal form.
+func iconsHandler(icons *iconSet) http.Handler {
+
+	fn := func(rsp http.ResponseWriter, req *http.Request) {
+
+		name := req.PathValue("name")
+		i, exists := icons.icons[name]
+
+		if !exists {
+			http.Error(rsp, "Not found", http.StatusNotFound)
+			return
+		}
+
+		body := i.body
+		params := req.URL.Query()
+
+		if i.details.Colorable && params.Has("color") {
+
+			c, err := parseColor(params.Get("color"), 1)
+
+			if err != nil {
+				http.Error(rsp, "Invalid color parameter", http.StatusBadRequest)
+				return
+			}
+
+			body = []byte(strings.ReplaceAll(string(body), icon_color_placeholder, colorHex(c)))
+		}
+
+		enc_body := newEncodedBody(body, i.content_type)
+		enc_body.ServeHTTP(rsp, req)
+		return
+	}
+
+	return http.HandlerFunc(fn)
+}
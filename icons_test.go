@@ -0,0 +1,66 @@
+package show
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestIconsHandlerColor(t *testing.T) {
+
+	icons, err := newIconSet("")
+
+	if err != nil {
+		t.Fatalf("Failed to create icon set, %v", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/icons/{name}", iconsHandler(icons))
+
+	tests := []struct {
+		color    string
+		status   int
+		expected string
+	}{
+		{"#ff0000", 200, "#ff0000"},
+		{"#f00", 200, "#ff0000"},
+		{"#ff000080", 200, "#ff000080"},
+		{"red", 200, "#ff0000"},
+		{"RebeccaPurple", 200, "#663399"},
+		{"rgb(255, 0, 0)", 200, "#ff0000"},
+		{"rgba(255,0,0,0.5)", 200, "#ff000080"},
+		{"rgb(255 0 0 / 50%)", 200, "#ff000080"},
+		{"hsl(120, 100%, 50%)", 200, "#00ff00"},
+		{"hsla(120deg 100% 50% / 0.5)", 200, "#00ff0080"},
+		{"rgb(255, 0)", 400, ""},
+		{"rgb(255, 0, 0", 400, ""},
+		{"url(#gradient)", 400, ""},
+		{`red" onload="alert(1)`, 400, ""},
+		{"rgb(255,0,0);fill:blue", 400, ""},
+		{"calc(1)", 400, ""},
+		{"notacolour", 400, ""},
+	}
+
+	for _, test := range tests {
+
+		t.Run(test.color, func(t *testing.T) {
+
+			rsp := httptest.NewRecorder()
+			mux.ServeHTTP(rsp, httptest.NewRequest("GET", "/icons/circle?color="+url.QueryEscape(test.color), nil))
+
+			if rsp.Code != test.status {
+				t.Fatalf("Expected status code %d, got %d", test.status, rsp.Code)
+			}
+
+			if test.status != 200 {
+				return
+			}
+
+			if !strings.Contains(rsp.Body.String(), test.expected) {
+				t.Fatalf("Expected icon to be filled with %s", test.expected)
+			}
+		})
+	}
+}
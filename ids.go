@@ -0,0 +1,72 @@
+package show
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/paulmach/orb/geojson"
+)
+
+// The number of hex characters of a feature's SHA-256 content hash used for its identifier.
+const id_hash_length int = 16
+
+// featureId derives a stable identifier for 'f'. If 'id_property' is not empty and 'f' has a matching property
+// its value is used. Otherwise the feature's GeoJSON "id" member is used, if present. Failing both the identifier
+// is derived from a hash of the feature's (JSON-encoded) contents.
+func featureId(f *geojson.Feature, id_property string) (string, error) {
+
+	if id_property != "" {
+
+		v, exists := f.Properties[id_property]
+
+		if exists && v != nil {
+
+			str_id := idToString(v)
+
+			if str_id != "" {
+				return str_id, nil
+			}
+		}
+	}
+
+	if f.ID != nil {
+
+		str_id := idToString(f.ID)
+
+		if str_id != "" {
+			return str_id, nil
+		}
+	}
+
+	enc, err := f.MarshalJSON()
+
+	if err != nil {
+		return "", fmt.Errorf("Failed to marshal feature, %w", err)
+	}
+
+	sum := sha256.Sum256(enc)
+	return hex.EncodeToString(sum[:])[:id_hash_length], nil
+}
+
+func idToString(v any) string {
+
+	var str_id string
+
+	switch v.(type) {
+	case float64:
+		// Avoid scientific notation for large numeric identifiers (like WOF IDs)
+		str_id = fmt.Sprintf("%.0f", v.(float64))
+
+		if float64(int64(v.(float64))) != v.(float64) {
+			str_id = fmt.Sprintf("%v", v)
+		}
+
+	default:
+		str_id = fmt.Sprintf("%v", v)
+	}
+
+	// Identifiers are used in URL paths
+	return strings.ReplaceAll(strings.TrimSpace(str_id), "/", "-")
+}
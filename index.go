@@ -0,0 +1,133 @@
+package show
+
+import (
+	"slices"
+	"sort"
+
+	"github.com/paulmach/orb"
+)
+
+// The maximum number of children for each node in a spatialIndex.
+const rtree_node_size int = 16
+
+// spatialIndex is a static, in-memory R-tree of feature bounding boxes, packed using the Sort-Tile-Recursive (STR)
+// algorithm. Items in the index are identified by their offset in a feature collection.
+type spatialIndex struct {
+	root *rtreeNode
+}
+
+type rtreeNode struct {
+	bound    orb.Bound
+	children []*rtreeNode
+	// offset is the feature offset for leaf nodes. It is -1 for interior nodes.
+	offset int
+}
+
+// newSpatialIndex returns a new `spatialIndex` for 'bounds' where each bounding box is identified by the
+// corresponding offset in 'offsets'.
+func newSpatialIndex(bounds []orb.Bound, offsets []int) *spatialIndex {
+
+	nodes := make([]*rtreeNode, len(bounds))
+
+	for i, b := range bounds {
+		nodes[i] = &rtreeNode{
+			bound:  b,
+			offset: offsets[i],
+		}
+	}
+
+	idx := &spatialIndex{}
+
+	if len(nodes) == 0 {
+		return idx
+	}
+
+	for len(nodes) > 1 {
+		nodes = packNodes(nodes)
+	}
+
+	idx.root = nodes[0]
+	return idx
+}
+
+// Search returns the sorted list of offsets whose bounding boxes intersect 'b'.
+func (idx *spatialIndex) Search(b orb.Bound) []int {
+
+	results := make([]int, 0)
+
+	if idx.root == nil {
+		return results
+	}
+
+	stack := []*rtreeNode{idx.root}
+
+	for len(stack) > 0 {
+
+		n := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		if !n.bound.Intersects(b) {
+			continue
+		}
+
+		if n.children == nil {
+			results = append(results, n.offset)
+			continue
+		}
+
+		stack = append(stack, n.children...)
+	}
+
+	slices.Sort(results)
+	return results
+}
+
+// packNodes groups 'nodes' in to parent nodes using the Sort-Tile-Recursive algorithm.
+func packNodes(nodes []*rtreeNode) []*rtreeNode {
+
+	count := len(nodes)
+	count_parents := (count + rtree_node_size - 1) / rtree_node_size
+
+	slices_count := 1
+
+	for slices_count*slices_count < count_parents {
+		slices_count += 1
+	}
+
+	slice_size := slices_count * rtree_node_size
+
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].bound.Center().X() < nodes[j].bound.Center().X()
+	})
+
+	parents := make([]*rtreeNode, 0, count_parents)
+
+	for start := 0; start < count; start += slice_size {
+
+		end := min(start+slice_size, count)
+		slice := nodes[start:end]
+
+		sort.Slice(slice, func(i, j int) bool {
+			return slice[i].bound.Center().Y() < slice[j].bound.Center().Y()
+		})
+
+		for i := 0; i < len(slice); i += rtree_node_size {
+
+			children := slices.Clone(slice[i:min(i+rtree_node_size, len(slice))])
+
+			b := children[0].bound
+
+			for _, c := range children[1:] {
+				b = b.Union(c.bound)
+			}
+
+			parents = append(parents, &rtreeNode{
+				bound:    b,
+				children: children,
+				offset:   -1,
+			})
+		}
+	}
+
+	return parents
+}
@@ -0,0 +1,85 @@
+package show
+
+import (
+	"fmt"
+	"math/rand"
+	"slices"
+	"testing"
+
+	"github.com/paulmach/orb"
+)
+
+// randomBound returns a bounding box with a random location and a size of up to 'size' degrees.
+func randomBound(r *rand.Rand, size float64) orb.Bound {
+
+	x := r.Float64()*360 - 180
+	y := r.Float64()*180 - 90
+
+	return orb.Bound{
+		Min: orb.Point{x, y},
+		Max: orb.Point{x + r.Float64()*size, y + r.Float64()*size},
+	}
+}
+
+func TestSpatialIndexSearch(t *testing.T) {
+
+	tests := []struct {
+		count int
+		size  float64
+	}{
+		{0, 10},
+		{1, 10},
+		{rtree_node_size, 10},
+		{rtree_node_size + 1, 10},
+		{1000, 0},
+		{1000, 5},
+		{5000, 1},
+	}
+
+	for _, test := range tests {
+
+		t.Run(fmt.Sprintf("%d/%f", test.count, test.size), func(t *testing.T) {
+
+			r := rand.New(rand.NewSource(int64(test.count)))
+
+			bounds := make([]orb.Bound, test.count)
+			offsets := make([]int, test.count)
+
+			for i := 0; i < test.count; i++ {
+				bounds[i] = randomBound(r, test.size)
+				offsets[i] = i * 2
+			}
+
+			idx := newSpatialIndex(bounds, offsets)
+
+			queries := []orb.Bound{
+				{Min: orb.Point{-180, -90}, Max: orb.Point{180, 90}},
+				{Min: orb.Point{200, 100}, Max: orb.Point{210, 110}},
+			}
+
+			for i := 0; i < 100; i++ {
+				queries = append(queries, randomBound(r, 30))
+			}
+
+			for _, q := range queries {
+
+				// Compare the results with a brute-force search
+
+				expected := make([]int, 0)
+
+				for i, b := range bounds {
+
+					if b.Intersects(q) {
+						expected = append(expected, offsets[i])
+					}
+				}
+
+				results := idx.Search(q)
+
+				if !slices.Equal(results, expected) {
+					t.Fatalf("Search for %v returned %d results, expected %d", q, len(results), len(expected))
+				}
+			}
+		})
+	}
+}
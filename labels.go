@@ -0,0 +1,320 @@
+package show
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/paulmach/orb/geojson"
+	"github.com/tidwall/gjson"
+)
+
+// The file extensions of URLs which are rendered as image thumbnails in default labels.
+var label_image_extensions = []string{".jpg", ".jpeg", ".png", ".gif", ".webp", ".svg"}
+
+// The template used to render labels for a list of properties. Values which are http(s) URLs are rendered
+// as links or, for images, as thumbnails.
+var default_label_template = template.Must(template.New("label").Parse(`{{ range . -}}
+<div class="show-label-property"><strong>{{ .Name }}</strong> {{ if .HTML }}{{ .HTML }}{{ else if .Image }}<a href="{{ .URL }}" target="_blank" rel="noopener noreferrer"><img class="show-thumbnail" src="{{ .URL }}" alt="{{ .Name }}" /></a>{{ else if .URL }}<a href="{{ .URL }}" target="_blank" rel="noopener noreferrer">{{ .Value }}</a>{{ else }}{{ .Value }}{{ end }}</div>
+{{ end }}`))
+
+// labelsConfig defines configuration details for maps displaying labels (in popups) for features when they are clicked.
+type labelsConfig struct {
+	// A relative URI for requesting the (HTML) label for a feature, to which the (URL-escaped) "show:id" identifier of the
+	// feature and a ".html" suffix are appended.
+	URL string `json:"url"`
+}
+
+// labelProperty is an individual property rendered by the default label template.
+type labelProperty struct {
+	Name  string
+	Value string
+	URL   string
+	Image bool
+	// HTML is the (unescaped) value of properties which are trusted to contain HTML.
+	HTML template.HTML
+}
+
+// labelData is the data passed to custom label templates for each feature.
+type labelData struct {
+	// The "show:id" identifier for the feature.
+	Id string
+	// The feature's properties.
+	Properties geojson.Properties
+	// The JSON-encoded properties, used to query properties by path.
+	enc_properties []byte
+	// The properties whose values are trusted to contain HTML.
+	trusted map[string]bool
+}
+
+// Get returns the value of the property at 'p', which may be a top-level property name or a gjson path (for example
+// "wof:hierarchy.0.country_id"), or nil if there is no matching property. Numbers are returned as `json.Number` values, to
+// prevent large identifiers being rendered in exponent notation, and objects and lists are returned as JSON-encoded strings.
+// The string values of trusted HTML properties are returned as `template.HTML` values which are not escaped.
+func (d *labelData) Get(p string) any {
+
+	v, ok := propertyValue(d.Properties, d.enc_properties, p)
+
+	if !ok {
+		return nil
+	}
+
+	switch n := v.(type) {
+	case string:
+
+		if d.trusted[p] {
+			return template.HTML(n)
+		}
+
+		return n
+	case float64:
+		return json.Number(strconv.FormatFloat(n, 'f', -1, 64))
+	case map[string]any, []any:
+		return labelValue(v)
+	}
+
+	return v
+}
+
+// featureLabeler renders the (HTML) labels displayed in popups when features are clicked.
+type featureLabeler struct {
+	properties []string
+	template   *template.Template
+	trusted    map[string]bool
+}
+
+// newFeatureLabeler returns a new `featureLabeler` instance rendering labels for the properties (top-level property
+// names or gjson paths) in 'properties' or, if 'template_path' is not empty, using the Go template in 'template_path'.
+// Templates use the `text/template` syntax and are rendered using `html/template` so values are HTML-escaped. Templates
+// are passed a `labelData` instance for each feature, for example:
+//
+//	<a href="{{ .Get "wof:repo_url" }}">{{ .Get "wof:name" }}</a>
+//	<img src="{{ .Get "media.thumbnail" }}" class="show-thumbnail" />
+//
+// All values are HTML-escaped except the string values of the properties (top-level property names or gjson paths)
+// in 'trusted' which are rendered as-is. Returns nil if there are no properties or template.
+func newFeatureLabeler(properties []string, template_path string, trusted []string) (*featureLabeler, error) {
+
+	if len(properties) == 0 && template_path == "" {
+		return nil, nil
+	}
+
+	l := &featureLabeler{
+		properties: properties,
+		trusted:    make(map[string]bool),
+	}
+
+	for _, p := range trusted {
+		l.trusted[p] = true
+	}
+
+	if template_path != "" {
+
+		body, err := os.ReadFile(template_path)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read label template, %w", err)
+		}
+
+		t, err := template.New("label").Option("missingkey=zero").Parse(string(body))
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse label template, %w", err)
+		}
+
+		l.template = t
+	}
+
+	return l, nil
+}
+
+// Label returns the (HTML) label for 'f', identified by 'id', or an empty string if 'f' has none of the properties
+// to display.
+func (l *featureLabeler) Label(f *geojson.Feature, id string) (string, error) {
+
+	props := f.Properties
+
+	if props == nil {
+		props = geojson.Properties{}
+	}
+
+	enc_props, err := json.Marshal(props)
+
+	if err != nil {
+		return "", fmt.Errorf("Failed to marshal properties, %w", err)
+	}
+
+	var buf bytes.Buffer
+
+	if l.template != nil {
+
+		data := &labelData{
+			Id:             id,
+			Properties:     props,
+			enc_properties: enc_props,
+			trusted:        l.trusted,
+		}
+
+		err := l.template.Execute(&buf, data)
+
+		if err != nil {
+			return "", fmt.Errorf("Failed to render label template, %w", err)
+		}
+
+		return strings.TrimSpace(buf.String()), nil
+	}
+
+	label_props := make([]*labelProperty, 0, len(l.properties))
+
+	for _, p := range l.properties {
+
+		v, ok := propertyValue(props, enc_props, p)
+
+		if !ok || v == nil {
+			continue
+		}
+
+		label_p := newLabelProperty(p, v)
+
+		str_v, is_string := v.(string)
+
+		if is_string && l.trusted[p] {
+			label_p.HTML = template.HTML(str_v)
+		}
+
+		label_props = append(label_props, label_p)
+	}
+
+	if len(label_props) == 0 {
+		return "", nil
+	}
+
+	err = default_label_template.Execute(&buf, label_props)
+
+	if err != nil {
+		return "", fmt.Errorf("Failed to render label, %w", err)
+	}
+
+	return strings.TrimSpace(buf.String()), nil
+}
+
+// newLabelProperty returns a new `labelProperty` instance for the property 'name' with value 'v'.
+func newLabelProperty(name string, v any) *labelProperty {
+
+	str_v := labelValue(v)
+
+	p := &labelProperty{
+		Name:  name,
+		Value: str_v,
+	}
+
+	u, err := url.Parse(strings.TrimSpace(str_v))
+
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return p
+	}
+
+	p.URL = u.String()
+
+	ext := strings.ToLower(path.Ext(u.Path))
+
+	for _, image_ext := range label_image_extensions {
+
+		if ext == image_ext {
+			p.Image = true
+			break
+		}
+	}
+
+	return p
+}
+
+// labelValue returns the string representation of the property value 'v'. Numbers are formatted without exponents
+// and objects and lists are JSON-encoded.
+func labelValue(v any) string {
+
+	switch n := v.(type) {
+	case float64:
+		return strconv.FormatFloat(n, 'f', -1, 64)
+	case map[string]any, []any:
+
+		enc, err := json.Marshal(v)
+
+		if err != nil {
+			return ""
+		}
+
+		return string(enc)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+// propertyValue returns the value of the property at 'p' in 'props', whose JSON encoding is 'enc_props'. If 'props'
+// does not contain a top-level property named 'p' then 'p' is treated as a gjson path.
+func propertyValue(props geojson.Properties, enc_props []byte, p string) (any, bool) {
+
+	v, exists := props[p]
+
+	if exists {
+		return v, true
+	}
+
+	r := gjson.GetBytes(enc_props, p)
+
+	if !r.Exists() {
+		return nil, false
+	}
+
+	return r.Value(), true
+}
+
+// labelHandler returns an `http.Handler` for serving the (HTML) label for an individual feature in 'features', identified
+// by its (stable) "show:id" identifier, rendered using 'labeler'. Features without a label return an empty response with a
+// 204 status code. The handler expects to be registered with a "/labels/{id}" pattern where the final "{id}" element ends in ".html".
+func labelHandler(features *featureCollection, labeler *featureLabeler) http.Handler {
+
+	fn := func(rsp http.ResponseWriter, req *http.Request) {
+
+		id, ok := strings.CutSuffix(req.PathValue("id"), ".html")
+
+		if !ok {
+			http.NotFound(rsp, req)
+			return
+		}
+
+		f, exists := features.Feature(id)
+
+		if !exists {
+			http.NotFound(rsp, req)
+			return
+		}
+
+		label, err := labeler.Label(f, id)
+
+		if err != nil {
+			slog.Error("Failed to render label", "id", id, "error", err)
+			http.Error(rsp, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		if label == "" {
+			rsp.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		body := newEncodedBody([]byte(label), "text/html; charset=utf-8")
+		body.ServeHTTP(rsp, req)
+		return
+	}
+
+	return http.HandlerFunc(fn)
+}
@@ -0,0 +1,248 @@
+package show
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+)
+
+// testLabelFeature returns a feature with properties containing markup, URLs and nested values.
+func testLabelFeature() *geojson.Feature {
+
+	f := geojson.NewFeature(orb.Point{-122.386166, 37.616407})
+
+	f.Properties = geojson.Properties{
+		"wof:name":      `SFO <script>alert("name")</script>`,
+		"wof:id":        float64(102527513),
+		"wof:hierarchy": []any{map[string]any{"country_id": float64(85633793)}},
+		"wof:repo_url":  "https://github.com/sfomuseum-data/sfomuseum-data-architecture",
+		"thumbnail":     "https://static.sfomuseum.org/media/102527513.jpg",
+		"bad_url":       "javascript:alert(1)",
+		"description":   `<em>Terminal</em> <img src=x onerror="alert(1)">`,
+	}
+
+	return f
+}
+
+func TestFeatureLabelerDefault(t *testing.T) {
+
+	tests := []struct {
+		name       string
+		properties []string
+		trusted    []string
+		includes   []string
+		excludes   []string
+	}{
+		{
+			name:       "escaped",
+			properties: []string{"wof:name"},
+			includes:   []string{`SFO &lt;script&gt;alert(&#34;name&#34;)&lt;/script&gt;`},
+			excludes:   []string{"<script>"},
+		},
+		{
+			name:       "numbers",
+			properties: []string{"wof:id"},
+			includes:   []string{"102527513"},
+			excludes:   []string{"e+08"},
+		},
+		{
+			name:       "gjson path",
+			properties: []string{"wof:hierarchy.0.country_id"},
+			includes:   []string{"<strong>wof:hierarchy.0.country_id</strong> 85633793"},
+		},
+		{
+			name:       "link",
+			properties: []string{"wof:repo_url"},
+			includes:   []string{`<a href="https://github.com/sfomuseum-data/sfomuseum-data-architecture" target="_blank" rel="noopener noreferrer">`},
+		},
+		{
+			name:       "thumbnail",
+			properties: []string{"thumbnail"},
+			includes:   []string{`<img class="show-thumbnail" src="https://static.sfomuseum.org/media/102527513.jpg"`},
+		},
+		{
+			name:       "unsafe url",
+			properties: []string{"bad_url"},
+			includes:   []string{"javascript:alert(1)"},
+			excludes:   []string{"href"},
+		},
+		{
+			name:       "untrusted html",
+			properties: []string{"description"},
+			includes:   []string{"&lt;em&gt;Terminal&lt;/em&gt;"},
+			excludes:   []string{"<img", "<em>"},
+		},
+		{
+			name:       "trusted html",
+			properties: []string{"description", "wof:name"},
+			trusted:    []string{"description"},
+			includes:   []string{`<em>Terminal</em> <img src=x onerror="alert(1)">`, "SFO &lt;script&gt;"},
+		},
+	}
+
+	for _, test := range tests {
+
+		t.Run(test.name, func(t *testing.T) {
+
+			l, err := newFeatureLabeler(test.properties, "", test.trusted)
+
+			if err != nil {
+				t.Fatalf("Failed to create labeler, %v", err)
+			}
+
+			label, err := l.Label(testLabelFeature(), "sfo")
+
+			if err != nil {
+				t.Fatalf("Failed to render label, %v", err)
+			}
+
+			assertLabel(t, label, test.includes, test.excludes)
+		})
+	}
+}
+
+func TestFeatureLabelerTemplate(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		template string
+		trusted  []string
+		includes []string
+		excludes []string
+	}{
+		{
+			name:     "escaped",
+			template: `<h1>{{ .Get "wof:name" }}</h1>`,
+			includes: []string{`<h1>SFO &lt;script&gt;alert(&#34;name&#34;)&lt;/script&gt;</h1>`},
+			excludes: []string{"<script>"},
+		},
+		{
+			name:     "unsafe url",
+			template: `<a href="{{ .Get "bad_url" }}">link</a>`,
+			includes: []string{`href="#ZgotmplZ"`},
+			excludes: []string{"javascript:"},
+		},
+		{
+			name:     "attribute",
+			template: `<img src="{{ .Get "thumbnail" }}" alt="{{ .Get "wof:name" }}" />`,
+			includes: []string{`src="https://static.sfomuseum.org/media/102527513.jpg"`, `alt="SFO &lt;script&gt;`},
+		},
+		{
+			name:     "values",
+			template: `{{ .Id }} {{ .Get "wof:id" }} {{ .Get "wof:hierarchy.0.country_id" }} [{{ .Get "missing" }}]`,
+			includes: []string{"sfo 102527513 85633793 []"},
+		},
+		{
+			name:     "trusted html",
+			template: `{{ .Get "description" }}`,
+			trusted:  []string{"description"},
+			includes: []string{`<em>Terminal</em>`},
+		},
+	}
+
+	for _, test := range tests {
+
+		t.Run(test.name, func(t *testing.T) {
+
+			template_path := filepath.Join(t.TempDir(), "label.html")
+
+			err := os.WriteFile(template_path, []byte(test.template), 0644)
+
+			if err != nil {
+				t.Fatalf("Failed to write template, %v", err)
+			}
+
+			l, err := newFeatureLabeler(nil, template_path, test.trusted)
+
+			if err != nil {
+				t.Fatalf("Failed to create labeler, %v", err)
+			}
+
+			label, err := l.Label(testLabelFeature(), "sfo")
+
+			if err != nil {
+				t.Fatalf("Failed to render label, %v", err)
+			}
+
+			assertLabel(t, label, test.includes, test.excludes)
+		})
+	}
+}
+
+// assertLabel fails 't' if 'label' does not contain all the strings in 'includes' or contains any of the strings in 'excludes'.
+func assertLabel(t *testing.T, label string, includes []string, excludes []string) {
+
+	for _, str := range includes {
+
+		if !strings.Contains(label, str) {
+			t.Fatalf("Expected label to contain '%s', got %s", str, label)
+		}
+	}
+
+	for _, str := range excludes {
+
+		if strings.Contains(label, str) {
+			t.Fatalf("Expected label not to contain '%s', got %s", str, label)
+		}
+	}
+}
+
+func TestLabelHandler(t *testing.T) {
+
+	ctx := context.Background()
+
+	f := testLabelFeature()
+	f.ID = "sfo"
+
+	unlabeled := geojson.NewFeature(orb.Point{0, 0})
+	unlabeled.ID = "unlabeled"
+
+	features, err := newFeatureCollection(ctx, []*geojson.Feature{f, unlabeled}, nil, "")
+
+	if err != nil {
+		t.Fatalf("Failed to create feature collection, %v", err)
+	}
+
+	l, err := newFeatureLabeler([]string{"wof:name"}, "", nil)
+
+	if err != nil {
+		t.Fatalf("Failed to create labeler, %v", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/labels/{id}", labelHandler(features, l))
+
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{"/labels/sfo.html", 200},
+		{"/labels/unlabeled.html", 204},
+		{"/labels/lax.html", 404},
+		{"/labels/sfo", 404},
+	}
+
+	for _, test := range tests {
+
+		t.Run(test.path, func(t *testing.T) {
+
+			rsp := httptest.NewRecorder()
+			mux.ServeHTTP(rsp, httptest.NewRequest("GET", test.path, nil))
+
+			if rsp.Code != test.status {
+				t.Fatalf("Expected status code %d, got %d", test.status, rsp.Code)
+			}
+
+			if test.status == 200 && strings.Contains(rsp.Body.String(), "<script>") {
+				t.Fatalf("Expected label to be escaped, got %s", rsp.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,525 @@
+package show
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+	"github.com/paulmach/orb/planar"
+	"github.com/paulmach/orb/simplify"
+)
+
+// The maximum number of labels returned by a single request for map labels.
+const map_labels_max int = 500
+
+// The size, in pixels, of the grid cells used to discard map labels which would (almost certainly) collide at a given zoom
+// level. Only the highest priority label in each cell is returned and the web application handles any remaining collisions.
+const map_labels_cell_size float64 = 48
+
+// The ways in which map labels are placed relative to their features.
+const (
+	// Labels are drawn next to point features.
+	map_label_point string = "point"
+	// Labels are drawn centered on a point inside polygon features.
+	map_label_center string = "center"
+	// Labels are drawn along line features.
+	map_label_line string = "line"
+)
+
+// mapLabelsConfig defines configuration details for maps drawing permanent text labels for features.
+type mapLabelsConfig struct {
+	// A relative URI for requesting the labels for the current map viewport and zoom level.
+	URL string `json:"url"`
+}
+
+// mapLabel is the permanent text label for an individual feature.
+type mapLabel struct {
+	text string
+	// The location of the label for point and polygon features and the midpoint of 'line' for line features.
+	point orb.Point
+	// The line along which the label is drawn for line features.
+	line orb.LineString
+	// The way in which the label is placed relative to its feature.
+	placement string
+	// The priority of the label. Labels for larger features have a higher priority.
+	priority float64
+}
+
+// mapLabelData is the data passed to map label templates for each feature. Unlike `labelData` the `Get` method returns an empty
+// string, rather than nil, for missing properties since `text/template` renders nil values as "<no value>".
+type mapLabelData struct {
+	*labelData
+}
+
+// Get returns the value of the property at 'p' as described in `labelData.Get` or an empty string if there is no matching property.
+func (d *mapLabelData) Get(p string) any {
+
+	v := d.labelData.Get(p)
+
+	if v == nil {
+		return ""
+	}
+
+	return v
+}
+
+// mapLabeler derives the (plain text) permanent map labels for features.
+type mapLabeler struct {
+	property string
+	template *template.Template
+}
+
+// newMapLabeler returns a new `mapLabeler` instance for 'label' which is either a property (a top-level property name or a
+// gjson path) or, if it contains "{{", a Go template using the `text/template` syntax. Templates are passed a `mapLabelData`
+// instance for each feature, for example:
+//
+//	{{ .Get "wof:name" }} ({{ .Get "wof:placetype" }})
+func newMapLabeler(label string) (*mapLabeler, error) {
+
+	l := &mapLabeler{}
+
+	if !strings.Contains(label, "{{") {
+		l.property = label
+		return l, nil
+	}
+
+	t, err := template.New("map_label").Option("missingkey=zero").Parse(label)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse map label template, %w", err)
+	}
+
+	l.template = t
+	return l, nil
+}
+
+// Text returns the label for 'f', identified by 'id', with any runs of whitespace collapsed to a single space. It returns an
+// empty string if 'f' does not have a label.
+func (l *mapLabeler) Text(f *geojson.Feature, id string) (string, error) {
+
+	props := f.Properties
+
+	if props == nil {
+		props = geojson.Properties{}
+	}
+
+	enc_props, err := json.Marshal(props)
+
+	if err != nil {
+		return "", fmt.Errorf("Failed to marshal properties, %w", err)
+	}
+
+	var text string
+
+	if l.template != nil {
+
+		data := &mapLabelData{
+			labelData: &labelData{
+				Id:             id,
+				Properties:     props,
+				enc_properties: enc_props,
+			},
+		}
+
+		var buf bytes.Buffer
+		err := l.template.Execute(&buf, data)
+
+		if err != nil {
+			return "", fmt.Errorf("Failed to render map label template, %w", err)
+		}
+
+		text = buf.String()
+
+	} else {
+
+		v, ok := propertyValue(props, enc_props, l.property)
+
+		if !ok || v == nil {
+			return "", nil
+		}
+
+		text = labelValue(v)
+	}
+
+	return strings.Join(strings.Fields(text), " "), nil
+}
+
+// newMapLabel returns the `mapLabel` with 'text' for the geometry 'g'. Points are labeled at their location, polygons at their centroid
+// (or a point inside the polygon if the centroid is not) and lines along their length. Multi-geometries are labeled using their largest part.
+func newMapLabel(text string, g orb.Geometry) *mapLabel {
+
+	l := &mapLabel{
+		text:      text,
+		placement: map_label_center,
+	}
+
+	switch geom := g.(type) {
+	case orb.Point:
+		l.point = geom
+		l.placement = map_label_point
+	case orb.MultiPoint:
+
+		if len(geom) == 0 {
+			return nil
+		}
+
+		l.point = geom[0]
+		l.placement = map_label_point
+
+	case orb.LineString:
+		l.line = geom
+	case orb.MultiLineString:
+
+		for _, ls := range geom {
+
+			if l.line == nil || planar.Length(ls) > planar.Length(l.line) {
+				l.line = ls
+			}
+		}
+
+	case orb.Polygon:
+
+		if len(geom) == 0 {
+			return nil
+		}
+
+		l.point = polygonLabelPoint(geom)
+		l.priority = math.Sqrt(planar.Area(geom))
+	case orb.MultiPolygon:
+
+		var largest orb.Polygon
+		largest_area := -1.0
+
+		for _, p := range geom {
+
+			if len(p) == 0 {
+				continue
+			}
+
+			area := planar.Area(p)
+
+			if area > largest_area {
+				largest = p
+				largest_area = area
+			}
+		}
+
+		if largest == nil {
+			return nil
+		}
+
+		l.point = polygonLabelPoint(largest)
+		l.priority = math.Sqrt(largest_area)
+
+	default:
+
+		if g == nil {
+			return nil
+		}
+
+		l.point = g.Bound().Center()
+	}
+
+	if l.line != nil {
+
+		if len(l.line) < 2 {
+			return nil
+		}
+
+		l.point = lineMidpoint(l.line)
+		l.priority = planar.Length(l.line)
+		l.placement = map_label_line
+	}
+
+	return l
+}
+
+// polygonLabelPoint returns the centroid of 'p' or, if the centroid is outside 'p', the middle of the widest span of 'p' along the
+// horizontal line through its centroid.
+func polygonLabelPoint(p orb.Polygon) orb.Point {
+
+	c, _ := planar.CentroidArea(p)
+
+	if planar.PolygonContains(p, c) {
+		return c
+	}
+
+	y := c.Y()
+	xs := make([]float64, 0)
+
+	for _, r := range p {
+
+		for i := 1; i < len(r); i++ {
+
+			a := r[i-1]
+			b := r[i]
+
+			if (a.Y() > y) != (b.Y() > y) {
+				xs = append(xs, a.X()+(y-a.Y())*(b.X()-a.X())/(b.Y()-a.Y()))
+			}
+		}
+	}
+
+	slices.Sort(xs)
+
+	// Crossings alternate between entering and leaving the polygon (including its holes)
+
+	pt := p.Bound().Center()
+	widest := 0.0
+
+	for i := 1; i < len(xs); i += 2 {
+
+		w := xs[i] - xs[i-1]
+
+		if w > widest {
+			pt = orb.Point{xs[i-1] + w/2, y}
+			widest = w
+		}
+	}
+
+	return pt
+}
+
+// lineMidpoint returns the point halfway along the length of 'ls'.
+func lineMidpoint(ls orb.LineString) orb.Point {
+
+	target := planar.Length(ls) / 2
+
+	for i := 1; i < len(ls); i++ {
+
+		d := planar.Distance(ls[i-1], ls[i])
+
+		if target > d {
+			target -= d
+			continue
+		}
+
+		if d == 0 {
+			return ls[i]
+		}
+
+		t := target / d
+		return orb.Point{ls[i-1].X() + (ls[i].X()-ls[i-1].X())*t, ls[i-1].Y() + (ls[i].Y()-ls[i-1].Y())*t}
+	}
+
+	return ls[len(ls)-1]
+}
+
+// mapLabels caches the permanent map labels derived by a `mapLabeler` for each feature in a `featureCollection`. Since
+// feature collections are append-only labels are stored by offset and only derived for features added since the last update.
+type mapLabels struct {
+	mu      *sync.RWMutex
+	labeler *mapLabeler
+	labels  []*mapLabel
+}
+
+// newMapLabels returns a new `mapLabels` instance deriving labels for 'features' using 'labeler'.
+func newMapLabels(features *featureCollection, labeler *mapLabeler) *mapLabels {
+
+	l := &mapLabels{
+		mu:      new(sync.RWMutex),
+		labeler: labeler,
+		labels:  make([]*mapLabel, 0),
+	}
+
+	l.Update(features)
+	return l
+}
+
+// Update derives the labels for any features in 'features' which have been added since the last update.
+func (l *mapLabels) Update(features *featureCollection) {
+
+	fc, ids := features.snapshot()
+
+	l.mu.RLock()
+	count := len(l.labels)
+	l.mu.RUnlock()
+
+	if count >= len(fc) {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for i := len(l.labels); i < len(fc); i++ {
+
+		f := fc[i]
+
+		text, err := l.labeler.Text(f, ids[i])
+
+		if err != nil {
+			slog.Warn("Failed to derive map label", "id", ids[i], "error", err)
+		}
+
+		var label *mapLabel
+
+		if text != "" {
+			label = newMapLabel(text, f.Geometry)
+		}
+
+		l.labels = append(l.labels, label)
+	}
+}
+
+// Label returns the label for the feature at 'offset' or nil if it does not have one.
+func (l *mapLabels) Label(offset int) *mapLabel {
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if offset < 0 || offset >= len(l.labels) {
+		return nil
+	}
+
+	return l.labels[offset]
+}
+
+// mapLabelsHandler returns an `http.Handler` for serving the permanent text labels, derived by 'labels', for the features in 'features'
+// as a GeoJSON FeatureCollection. Valid query parameters are "z" (the zoom level, required), "bbox" (minx,miny,maxx,maxy) and zero or
+// more "property" parameters as described in `featuresQueryFromRequest`. Labels for point and polygon features are returned as Point
+// features and labels for line features as (simplified) LineString features along which the label is drawn. Each feature has a "show:label"
+// property containing the text of the label and a "show:placement" property ("point", "center" or "line") describing how it is drawn. Labels for larger features are returned first and only the first label in each cell of a
+// grid of `map_labels_cell_size` pixels is returned, up to a maximum of `map_labels_max` labels.
+func mapLabelsHandler(features *featureCollection, labels *mapLabels) http.Handler {
+
+	cache := newResponseCache()
+
+	fn := func(rsp http.ResponseWriter, req *http.Request) {
+
+		q, err := featuresQueryFromRequest(req)
+
+		if err != nil {
+			http.Error(rsp, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if q.Zoom == nil {
+			http.Error(rsp, "Missing z parameter", http.StatusBadRequest)
+			return
+		}
+
+		version := features.Version()
+		cache_key := req.URL.Query().Encode()
+
+		body, exists := cache.Get(version, cache_key)
+
+		if exists {
+			body.ServeHTTP(rsp, req)
+			return
+		}
+
+		labels.Update(features)
+
+		fc := mapLabelsFeatureCollection(features, labels, q.Bound, *q.Zoom, q.Properties)
+
+		enc_json, err := fc.MarshalJSON()
+
+		if err != nil {
+			slog.Error("Failed to marshal map labels", "error", err)
+			http.Error(rsp, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		body = newEncodedBody(enc_json, "application/json")
+		// Only cache the response if the features didn't change while it was being produced.
+
+		if features.Version() == version {
+			cache.Set(version, cache_key, body)
+		}
+
+		body.ServeHTTP(rsp, req)
+		return
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+// mapLabelsFeatureCollection returns the labels, in 'labels', for the features in 'features' which intersect 'b' (which may be nil)
+// and match 'properties' at zoom level 'z'. See `mapLabelsHandler` for details.
+func mapLabelsFeatureCollection(features *featureCollection, labels *mapLabels, b *orb.Bound, z int, properties map[string][]string) *geojson.FeatureCollection {
+
+	world := 256 * math.Pow(2, float64(z))
+
+	q := &featuresQuery{
+		Properties: properties,
+	}
+
+	if b != nil {
+
+		// Include the labels for features just outside the bounding box which may extend in to it.
+
+		pad := map_labels_cell_size * 360 / world
+		padded := b.Pad(pad)
+
+		q.Bound = &padded
+	}
+
+	results := features.Query(q)
+
+	candidates := make([]*mapLabel, 0)
+
+	for _, offset := range results.Offsets {
+
+		l := labels.Label(offset)
+
+		if l != nil {
+			candidates = append(candidates, l)
+		}
+	}
+
+	slices.SortStableFunc(candidates, func(a *mapLabel, b *mapLabel) int {
+
+		switch {
+		case a.priority > b.priority:
+			return -1
+		case a.priority < b.priority:
+			return 1
+		default:
+			return 0
+		}
+	})
+
+	s_fn := simplify.DouglasPeucker(simplifyThreshold(z))
+	cells := make(map[[2]int]bool)
+
+	fc := geojson.NewFeatureCollection()
+
+	for _, l := range candidates {
+
+		key, _, _ := gridCell(lngX(l.point.Lon())*world, latY(l.point.Lat())*world, map_labels_cell_size)
+
+		if cells[key] {
+			continue
+		}
+
+		cells[key] = true
+
+		var geom orb.Geometry = l.point
+
+		if l.line != nil {
+			geom = simplifyLineString(s_fn, l.line)
+		}
+
+		f := geojson.NewFeature(geom)
+		f.Properties["show:label"] = l.text
+		f.Properties["show:placement"] = l.placement
+
+		fc.Append(f)
+
+		if len(fc.Features) >= map_labels_max {
+			break
+		}
+	}
+
+	return fc
+}
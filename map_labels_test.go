@@ -0,0 +1,216 @@
+package show
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+	"github.com/paulmach/orb/planar"
+)
+
+func TestMapLabelerText(t *testing.T) {
+
+	f := geojson.NewFeature(orb.Point{0, 0})
+
+	f.Properties = geojson.Properties{
+		"wof:name":      "San Francisco\n  International   Airport",
+		"wof:id":        float64(102527513),
+		"wof:hierarchy": []any{map[string]any{"country_id": float64(85633793)}},
+		"markup":        "<b>SFO</b>",
+	}
+
+	tests := []struct {
+		label    string
+		expected string
+	}{
+		{"wof:name", "San Francisco International Airport"},
+		{"wof:id", "102527513"},
+		{"wof:hierarchy.0.country_id", "85633793"},
+		{"markup", "<b>SFO</b>"},
+		{"missing", ""},
+		{`{{ .Get "wof:name" }} ({{ .Get "wof:id" }})`, "San Francisco International Airport (102527513)"},
+		{`{{ .Id }}: {{ .Get "missing" }}`, "sfo:"},
+	}
+
+	for _, test := range tests {
+
+		t.Run(test.label, func(t *testing.T) {
+
+			l, err := newMapLabeler(test.label)
+
+			if err != nil {
+				t.Fatalf("Failed to create map labeler, %v", err)
+			}
+
+			text, err := l.Text(f, "sfo")
+
+			if err != nil {
+				t.Fatalf("Failed to derive label, %v", err)
+			}
+
+			if text != test.expected {
+				t.Fatalf("Expected '%s', got '%s'", test.expected, text)
+			}
+		})
+	}
+}
+
+func TestNewMapLabel(t *testing.T) {
+
+	square := orb.Ring{{0, 0}, {4, 0}, {4, 4}, {0, 4}, {0, 0}}
+
+	// The centroid of a U-shaped polygon is outside the polygon
+	u_shape := orb.Polygon{{{0, 0}, {10, 0}, {10, 10}, {8, 10}, {8, 2}, {2, 2}, {2, 10}, {0, 10}, {0, 0}}}
+
+	tests := []struct {
+		name      string
+		geom      orb.Geometry
+		placement string
+		point     orb.Point
+	}{
+		{"point", orb.Point{1, 2}, map_label_point, orb.Point{1, 2}},
+		{"multipoint", orb.MultiPoint{{1, 2}, {3, 4}}, map_label_point, orb.Point{1, 2}},
+		{"polygon", orb.Polygon{square}, map_label_center, orb.Point{2, 2}},
+		{"multipolygon", orb.MultiPolygon{{{{10, 10}, {11, 10}, {11, 11}, {10, 10}}}, {square}}, map_label_center, orb.Point{2, 2}},
+		{"linestring", orb.LineString{{0, 0}, {2, 0}, {2, 2}}, map_label_line, orb.Point{2, 0}},
+		{"multilinestring", orb.MultiLineString{{{0, 0}, {1, 0}}, {{0, 5}, {0, 9}}}, map_label_line, orb.Point{0, 7}},
+		{"empty polygon", orb.Polygon{}, "", orb.Point{}},
+		{"short line", orb.LineString{{0, 0}}, "", orb.Point{}},
+		{"empty multipoint", orb.MultiPoint{}, "", orb.Point{}},
+		{"nil", nil, "", orb.Point{}},
+	}
+
+	for _, test := range tests {
+
+		t.Run(test.name, func(t *testing.T) {
+
+			l := newMapLabel("label", test.geom)
+
+			if test.placement == "" {
+
+				if l != nil {
+					t.Fatalf("Expected no label, got %v", l)
+				}
+
+				return
+			}
+
+			if l == nil {
+				t.Fatalf("Expected a label")
+			}
+
+			if l.placement != test.placement {
+				t.Fatalf("Expected placement %s, got %s", test.placement, l.placement)
+			}
+
+			if !l.point.Equal(test.point) {
+				t.Fatalf("Expected label at %v, got %v", test.point, l.point)
+			}
+		})
+	}
+
+	l := newMapLabel("label", u_shape)
+
+	if !planar.PolygonContains(u_shape, l.point) {
+		t.Fatalf("Expected label for U-shaped polygon to be inside the polygon, got %v", l.point)
+	}
+}
+
+func TestMapLabelsHandler(t *testing.T) {
+
+	ctx := context.Background()
+
+	// Two points and a (larger, so higher priority) polygon at SFO and a point in New York
+
+	points := [][2]any{
+		{"SFO", orb.Point{-122.3866, 37.6164}},
+		{"SFO Gate", orb.Point{-122.3856, 37.6170}},
+		{"SFO Airfield", orb.Polygon{{{-122.40, 37.60}, {-122.36, 37.60}, {-122.36, 37.63}, {-122.40, 37.63}, {-122.40, 37.60}}}},
+		{"JFK", orb.Point{-73.7781, 40.6413}},
+	}
+
+	fc := make([]*geojson.Feature, 0)
+
+	for _, p := range points {
+
+		f := geojson.NewFeature(p[1].(orb.Geometry))
+		f.Properties["name"] = p[0]
+		f.Properties["type"] = "airport"
+
+		fc = append(fc, f)
+	}
+
+	features, err := newFeatureCollection(ctx, fc, nil, "")
+
+	if err != nil {
+		t.Fatalf("Failed to create feature collection, %v", err)
+	}
+
+	labeler, err := newMapLabeler("name")
+
+	if err != nil {
+		t.Fatalf("Failed to create map labeler, %v", err)
+	}
+
+	h := mapLabelsHandler(features, newMapLabels(features, labeler))
+
+	tests := []struct {
+		query  string
+		status int
+		labels []string
+	}{
+		{"z=2", 200, []string{"SFO Airfield", "JFK"}},
+		{"z=18", 200, []string{"SFO Airfield", "SFO", "SFO Gate", "JFK"}},
+		{"z=18&bbox=-80,40,-70,41", 200, []string{"JFK"}},
+		{"z=18&property=name=SFO", 200, []string{"SFO"}},
+		{"", 400, nil},
+	}
+
+	for _, test := range tests {
+
+		t.Run(test.query, func(t *testing.T) {
+
+			rsp := httptest.NewRecorder()
+			h.ServeHTTP(rsp, httptest.NewRequest("GET", "/map-labels.geojson?"+test.query, nil))
+
+			if rsp.Code != test.status {
+				t.Fatalf("Expected status code %d, got %d", test.status, rsp.Code)
+			}
+
+			if test.status != 200 {
+				return
+			}
+
+			fc, err := geojson.UnmarshalFeatureCollection(rsp.Body.Bytes())
+
+			if err != nil {
+				t.Fatalf("Failed to decode response, %v", err)
+			}
+
+			if len(fc.Features) != len(test.labels) {
+				t.Fatalf("Expected %d labels, got %d", len(test.labels), len(fc.Features))
+			}
+
+			// Labels are ordered by priority (larger features first)
+
+			for i, f := range fc.Features {
+
+				if f.Properties.MustString("show:label") != test.labels[i] {
+					t.Fatalf("Expected label at offset %d to be %s, got %s", i, test.labels[i], f.Properties.MustString("show:label"))
+				}
+
+				placement := map_label_point
+
+				if test.labels[i] == "SFO Airfield" {
+					placement = map_label_center
+				}
+
+				if f.Properties.MustString("show:placement") != placement {
+					t.Fatalf("Expected %s placement for %s, got %s", placement, test.labels[i], f.Properties.MustString("show:placement"))
+				}
+			}
+		})
+	}
+}
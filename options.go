@@ -4,9 +4,11 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"slices"
 
 	"github.com/paulmach/orb/geojson"
 	"github.com/sfomuseum/go-flags/flagset"
+	"github.com/sfomuseum/go-flags/multi"
 	www_show "github.com/sfomuseum/go-www-show/v2"
 )
 
@@ -20,18 +22,435 @@ type RunOptions struct {
 	PointStyle      *LeafletStyle
 	LabelProperties []string
 	Browser         www_show.Browser
+	// LabelTemplate is the (optional) path to a Go template used to render the labels displayed when features are
+	// clicked, instead of listing `LabelProperties`. Templates use the `text/template` syntax and are rendered using
+	// `html/template` so values are HTML-escaped. See `newFeatureLabeler` for details.
+	LabelTemplate string
+	// TrustedHTMLProperties are the (optional) properties, top-level property names or gjson paths, whose values are
+	// trusted to contain HTML and are rendered in labels without being escaped. All other values are HTML-escaped.
+	TrustedHTMLProperties []string
+	// MapLabel is the (optional) property, a top-level property name or gjson path, or Go template (using the
+	// `text/template` syntax) used to draw a permanent text label for each feature on the map. See `newMapLabeler`
+	// for details.
+	MapLabel string
+	// ContentSecurityPolicy is the value of the Content-Security-Policy header sent with every response. If empty a
+	// restrictive policy derived from the map configuration is used. See `contentSecurityPolicy` for details.
+	ContentSecurityPolicy string
+	// ImageHosts are the (optional) remote hosts that images in labels, like thumbnails, may be loaded from when
+	// `ContentSecurityPolicy` is empty. Each host is a Content-Security-Policy source expression, for example
+	// "https://images.example.com", "https://*.example.com" or "https:" to allow images from any HTTPS URL.
+	ImageHosts []string
+	// LineStyle is an optional style merged on top of `Style` for line geometries.
+	LineStyle *LeafletStyle
+	// PolygonStyle is an optional style merged on top of `Style` for polygon geometries.
+	PolygonStyle *LeafletStyle
+	// HoverStyle is an optional style applied to features when the pointer is over them.
+	HoverStyle *LeafletStyle
+	// SelectedStyle is the style applied to the currently selected feature. If nil a default style is used.
+	SelectedStyle *LeafletStyle
+	// StyleRules is an optional rules-based style definition used to assign styles to individual features according
+	// to their properties. Features which do not match any rules are rendered using `Style` or, if that is nil, the
+	// default style of the rules definition.
+	StyleRules *StyleRules
+	// StyleFunc is an optional callback, run by the server, which returns the style for an individual feature or nil if
+	// the feature should be rendered using its default style. The style it returns is merged on top of any styles derived
+	// from `StyleRules`, `ColorProperty` or simplestyle-spec properties. It is called once for each feature, when the
+	// feature is loaded or added to a running `Handler`, and must not modify the feature.
+	StyleFunc func(*geojson.Feature) *LeafletStyle
+	// IconsPath is the (optional) path to an image file, or a directory of image files, that can be used as icons to
+	// render point features. Icons are named after their file name without its extension. A set of SVG symbols
+	// ("circle", "square", "triangle", "diamond", "star" and "pin") is always available.
+	IconsPath string
+	// Icon is the name of the icon used to render point features which are not assigned an icon of their own. If empty
+	// point features without an icon are rendered as markers or circle markers.
+	Icon string
+	// IconProperty is the (optional) name of the property whose value is used to assign point features the icon with
+	// the same name.
+	IconProperty string
+	// IconSize is the size of icons in pixels. If 0 a default of 24 pixels is used.
+	IconSize int
+	// SimpleStyle determines how simplestyle-spec properties (for example "stroke" or "marker-color") in features are
+	// applied. Valid options are "none" (the default) in which case they are ignored, "feature" in which case they take
+	// precedence over `Style`, `PointStyle` and `StyleRules` and "style" in which case they are only used for options not
+	// defined by those styles.
+	SimpleStyle string
+	// ColorProperty is the (optional) name of the property used to colour features. Colours are assigned when a
+	// `Handler` is created using the classes derived from the features being served at that time.
+	ColorProperty string
+	// ColorMethod is the method used to assign features to classes when colouring them by `ColorProperty`. Valid
+	// options are "quantile" (the default), "equal-interval" and "jenks" for numeric properties and "categorical"
+	// which assigns a colour to each distinct value.
+	ColorMethod string
+	// ColorClasses is the number of classes used to colour features by a numeric property. If 0 a default of 5
+	// classes is used.
+	ColorClasses int
+	// ColorRamp is the name of the colour ramp (for example "YlOrRd" or "Viridis") or, for categorical properties,
+	// the colour palette (for example "Tableau10") used to colour features. If empty a default is used.
+	ColorRamp string
+	// VectorTileThreshold is the number of features above which the web application will render features using
+	// (server-side) vector tiles rather than fetching all the features at once. If 0 vector tiles are never used.
+	VectorTileThreshold int
+	// ViewportThreshold is the number of features above which the web application will only request the features
+	// inside the current map viewport (rather than all the features at once). If 0 viewport queries are never used.
+	ViewportThreshold int
+	// RasterThreshold is the number of features above which the web application will render features using
+	// (server-rendered) PNG raster tiles. If 0 raster tiles are never used. Raster tiles take precedence over
+	// clustering, vector tiles and viewport queries.
+	RasterThreshold int
+	// ClusterThreshold is the number of features above which the web application will render point features as
+	// (server-side) clusters, updated as the map is zoomed and moved. If 0 points are never clustered. Clustering
+	// takes precedence over both vector tiles and viewport queries.
+	ClusterThreshold int
+	// Simplify signals that simplified versions of each geometry should be precomputed for a set of zoom bands and
+	// served, for the current zoom level, to the web application. The complete record for each feature, including its
+	// original geometry, is still shown in the web application's raw pane.
+	Simplify bool
+	// Aggregate is the type of cells, "hex" or "grid", that point features are aggregated in to when the web application
+	// is loaded. If empty aggregated cells are not shown until they are enabled in the web application.
+	Aggregate string
+	// AggregateSize is the size, in pixels, of the cells that point features are aggregated in to. If 0 a default size of
+	// 40 pixels is used.
+	AggregateSize float64
+	// AggregateProperty is the (optional) name of a numeric property whose sum and mean are calculated for each cell.
+	AggregateProperty string
+	// Transport is the encoding used to send features to the web application. Valid options are "geojson" (the default)
+	// and "geobuf" which is a compact binary encoding of GeoJSON. The "/features.geojson" endpoint is always available.
+	Transport string
+	// IdProperty is the (optional) name of the feature property used to derive stable identifiers for features. If
+	// empty, or if a feature does not have a matching property, its GeoJSON "id" member is used. Failing both identifiers
+	// are derived from a hash of the feature's contents.
+	IdProperty string
+	// LazyProperties signals that the web application should request features without properties and only fetch the
+	// complete record (and label) for individual features when they are clicked on or scrolled in to view.
+	LazyProperties bool
+	// Transformers is an optional list of `FeatureTransformer` instances which are applied, in order, to features
+	// when they are loaded and whenever they are added to a running `Handler`.
+	Transformers []FeatureTransformer
+	// Datasets is an optional `Datasets` instance containing multiple named datasets to serve. If present
+	// then `Features` is ignored by `RunWithOptions` and datasets are served under the "/maps/" path.
+	Datasets *Datasets
 }
 
+// RunOptionsBuilder derives `RunOptions` instances from command line arguments. Each builder has its own `flag.FlagSet`
+// instance, and associated state, so multiple builders can be used independently in the same process.
+type RunOptionsBuilder struct {
+	fs *flag.FlagSet
+}
+
+// NewRunOptionsBuilder returns a new `RunOptionsBuilder` instance whose flag set is derived from `DefaultFlagSet`.
+func NewRunOptionsBuilder() *RunOptionsBuilder {
+
+	b := &RunOptionsBuilder{
+		fs: DefaultFlagSet(),
+	}
+
+	return b
+}
+
+// FlagSet returns the `flag.FlagSet` instance associated with 'b'.
+func (b *RunOptionsBuilder) FlagSet() *flag.FlagSet {
+	return b.fs
+}
+
+// Parse parses 'args' using the flag set associated with 'b'.
+func (b *RunOptionsBuilder) Parse(args []string) error {
+	return b.fs.Parse(args)
+}
+
+// Args returns the non-flag arguments remaining after 'b' has been parsed.
+func (b *RunOptionsBuilder) Args() []string {
+	return b.fs.Args()
+}
+
+// RunOptions returns a new `RunOptions` instance derived from the (parsed) flag set associated with 'b'.
+func (b *RunOptionsBuilder) RunOptions(ctx context.Context) (*RunOptions, error) {
+	return RunOptionsFromParsedFlagSet(ctx, b.fs)
+}
+
+// RunOptionsFromFlagSet parses the current command line arguments (`os.Args`) using 'fs' and returns a new
+// `RunOptions` instance derived from the results.
 func RunOptionsFromFlagSet(ctx context.Context, fs *flag.FlagSet) (*RunOptions, error) {
 
 	flagset.Parse(fs)
 
+	return RunOptionsFromParsedFlagSet(ctx, fs)
+}
+
+// RunOptionsFromParsedFlagSet returns a new `RunOptions` instance derived from 'fs' which is expected to have
+// been created by `DefaultFlagSet` and to have been parsed already. Values defined in a config file profile
+// (see the -config and -profile flags) are applied to 'fs' for any flags that have not been set explicitly.
+func RunOptionsFromParsedFlagSet(ctx context.Context, fs *flag.FlagSet) (*RunOptions, error) {
+
+	config_path, err := flagValue[string](fs, "config")
+
+	if err != nil {
+		return nil, err
+	}
+
+	profile_name, err := flagValue[string](fs, "profile")
+
+	if err != nil {
+		return nil, err
+	}
+
+	no_default_config, err := flagValue[bool](fs, "no-default-config")
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = applyConfig(fs, config_path, profile_name, !no_default_config)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to apply config profile, %w", err)
+	}
+
+	map_provider, err := flagValue[string](fs, "map-provider")
+
+	if err != nil {
+		return nil, err
+	}
+
+	map_tile_uri, err := flagValue[string](fs, "map-tile-uri")
+
+	if err != nil {
+		return nil, err
+	}
+
+	protomaps_theme, err := flagValue[string](fs, "protomaps-theme")
+
+	if err != nil {
+		return nil, err
+	}
+
+	port, err := flagValue[int](fs, "port")
+
+	if err != nil {
+		return nil, err
+	}
+
+	vector_tile_threshold, err := flagValue[int](fs, "vector-tile-threshold")
+
+	if err != nil {
+		return nil, err
+	}
+
+	viewport_threshold, err := flagValue[int](fs, "viewport-threshold")
+
+	if err != nil {
+		return nil, err
+	}
+
+	raster_threshold, err := flagValue[int](fs, "raster-threshold")
+
+	if err != nil {
+		return nil, err
+	}
+
+	cluster_threshold, err := flagValue[int](fs, "cluster-threshold")
+
+	if err != nil {
+		return nil, err
+	}
+
+	simplify, err := flagValue[bool](fs, "simplify")
+
+	if err != nil {
+		return nil, err
+	}
+
+	aggregate, err := flagValue[string](fs, "aggregate")
+
+	if err != nil {
+		return nil, err
+	}
+
+	aggregate_size, err := flagValue[float64](fs, "aggregate-size")
+
+	if err != nil {
+		return nil, err
+	}
+
+	aggregate_property, err := flagValue[string](fs, "aggregate-property")
+
+	if err != nil {
+		return nil, err
+	}
+
+	transport, err := flagValue[string](fs, "transport")
+
+	if err != nil {
+		return nil, err
+	}
+
+	lazy_properties, err := flagValue[bool](fs, "lazy-properties")
+
+	if err != nil {
+		return nil, err
+	}
+
+	id_property, err := flagValue[string](fs, "id-property")
+
+	if err != nil {
+		return nil, err
+	}
+
+	label_properties, err := flagValue[multi.MultiString](fs, "label")
+
+	if err != nil {
+		return nil, err
+	}
+
+	browser_uri, err := flagValue[string](fs, "browser-uri")
+
+	if err != nil {
+		return nil, err
+	}
+
+	style, err := flagValue[string](fs, "style")
+
+	if err != nil {
+		return nil, err
+	}
+
+	point_style, err := flagValue[string](fs, "point-style")
+
+	if err != nil {
+		return nil, err
+	}
+
+	line_style, err := flagValue[string](fs, "line-style")
+
+	if err != nil {
+		return nil, err
+	}
+
+	polygon_style, err := flagValue[string](fs, "polygon-style")
+
+	if err != nil {
+		return nil, err
+	}
+
+	hover_style, err := flagValue[string](fs, "hover-style")
+
+	if err != nil {
+		return nil, err
+	}
+
+	selected_style, err := flagValue[string](fs, "selected-style")
+
+	if err != nil {
+		return nil, err
+	}
+
+	simplestyle, err := flagValue[string](fs, "simplestyle")
+
+	if err != nil {
+		return nil, err
+	}
+
+	color_property, err := flagValue[string](fs, "color-property")
+
+	if err != nil {
+		return nil, err
+	}
+
+	color_method, err := flagValue[string](fs, "color-method")
+
+	if err != nil {
+		return nil, err
+	}
+
+	color_classes, err := flagValue[int](fs, "color-classes")
+
+	if err != nil {
+		return nil, err
+	}
+
+	color_ramp, err := flagValue[string](fs, "color-ramp")
+
+	if err != nil {
+		return nil, err
+	}
+
+	label_template, err := flagValue[string](fs, "label-template")
+
+	if err != nil {
+		return nil, err
+	}
+
+	trusted_html, err := flagValue[multi.MultiString](fs, "trusted-html")
+
+	if err != nil {
+		return nil, err
+	}
+
+	image_hosts, err := flagValue[multi.MultiString](fs, "image-host")
+
+	if err != nil {
+		return nil, err
+	}
+
+	map_label, err := flagValue[string](fs, "map-label")
+
+	if err != nil {
+		return nil, err
+	}
+
+	icons_path, err := flagValue[string](fs, "icons")
+
+	if err != nil {
+		return nil, err
+	}
+
+	icon, err := flagValue[string](fs, "icon")
+
+	if err != nil {
+		return nil, err
+	}
+
+	icon_property, err := flagValue[string](fs, "icon-property")
+
+	if err != nil {
+		return nil, err
+	}
+
+	icon_size, err := flagValue[int](fs, "icon-size")
+
+	if err != nil {
+		return nil, err
+	}
+
 	opts := &RunOptions{
-		MapProvider:     map_provider,
-		MapTileURI:      map_tile_uri,
-		ProtomapsTheme:  protomaps_theme,
-		Port:            port,
-		LabelProperties: label_properties,
+		MapProvider:           map_provider,
+		MapTileURI:            map_tile_uri,
+		ProtomapsTheme:        protomaps_theme,
+		Port:                  port,
+		LabelProperties:       slices.Clone(label_properties),
+		LabelTemplate:         label_template,
+		TrustedHTMLProperties: slices.Clone(trusted_html),
+		ImageHosts:            slices.Clone(image_hosts),
+		MapLabel:              map_label,
+		VectorTileThreshold:   vector_tile_threshold,
+		ViewportThreshold:     viewport_threshold,
+		ClusterThreshold:      cluster_threshold,
+		RasterThreshold:       raster_threshold,
+		LazyProperties:        lazy_properties,
+		Simplify:              simplify,
+		Transport:             transport,
+		Aggregate:             aggregate,
+		AggregateSize:         aggregate_size,
+		AggregateProperty:     aggregate_property,
+		IdProperty:            id_property,
+		SimpleStyle:           simplestyle,
+		ColorProperty:         color_property,
+		ColorMethod:           color_method,
+		ColorClasses:          color_classes,
+		ColorRamp:             color_ramp,
+		IconsPath:             icons_path,
+		Icon:                  icon,
+		IconProperty:          icon_property,
+		IconSize:              icon_size,
 	}
 
 	br, err := www_show.NewBrowser(ctx, browser_uri)
@@ -44,13 +463,19 @@ func RunOptionsFromFlagSet(ctx context.Context, fs *flag.FlagSet) (*RunOptions,
 
 	if style != "" {
 
-		s, err := UnmarshalStyle(style)
+		// This may be either a plain Leaflet style or a rules-based style definition
+
+		rules, err := UnmarshalStyleRules(style)
 
 		if err != nil {
 			return nil, fmt.Errorf("Failed to unmarshal style, %w", err)
 		}
 
-		opts.Style = s
+		opts.Style = rules.Default
+
+		if len(rules.Rules) > 0 {
+			opts.StyleRules = rules
+		}
 	}
 
 	if point_style != "" {
@@ -64,5 +489,33 @@ func RunOptionsFromFlagSet(ctx context.Context, fs *flag.FlagSet) (*RunOptions,
 		opts.PointStyle = s
 	}
 
+	// Additional styles which are assigned in the same way as -point-style
+
+	other_styles := []struct {
+		name   string
+		value  string
+		target **LeafletStyle
+	}{
+		{"line", line_style, &opts.LineStyle},
+		{"polygon", polygon_style, &opts.PolygonStyle},
+		{"hover", hover_style, &opts.HoverStyle},
+		{"selected", selected_style, &opts.SelectedStyle},
+	}
+
+	for _, o := range other_styles {
+
+		if o.value == "" {
+			continue
+		}
+
+		s, err := UnmarshalStyle(o.value)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to unmarshal %s style, %w", o.name, err)
+		}
+
+		*o.target = s
+	}
+
 	return opts, nil
 }
@@ -0,0 +1,232 @@
+package show
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// The name of the directory, relative to `os.UserConfigDir`, where the default config file is stored.
+const config_dirname string = "go-geojson-show"
+
+// The list of filenames, in order of precedence, to look for in the default config directory.
+var config_filenames = []string{
+	"config.json",
+	"config.yaml",
+	"config.yml",
+	"config.toml",
+}
+
+// Config defines a collection of named profiles for deriving `RunOptions` instances.
+type Config struct {
+	// The name of the profile to use if no profile is specified explicitly.
+	DefaultProfile string `json:"default_profile" yaml:"default_profile" toml:"default_profile"`
+	// A dictionary of named profiles.
+	Profiles map[string]Profile `json:"profiles" yaml:"profiles" toml:"profiles"`
+}
+
+// Profile is a dictionary of command-line flag names and their values. Values may be strings, numbers or booleans.
+// Lists are assigned to flags that accept multiple values one item at a time. Dictionaries (for example style
+// definitions) are assigned as JSON-encoded strings.
+type Profile map[string]any
+
+// LoadConfig reads and parses the config file at 'path'. The format of the file is determined by its extension
+// which may be one of ".json", ".yaml", ".yml" or ".toml".
+func LoadConfig(path string) (*Config, error) {
+
+	r, err := os.Open(path)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open config file for reading, %w", err)
+	}
+
+	defer r.Close()
+
+	body, err := io.ReadAll(r)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read config file, %w", err)
+	}
+
+	var cfg *Config
+
+	ext := strings.ToLower(filepath.Ext(path))
+
+	switch ext {
+	case ".json":
+		err = json.Unmarshal(body, &cfg)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(body, &cfg)
+	case ".toml":
+		err = toml.Unmarshal(body, &cfg)
+	default:
+		return nil, fmt.Errorf("Unsupported config file format, '%s'", ext)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to unmarshal config file, %w", err)
+	}
+
+	return cfg, nil
+}
+
+// DefaultConfigPath returns the path of the first config file found in the default config directory
+// (for example "~/.config/go-geojson-show/config.yaml" on Linux). It returns an empty string if none exist.
+func DefaultConfigPath() string {
+
+	root, err := os.UserConfigDir()
+
+	if err != nil {
+		return ""
+	}
+
+	for _, fname := range config_filenames {
+
+		path := filepath.Join(root, config_dirname, fname)
+
+		_, err := os.Stat(path)
+
+		if err == nil {
+			return path
+		}
+	}
+
+	return ""
+}
+
+// ApplyProfile assigns the values in 'p' to their corresponding flags in 'fs'. Flags are assigned in alphabetical
+// order, so the result does not depend on the order in which the profile was defined. Flags that have already been
+// set explicitly (on the command line) are left untouched.
+func ApplyProfile(fs *flag.FlagSet, p Profile) error {
+
+	explicit := make(map[string]bool)
+
+	fs.Visit(func(fl *flag.Flag) {
+		explicit[fl.Name] = true
+	})
+
+	for _, name := range slices.Sorted(maps.Keys(p)) {
+
+		value := p[name]
+
+		switch name {
+		case "config", "profile", "no-default-config":
+			return fmt.Errorf("Profiles can not assign the -%s flag", name)
+		}
+
+		if fs.Lookup(name) == nil {
+			return fmt.Errorf("Unknown flag in profile, '%s'", name)
+		}
+
+		if explicit[name] {
+			continue
+		}
+
+		values := []any{value}
+
+		list, is_list := value.([]any)
+
+		if is_list {
+			values = list
+		}
+
+		for _, v := range values {
+
+			str_v, err := profileValueToString(v)
+
+			if err != nil {
+				return fmt.Errorf("Invalid value for '%s' flag, %w", name, err)
+			}
+
+			err = fs.Set(name, str_v)
+
+			if err != nil {
+				return fmt.Errorf("Failed to assign '%s' flag, %w", name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// applyConfig loads the config file defined by the -config flag (or the default config file, unless 'use_default'
+// is false) and applies the profile defined by the -profile flag (or the config file's default profile) to 'fs'.
+func applyConfig(fs *flag.FlagSet, config_path string, profile_name string, use_default bool) error {
+
+	if config_path == "" && use_default {
+
+		config_path = DefaultConfigPath()
+
+		if config_path != "" {
+			slog.Info("Using default config file", "path", config_path)
+		}
+	}
+
+	if config_path == "" {
+
+		if profile_name != "" {
+			return fmt.Errorf("Profile '%s' requested but no config file found", profile_name)
+		}
+
+		return nil
+	}
+
+	cfg, err := LoadConfig(config_path)
+
+	if err != nil {
+		return fmt.Errorf("Failed to load config file %s, %w", config_path, err)
+	}
+
+	if profile_name == "" {
+		profile_name = cfg.DefaultProfile
+	}
+
+	if profile_name == "" {
+		return nil
+	}
+
+	p, exists := cfg.Profiles[profile_name]
+
+	if !exists {
+		return fmt.Errorf("Profile '%s' not found in %s", profile_name, config_path)
+	}
+
+	return ApplyProfile(fs, p)
+}
+
+func profileValueToString(v any) (string, error) {
+
+	switch v.(type) {
+	case string:
+		return v.(string), nil
+	case bool:
+		return strconv.FormatBool(v.(bool)), nil
+	case int, int64:
+		return fmt.Sprintf("%d", v), nil
+	case float64:
+		// Format numbers without exponents so that large values (which JSON decodes as float64) can be assigned to int flags
+		return strconv.FormatFloat(v.(float64), 'f', -1, 64), nil
+	case map[string]any, Profile:
+
+		enc, err := json.Marshal(v)
+
+		if err != nil {
+			return "", err
+		}
+
+		return string(enc), nil
+	default:
+		return "", fmt.Errorf("Unsupported value type %T", v)
+	}
+}
@@ -0,0 +1,172 @@
+package show
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestApplyProfile(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		args     []string
+		profile  Profile
+		flag     string
+		expected string
+		err      string
+	}{
+		{"string", nil, Profile{"map-provider": "protomaps"}, "map-provider", "protomaps", ""},
+		{"explicit flag", []string{"-map-provider", "leaflet"}, Profile{"map-provider": "protomaps"}, "map-provider", "leaflet", ""},
+		{"list", nil, Profile{"label": []any{"wof:name", "wof:id"}}, "label", "wof:name\nwof:id", ""},
+		{"number", nil, Profile{"icon-size": 32}, "icon-size", "32", ""},
+		{"large number", nil, Profile{"raster-threshold": float64(1000000)}, "raster-threshold", "1000000", ""},
+		{"large integer", nil, Profile{"raster-threshold": int64(25000000)}, "raster-threshold", "25000000", ""},
+		{"decimal", nil, Profile{"raster-threshold": 1.5}, "", "", "Failed to assign 'raster-threshold' flag, parse error"},
+		{"dictionary", nil, Profile{"style": map[string]any{"color": "red"}}, "style", `{"color":"red"}`, ""},
+		{"unknown flags", nil, Profile{"zzz": 1, "aaa": 1, "mmm": 1}, "", "", "Unknown flag in profile, 'aaa'"},
+		{"reserved flag", nil, Profile{"unknown": 1, "config": "config.json"}, "", "", "Profiles can not assign the -config flag"},
+		{"reserved default flag", nil, Profile{"no-default-config": true}, "", "", "Profiles can not assign the -no-default-config flag"},
+	}
+
+	for _, test := range tests {
+
+		t.Run(test.name, func(t *testing.T) {
+
+			// Profiles are applied in a defined order so the outcome (including errors) must be the same every time
+
+			for i := 0; i < 10; i++ {
+
+				fs := DefaultFlagSet()
+
+				err := fs.Parse(test.args)
+
+				if err != nil {
+					t.Fatalf("Failed to parse flags, %v", err)
+				}
+
+				err = ApplyProfile(fs, test.profile)
+
+				if test.err != "" {
+
+					if err == nil || err.Error() != test.err {
+						t.Fatalf("Expected error '%s', got %v", test.err, err)
+					}
+
+					continue
+				}
+
+				if err != nil {
+					t.Fatalf("Failed to apply profile, %v", err)
+				}
+
+				v := fs.Lookup(test.flag).Value.String()
+
+				if v != test.expected {
+					t.Fatalf("Expected -%s to be %s, got %s", test.flag, test.expected, v)
+				}
+			}
+		})
+	}
+}
+
+func TestApplyConfigDefault(t *testing.T) {
+
+	root := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", root)
+	t.Setenv("HOME", root)
+
+	config_root, err := os.UserConfigDir()
+
+	if err != nil {
+		t.Skipf("Failed to determine user config directory, %v", err)
+	}
+
+	err = os.MkdirAll(filepath.Join(config_root, config_dirname), 0755)
+
+	if err != nil {
+		t.Fatalf("Failed to create config directory, %v", err)
+	}
+
+	body := []byte(`{"default_profile": "example", "profiles": {"example": {"map-provider": "protomaps"}}}`)
+
+	err = os.WriteFile(filepath.Join(config_root, config_dirname, "config.json"), body, 0644)
+
+	if err != nil {
+		t.Fatalf("Failed to write config file, %v", err)
+	}
+
+	tests := []struct {
+		use_default bool
+		expected    string
+	}{
+		{true, "protomaps"},
+		{false, "leaflet"},
+	}
+
+	for _, test := range tests {
+
+		fs := DefaultFlagSet()
+
+		err := applyConfig(fs, "", "", test.use_default)
+
+		if err != nil {
+			t.Fatalf("Failed to apply config, %v", err)
+		}
+
+		v := fs.Lookup("map-provider").Value.String()
+
+		if v != test.expected {
+			t.Fatalf("Expected -map-provider to be %s when use_default is %t, got %s", test.expected, test.use_default, v)
+		}
+	}
+}
+
+func TestLoadConfigLargeNumbers(t *testing.T) {
+
+	tests := []struct {
+		filename string
+		body     string
+	}{
+		{"config.json", `{"profiles": {"example": {"raster-threshold": 1000000, "viewport-threshold": 2500000}}}`},
+		{"config.yaml", "profiles:\n  example:\n    raster-threshold: 1000000\n    viewport-threshold: 2.5e+06\n"},
+		{"config.toml", "[profiles.example]\nraster-threshold = 1000000\nviewport-threshold = 2_500_000\n"},
+	}
+
+	for _, test := range tests {
+
+		t.Run(test.filename, func(t *testing.T) {
+
+			path := filepath.Join(t.TempDir(), test.filename)
+
+			err := os.WriteFile(path, []byte(test.body), 0644)
+
+			if err != nil {
+				t.Fatalf("Failed to write config file, %v", err)
+			}
+
+			cfg, err := LoadConfig(path)
+
+			if err != nil {
+				t.Fatalf("Failed to load config, %v", err)
+			}
+
+			fs := DefaultFlagSet()
+
+			err = ApplyProfile(fs, cfg.Profiles["example"])
+
+			if err != nil {
+				t.Fatalf("Failed to apply profile, %v", err)
+			}
+
+			for k, expected := range map[string]string{"raster-threshold": "1000000", "viewport-threshold": "2500000"} {
+
+				v := fs.Lookup(k).Value.String()
+
+				if v != expected {
+					t.Fatalf("Expected -%s to be %s, got %s", k, expected, v)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,263 @@
+package show
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+)
+
+// The default number of features above which the web application will only request the features inside the current map viewport.
+const default_viewport_threshold int = 2000
+
+// featuresQuery defines criteria for selecting features from a featureCollection.
+type featuresQuery struct {
+	// Bound is an optional bounding box that features must intersect.
+	Bound *orb.Bound
+	// Properties is an optional dictionary of property names and values that features must match. A
+	// feature must match all the properties and any one of the values for each property.
+	Properties map[string][]string
+	// Limit is the maximum number of features to return. If 0 all the matching features are returned.
+	Limit int
+	// Offset is the number of matching features to skip.
+	Offset int
+	// IncludeProperties is an optional list of the only properties to include for each feature returned. If nil
+	// all properties are included. If not nil but empty, no properties are included.
+	IncludeProperties []string
+	// Zoom is an optional map zoom level used to select simplified geometries for features.
+	Zoom *int
+}
+
+// featuresQueryResults contains the results of a featuresQuery.
+type featuresQueryResults struct {
+	// Features is the list of features matching the query (after applying limit and offset criteria).
+	Features []*geojson.Feature
+	// Offsets is the list of offsets, in the feature collection, of each item in Features.
+	Offsets []int
+	// Ids is the list of stable identifiers for each item in Features.
+	Ids []string
+	// Total is the total number of features matching the query (before applying limit and offset criteria).
+	Total int
+}
+
+// featuresQueryFromRequest derives a featuresQuery from the query parameters in 'req'. Valid parameters are:
+// "bbox" (minx,miny,maxx,maxy), "limit", "offset", zero or more "property" parameters in the form of {NAME}={VALUE},
+// "properties" which is a comma-separated list of the only properties to include for each feature and "z" which is the
+// map zoom level used to select simplified geometries. If the "properties" parameter is present but empty then features
+// will be returned without any properties.
+func featuresQueryFromRequest(req *http.Request) (*featuresQuery, error) {
+
+	params := req.URL.Query()
+
+	q := &featuresQuery{}
+
+	str_bbox := params.Get("bbox")
+
+	if str_bbox != "" {
+
+		parts := strings.Split(str_bbox, ",")
+
+		if len(parts) != 4 {
+			return nil, fmt.Errorf("Invalid bbox parameter")
+		}
+
+		coords := make([]float64, 4)
+
+		for i, str_c := range parts {
+
+			c, err := strconv.ParseFloat(strings.TrimSpace(str_c), 64)
+
+			if err != nil {
+				return nil, fmt.Errorf("Invalid bbox parameter, %w", err)
+			}
+
+			coords[i] = c
+		}
+
+		b := orb.Bound{
+			Min: orb.Point{coords[0], coords[1]},
+			Max: orb.Point{coords[2], coords[3]},
+		}
+
+		q.Bound = &b
+	}
+
+	str_limit := params.Get("limit")
+
+	if str_limit != "" {
+
+		limit, err := strconv.Atoi(str_limit)
+
+		if err != nil || limit < 0 {
+			return nil, fmt.Errorf("Invalid limit parameter")
+		}
+
+		q.Limit = limit
+	}
+
+	str_offset := params.Get("offset")
+
+	if str_offset != "" {
+
+		offset, err := strconv.Atoi(str_offset)
+
+		if err != nil || offset < 0 {
+			return nil, fmt.Errorf("Invalid offset parameter")
+		}
+
+		q.Offset = offset
+	}
+
+	str_z := params.Get("z")
+
+	if str_z != "" {
+
+		z, err := strconv.Atoi(str_z)
+
+		if err != nil || z < 0 {
+			return nil, fmt.Errorf("Invalid z parameter")
+		}
+
+		q.Zoom = &z
+	}
+
+	if params.Has("properties") {
+
+		q.IncludeProperties = make([]string, 0)
+
+		for _, name := range strings.Split(params.Get("properties"), ",") {
+
+			name = strings.TrimSpace(name)
+
+			if name != "" {
+				q.IncludeProperties = append(q.IncludeProperties, name)
+			}
+		}
+	}
+
+	for _, str_prop := range params["property"] {
+
+		k, v, ok := strings.Cut(str_prop, "=")
+
+		if !ok || k == "" {
+			return nil, fmt.Errorf("Invalid property parameter, '%s'", str_prop)
+		}
+
+		if q.Properties == nil {
+			q.Properties = make(map[string][]string)
+		}
+
+		q.Properties[k] = append(q.Properties[k], v)
+	}
+
+	return q, nil
+}
+
+// Query returns the features in 'c' matching 'q'.
+func (c *featureCollection) Query(q *featuresQuery) *featuresQueryResults {
+
+	features, ids := c.snapshot()
+
+	var candidates []int
+
+	if q.Bound != nil {
+		candidates = c.Intersects(*q.Bound)
+	} else {
+
+		candidates = make([]int, len(features))
+
+		for i := range features {
+			candidates[i] = i
+		}
+	}
+
+	rsp := &featuresQueryResults{
+		Features: make([]*geojson.Feature, 0),
+		Offsets:  make([]int, 0),
+		Ids:      make([]string, 0),
+	}
+
+	for _, idx := range candidates {
+
+		// The index may have been updated since we retrieved the list of features.
+		if idx >= len(features) {
+			continue
+		}
+
+		f := features[idx]
+
+		if !matchesProperties(f, q.Properties) {
+			continue
+		}
+
+		rsp.Total += 1
+
+		if rsp.Total <= q.Offset {
+			continue
+		}
+
+		if q.Limit > 0 && len(rsp.Features) >= q.Limit {
+			continue
+		}
+
+		rsp.Features = append(rsp.Features, f)
+		rsp.Offsets = append(rsp.Offsets, idx)
+		rsp.Ids = append(rsp.Ids, ids[idx])
+	}
+
+	return rsp
+}
+
+// selectProperties returns a copy of 'f' which only contains the properties listed in 'names'.
+func selectProperties(f *geojson.Feature, names []string) *geojson.Feature {
+
+	new_f := geojson.NewFeature(f.Geometry)
+	new_f.ID = f.ID
+	new_f.BBox = f.BBox
+
+	for _, k := range names {
+
+		v, exists := f.Properties[k]
+
+		if exists {
+			new_f.Properties[k] = v
+		}
+	}
+
+	return new_f
+}
+
+// matchesProperties returns true if the properties of 'f' match all the criteria in 'properties'. Property values are
+// compared using their string representation as returned by `labelValue`.
+func matchesProperties(f *geojson.Feature, properties map[string][]string) bool {
+
+	for k, values := range properties {
+
+		v, exists := f.Properties[k]
+
+		if !exists {
+			return false
+		}
+
+		// Numbers are formatted without exponents so that large identifiers (like WOF IDs) can be matched
+		str_v := labelValue(v)
+		match := false
+
+		for _, test := range values {
+
+			if str_v == test {
+				match = true
+				break
+			}
+		}
+
+		if !match {
+			return false
+		}
+	}
+
+	return true
+}
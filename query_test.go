@@ -0,0 +1,167 @@
+package show
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+)
+
+func TestMatchesProperties(t *testing.T) {
+
+	f := geojson.NewFeature(orb.Point{0, 0})
+
+	f.Properties = geojson.Properties{
+		"wof:id":        float64(1159396321),
+		"height":        12.5,
+		"wof:placetype": "venue",
+		"is_current":    true,
+	}
+
+	tests := []struct {
+		name       string
+		properties map[string][]string
+		expected   bool
+	}{
+		{"large integer", map[string][]string{"wof:id": {"1159396321"}}, true},
+		{"exponent", map[string][]string{"wof:id": {"1.159396321e+09"}}, false},
+		{"decimal", map[string][]string{"height": {"12.5"}}, true},
+		{"string", map[string][]string{"wof:placetype": {"venue"}}, true},
+		{"any value", map[string][]string{"wof:placetype": {"campus", "venue"}}, true},
+		{"boolean", map[string][]string{"is_current": {"true"}}, true},
+		{"all properties", map[string][]string{"wof:placetype": {"venue"}, "height": {"10"}}, false},
+		{"missing property", map[string][]string{"name": {"SFO"}}, false},
+	}
+
+	for _, test := range tests {
+
+		t.Run(test.name, func(t *testing.T) {
+
+			if matchesProperties(f, test.properties) != test.expected {
+				t.Fatalf("Expected match to be %t", test.expected)
+			}
+		})
+	}
+}
+
+func TestDataHandlerPropertyFilter(t *testing.T) {
+
+	ctx := context.Background()
+
+	body := []byte(`{"type":"FeatureCollection","features":[
+{"type":"Feature","properties":{"wof:id":1159396321},"geometry":{"type":"Point","coordinates":[-122.38,37.62]}},
+{"type":"Feature","properties":{"wof:id":1159396323},"geometry":{"type":"Point","coordinates":[-122.39,37.61]}}
+]}`)
+
+	fc, err := geojson.UnmarshalFeatureCollection(body)
+
+	if err != nil {
+		t.Fatalf("Failed to unmarshal features, %v", err)
+	}
+
+	features, err := newFeatureCollection(ctx, fc.Features, nil, "")
+
+	if err != nil {
+		t.Fatalf("Failed to create feature collection, %v", err)
+	}
+
+	tests := []struct {
+		query    string
+		expected int
+	}{
+		{"", 2},
+		{"property=wof:id=1159396321", 1},
+		{"property=wof:id=1159396321&property=wof:id=1159396323", 2},
+		{"property=wof:id=1", 0},
+		{"bbox=-122.385,37.615,-122.375,37.625", 1},
+	}
+
+	h := dataHandler(features, nil, nil, transport_geojson)
+
+	for _, test := range tests {
+
+		t.Run(test.query, func(t *testing.T) {
+
+			rsp := httptest.NewRecorder()
+			h.ServeHTTP(rsp, httptest.NewRequest("GET", "/features.geojson?"+test.query, nil))
+
+			if rsp.Code != 200 {
+				t.Fatalf("Unexpected status code %d", rsp.Code)
+			}
+
+			var results struct {
+				Total int `json:"show:total"`
+			}
+
+			err := json.Unmarshal(rsp.Body.Bytes(), &results)
+
+			if err != nil {
+				t.Fatalf("Failed to decode response, %v", err)
+			}
+
+			if results.Total != test.expected {
+				t.Fatalf("Expected %d features, got %d", test.expected, results.Total)
+			}
+		})
+	}
+}
+
+func TestDataHandlerIncludeProperties(t *testing.T) {
+
+	ctx := context.Background()
+
+	f := geojson.NewFeature(orb.Point{-122.38, 37.62})
+	f.Properties["name"] = "SFO"
+	f.Properties["wof:id"] = float64(102527513)
+
+	features, err := newFeatureCollection(ctx, []*geojson.Feature{f}, nil, "")
+
+	if err != nil {
+		t.Fatalf("Failed to create feature collection, %v", err)
+	}
+
+	tests := []struct {
+		query    string
+		expected []string
+	}{
+		{"", []string{"name", "wof:id"}},
+		{"properties=", []string{}},
+		{"properties=name", []string{"name"}},
+		{"properties=name,wof:id", []string{"name", "wof:id"}},
+	}
+
+	h := dataHandler(features, nil, nil, transport_geojson)
+
+	for _, test := range tests {
+
+		t.Run(test.query, func(t *testing.T) {
+
+			rsp := httptest.NewRecorder()
+			h.ServeHTTP(rsp, httptest.NewRequest("GET", "/features.geojson?"+test.query, nil))
+
+			fc, err := geojson.UnmarshalFeatureCollection(rsp.Body.Bytes())
+
+			if err != nil {
+				t.Fatalf("Failed to decode response, %v", err)
+			}
+
+			props := fc.Features[0].Properties
+
+			if len(props) != len(test.expected) {
+				t.Fatalf("Expected %d properties, got %v", len(test.expected), props)
+			}
+
+			for _, name := range test.expected {
+
+				_, exists := props[name]
+
+				if !exists {
+					t.Fatalf("Expected property %s, got %v", name, props)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,1166 @@
+package show
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/clip"
+	"github.com/paulmach/orb/geojson"
+	"github.com/paulmach/orb/maptile"
+	"github.com/paulmach/orb/planar"
+	"golang.org/x/image/vector"
+)
+
+// The size, in pixels, of raster tiles.
+const raster_tile_size int = 256
+
+// Leaflet's default stroke (and fill) colour.
+const raster_default_color string = "#3388ff"
+
+// The maximum number of (encoded) raster tiles to cache for each version of a feature collection.
+const raster_cache_size int = 512
+
+// The maximum zoom level for which raster tiles are produced.
+const raster_max_zoom maptile.Zoom = 22
+
+// The number of segments used to approximate circles when rasterizing points and line joins.
+const raster_circle_segments int = 16
+
+// The maximum ratio of the length of a mitered line join to half the stroke width, beyond which joins are beveled.
+// This is the same as the default SVG "stroke-miterlimit" value.
+const raster_miter_limit float64 = 4
+
+// The minimum length, in pixels, of a dash pattern. Shorter patterns are rasterized as solid lines.
+const raster_min_dash_pattern float64 = 1
+
+// rasterConfig defines configuration details for maps rendering features using raster tiles.
+type rasterConfig struct {
+	// A relative URI template for requesting raster tiles.
+	TileURL string `json:"tile_url"`
+	// A relative URI for requesting the feature nearest to a location.
+	NearestURL string `json:"nearest_url"`
+	// The maximum zoom level for which raster tiles are produced.
+	MaxZoom int `json:"max_zoom"`
+}
+
+// rasterStyle defines the colours and dimensions used to rasterize features. It is derived from `LeafletStyle`
+// instances applying the same defaults as Leaflet. The "fillRule", "className", "interactive", "bubblingMouseEvents",
+// "pane", "smoothFactor" and "noClip" options have no effect on rasterized features and circle markers are always
+// outlined with solid strokes.
+type rasterStyle struct {
+	stroke       color.NRGBA
+	fill         color.NRGBA
+	outline      rasterStroke
+	line_stroke  color.NRGBA
+	line         rasterStroke
+	point_stroke color.NRGBA
+	point_fill   color.NRGBA
+	point_weight float64
+	point_radius float64
+}
+
+// rasterStroke defines the geometry of the strokes used to rasterize polygon outlines and lines.
+type rasterStroke struct {
+	weight float64
+	// The shape used at the ends of (unclosed) strokes: "butt", "round" or "square".
+	cap string
+	// The shape used at the corners of strokes: "miter", "round" or "bevel".
+	join string
+	// The lengths of alternating dashes and gaps, or nil for solid strokes.
+	dashes      []float64
+	dash_offset float64
+}
+
+// newRasterStroke returns a new `rasterStroke` instance derived from the stroke options in 's' applying the same
+// defaults as Leaflet. If strokes are disabled the resultant stroke has zero weight.
+func newRasterStroke(s *LeafletStyle) (rasterStroke, error) {
+
+	var stroke rasterStroke
+
+	if !firstBool(s.Stroke) {
+		return stroke, nil
+	}
+
+	dashes, err := parseDashArray(s.DashArray)
+
+	if err != nil {
+		return stroke, fmt.Errorf("Invalid dash array, %w", err)
+	}
+
+	// As with SVG an odd number of dashes and gaps is repeated to yield an even number
+
+	if len(dashes)%2 == 1 {
+		dashes = append(dashes, dashes...)
+	}
+
+	pattern := 0.0
+
+	for _, d := range dashes {
+		pattern += d
+	}
+
+	if pattern < raster_min_dash_pattern {
+		dashes = nil
+	}
+
+	var dash_offset float64
+
+	if s.DashOffset != "" {
+
+		dash_offset, err = strconv.ParseFloat(strings.TrimSpace(s.DashOffset), 64)
+
+		if err != nil {
+			return stroke, fmt.Errorf("Invalid dash offset, %w", err)
+		}
+	}
+
+	stroke = rasterStroke{
+		weight:      firstFloat(3, s.Weight),
+		cap:         firstString(s.LineCap, "round"),
+		join:        firstString(s.LineJoin, "round"),
+		dashes:      dashes,
+		dash_offset: dash_offset,
+	}
+
+	return stroke, nil
+}
+
+// Extent returns the number of pixels that the stroke may extend beyond the line it follows.
+func (s rasterStroke) Extent() float64 {
+
+	hw := s.weight / 2
+
+	switch {
+	case s.join == "miter":
+		return hw * raster_miter_limit
+	case s.cap == "square":
+		return hw * math.Sqrt2
+	default:
+		return hw
+	}
+}
+
+// newRasterStyle returns a new `rasterStyle` instance derived from 'style', which applies to all geometries, and
+// 'line_style', 'polygon_style' and 'point_style' which are merged on top of it for each type of geometry. Any
+// of the styles may be nil.
+func newRasterStyle(style *LeafletStyle, line_style *LeafletStyle, polygon_style *LeafletStyle, point_style *LeafletStyle) (*rasterStyle, error) {
+
+	if style == nil {
+		style = &LeafletStyle{}
+	}
+
+	if point_style == nil {
+		point_style = &LeafletStyle{}
+	}
+
+	polygon_style = mergeStyles(style, polygon_style)
+	line_style = mergeStyles(style, line_style)
+
+	// Leaflet's default path options
+
+	opacity := firstFloat(1.0, polygon_style.Opacity)
+	fill_opacity := firstFloat(0.2, polygon_style.FillOpacity)
+
+	if !firstBool(polygon_style.Fill) {
+		fill_opacity = 0
+	}
+
+	stroke := rasterColor("color", opacity, polygon_style.Color)
+	fill := rasterColor("fillColor", fill_opacity, polygon_style.FillColor, polygon_style.Color)
+
+	outline, err := newRasterStroke(polygon_style)
+
+	if err != nil {
+		return nil, err
+	}
+
+	line_stroke := rasterColor("color", firstFloat(1.0, line_style.Opacity), line_style.Color)
+
+	line, err := newRasterStroke(line_style)
+
+	if err != nil {
+		return nil, fmt.Errorf("Invalid line style, %w", err)
+	}
+
+	// Points fall back to the options in the style for all geometries
+
+	opacity = firstFloat(1.0, style.Opacity)
+	fill_opacity = firstFloat(0.2, style.FillOpacity)
+
+	point_stroke := rasterColor("color", firstFloat(opacity, point_style.Opacity), point_style.Color, style.Color)
+
+	point_fill_opacity := firstFloat(fill_opacity, point_style.FillOpacity)
+
+	if !firstBool(point_style.Fill, style.Fill) {
+		point_fill_opacity = 0
+	}
+
+	point_fill := rasterColor("fillColor", point_fill_opacity, point_style.FillColor, point_style.Color, style.FillColor, style.Color)
+
+	point_weight := firstFloat(3, point_style.Weight, style.Weight)
+
+	if !firstBool(point_style.Stroke, style.Stroke) {
+		point_weight = 0
+	}
+
+	s := &rasterStyle{
+		stroke:       stroke,
+		fill:         fill,
+		outline:      outline,
+		line_stroke:  line_stroke,
+		line:         line,
+		point_stroke: point_stroke,
+		point_fill:   point_fill,
+		point_weight: point_weight,
+		point_radius: firstFloat(10, point_style.Radius),
+	}
+
+	return s, nil
+}
+
+// Buffer returns the number of pixels that rasterized features may extend beyond their geometries.
+func (s *rasterStyle) Buffer() float64 {
+	return max(s.outline.Extent(), s.line.Extent(), s.point_radius+s.point_weight/2) + 1
+}
+
+// rasterStyles derives the `rasterStyle` instances used to rasterize individual features. Features without a
+// style of their own are rasterized using the default style. Otherwise their style is merged on top of the default
+// styles for each type of geometry and the resultant `rasterStyle` is cached for all the features sharing that style.
+type rasterStyles struct {
+	mu            *sync.RWMutex
+	style         *LeafletStyle
+	line_style    *LeafletStyle
+	polygon_style *LeafletStyle
+	point_style   *LeafletStyle
+	default_style *rasterStyle
+	features      *featureStyles
+	styles        map[string]*rasterStyle
+	buffer        float64
+}
+
+// newRasterStyles returns a new `rasterStyles` instance derived from 'style', 'line_style', 'polygon_style' and
+// 'point_style', any of which may be nil, and the per-feature styles in 'features', which may also be nil.
+func newRasterStyles(style *LeafletStyle, line_style *LeafletStyle, polygon_style *LeafletStyle, point_style *LeafletStyle, features *featureStyles) (*rasterStyles, error) {
+
+	default_style, err := newRasterStyle(style, line_style, polygon_style, point_style)
+
+	if err != nil {
+		return nil, err
+	}
+
+	s := &rasterStyles{
+		mu:            new(sync.RWMutex),
+		style:         style,
+		line_style:    line_style,
+		polygon_style: polygon_style,
+		point_style:   point_style,
+		default_style: default_style,
+		features:      features,
+		styles:        make(map[string]*rasterStyle),
+		buffer:        default_style.Buffer(),
+	}
+
+	return s, nil
+}
+
+// Update derives the per-feature styles for any features in 'features' which have been added since the last update.
+func (s *rasterStyles) Update(features *featureCollection) {
+
+	if s.features != nil {
+		s.features.Update(features)
+	}
+}
+
+// Style returns the `rasterStyle` for the feature at 'offset'. If a feature's style can not be rasterized (for
+// example because it contains an invalid dash array) a warning is logged and the default style is used instead.
+func (s *rasterStyles) Style(offset int) *rasterStyle {
+
+	if s.features == nil {
+		return s.default_style
+	}
+
+	fs := s.features.Style(offset)
+
+	if fs == nil {
+		return s.default_style
+	}
+
+	key := fs.key()
+
+	s.mu.RLock()
+	rs, exists := s.styles[key]
+	s.mu.RUnlock()
+
+	if exists {
+		return rs
+	}
+
+	rs, err := newRasterStyle(mergeStyles(s.style, fs), mergeStyles(s.line_style, fs), mergeStyles(s.polygon_style, fs), mergeStyles(s.point_style, fs))
+
+	if err != nil {
+		slog.Warn("Failed to derive raster style for feature, using default style", "offset", offset, "error", err)
+		rs = s.default_style
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.styles[key] = rs
+	s.buffer = max(s.buffer, rs.Buffer())
+
+	return rs
+}
+
+// Buffer returns the largest number of pixels that features rasterized using any of the styles derived so far
+// may extend beyond their geometries.
+func (s *rasterStyles) Buffer() float64 {
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.buffer
+}
+
+// rasterHandler returns an `http.Handler` for serving the features in 'features' as PNG tiles rasterized using 'styles'.
+// If 'simplified' is not nil then features are rasterized using the simplified geometries for each zoom level. The handler
+// expects to be registered with a "/raster/{z}/{x}/{y}" pattern where the final "{y}" element ends in ".png". Rendered tiles are
+// cached per version of the feature collection and include an ETag header.
+func rasterHandler(features *featureCollection, simplified *simplifiedGeometries, styles *rasterStyles) http.Handler {
+
+	cache := newSizedResponseCache(raster_cache_size)
+
+	fn := func(rsp http.ResponseWriter, req *http.Request) {
+
+		t, err := rasterTileFromRequest(req)
+
+		if err != nil {
+			http.Error(rsp, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// The styles for each feature are derived from the feature collection so they only change when its version does.
+
+		version := features.Version()
+		cache_key := fmt.Sprintf("%d/%d/%d", t.Z, t.X, t.Y)
+
+		enc_body, exists := cache.Get(version, cache_key)
+
+		if exists {
+			enc_body.ServeHTTP(rsp, req)
+			return
+		}
+
+		body, err := renderRasterTile(features, simplified, styles, t)
+
+		if err != nil {
+			slog.Error("Failed to render raster tile", "z", t.Z, "x", t.X, "y", t.Y, "error", err)
+			http.Error(rsp, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		enc_body = newEncodedBody(body, "image/png")
+		// Only cache the response if the features didn't change while it was being produced.
+
+		if features.Version() == version {
+			cache.Set(version, cache_key, enc_body)
+		}
+
+		enc_body.ServeHTTP(rsp, req)
+		return
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+// rasterTileFromRequest derives a `maptile.Tile` instance from the {z}, {x} and {y} path values in 'req'.
+func rasterTileFromRequest(req *http.Request) (maptile.Tile, error) {
+
+	var t maptile.Tile
+
+	str_y, ok := strings.CutSuffix(req.PathValue("y"), ".png")
+
+	if !ok {
+		return t, fmt.Errorf("Invalid tile extension")
+	}
+
+	z, err := strconv.ParseUint(req.PathValue("z"), 10, 32)
+
+	if err != nil || maptile.Zoom(z) > raster_max_zoom {
+		return t, fmt.Errorf("Invalid zoom level")
+	}
+
+	x, err := strconv.ParseUint(req.PathValue("x"), 10, 32)
+
+	if err != nil {
+		return t, fmt.Errorf("Invalid x coordinate")
+	}
+
+	y, err := strconv.ParseUint(str_y, 10, 32)
+
+	if err != nil {
+		return t, fmt.Errorf("Invalid y coordinate")
+	}
+
+	t = maptile.New(uint32(x), uint32(y), maptile.Zoom(z))
+
+	if !t.Valid() {
+		return t, fmt.Errorf("Invalid tile")
+	}
+
+	return t, nil
+}
+
+// renderRasterTile rasterizes the features in 'features' which intersect 't' using 'styles' and returns the result
+// encoded as a PNG image. Polygons are filled and then outlined, followed by lines and then points which are drawn as circles.
+func renderRasterTile(features *featureCollection, simplified *simplifiedGeometries, styles *rasterStyles, t maptile.Tile) ([]byte, error) {
+
+	styles.Update(features)
+
+	b := t.Bound()
+
+	pad := (b.Max.X() - b.Min.X()) * styles.Buffer() / float64(raster_tile_size)
+	b = b.Pad(pad)
+
+	results := features.Query(&featuresQuery{Bound: &b})
+
+	if simplified != nil {
+		simplified.Update(features)
+		results.Features = simplified.Features(results.Features, results.Offsets, int(t.Z))
+	}
+
+	r := newTileRasterizer(t)
+
+	for i, f := range results.Features {
+
+		if f.Geometry == nil {
+			continue
+		}
+
+		g := f.Geometry
+
+		switch g.(type) {
+		case orb.Point, orb.MultiPoint:
+			// pass
+		default:
+			// Clipping modifies geometries in place so make sure to work with a copy.
+			g = clip.Geometry(b, orb.Clone(g))
+		}
+
+		r.AddGeometry(g, styles.Style(results.Offsets[i]))
+	}
+
+	im := r.Render()
+
+	var buf bytes.Buffer
+
+	err := png.Encode(&buf, im)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to encode PNG, %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// tileRasterizer accumulates the shapes (in tile pixel coordinates) used to render a raster tile. Shapes are
+// accumulated in separate layers, for each distinct style, which are composited in order. Each shape in a layer
+// is added with the same orientation so that overlapping shapes are merged, rather than cancelling each other out,
+// except for holes in polygons which are added with the opposite orientation.
+type tileRasterizer struct {
+	tile   maptile.Tile
+	layers []*rasterLayers
+	index  map[*rasterStyle]*rasterLayers
+}
+
+// rasterLayers are the layers used to render the shapes for features sharing the same style.
+type rasterLayers struct {
+	style         *rasterStyle
+	fills         *vector.Rasterizer
+	strokes       *vector.Rasterizer
+	lines         *vector.Rasterizer
+	point_fills   *vector.Rasterizer
+	point_strokes *vector.Rasterizer
+	empty         map[*vector.Rasterizer]bool
+}
+
+func newTileRasterizer(t maptile.Tile) *tileRasterizer {
+
+	r := &tileRasterizer{
+		tile:   t,
+		layers: make([]*rasterLayers, 0),
+		index:  make(map[*rasterStyle]*rasterLayers),
+	}
+
+	return r
+}
+
+// AddGeometry adds the shapes used to render 'g' using 'style' to the rasterizer.
+func (r *tileRasterizer) AddGeometry(g orb.Geometry, style *rasterStyle) {
+
+	l := r.layersForStyle(style)
+
+	switch geom := g.(type) {
+	case orb.Point:
+		r.addPoint(l, geom)
+	case orb.MultiPoint:
+
+		for _, pt := range geom {
+			r.addPoint(l, pt)
+		}
+
+	case orb.LineString:
+		l.addStroke(l.lines, r.project(geom), style.line)
+	case orb.MultiLineString:
+
+		for _, ls := range geom {
+			l.addStroke(l.lines, r.project(ls), style.line)
+		}
+
+	case orb.Ring:
+		r.addPolygon(l, orb.Polygon{geom})
+	case orb.Polygon:
+		r.addPolygon(l, geom)
+	case orb.MultiPolygon:
+
+		for _, p := range geom {
+			r.addPolygon(l, p)
+		}
+
+	case orb.Bound:
+		r.addPolygon(l, geom.ToPolygon())
+	case orb.Collection:
+
+		for _, cg := range geom {
+			r.AddGeometry(cg, style)
+		}
+	}
+}
+
+// Render composites each layer of the rasterizer and returns the resultant image. The fills for every style are
+// composited first, followed by the polygon outlines for every style, then lines and then points.
+func (r *tileRasterizer) Render() image.Image {
+
+	im := image.NewRGBA(image.Rect(0, 0, raster_tile_size, raster_tile_size))
+
+	draw_layer := func(v *vector.Rasterizer, c color.NRGBA, empty bool) {
+
+		if empty {
+			return
+		}
+
+		v.DrawOp = draw.Over
+		v.Draw(im, im.Bounds(), image.NewUniform(c), image.Point{})
+	}
+
+	for _, l := range r.layers {
+		draw_layer(l.fills, l.style.fill, l.empty[l.fills])
+	}
+
+	for _, l := range r.layers {
+		draw_layer(l.strokes, l.style.stroke, l.empty[l.strokes])
+	}
+
+	for _, l := range r.layers {
+		draw_layer(l.lines, l.style.line_stroke, l.empty[l.lines])
+	}
+
+	for _, l := range r.layers {
+		draw_layer(l.point_fills, l.style.point_fill, l.empty[l.point_fills])
+		draw_layer(l.point_strokes, l.style.point_stroke, l.empty[l.point_strokes])
+	}
+
+	return im
+}
+
+// layersForStyle returns the layers used to render shapes using 'style', creating them if necessary.
+func (r *tileRasterizer) layersForStyle(style *rasterStyle) *rasterLayers {
+
+	l, exists := r.index[style]
+
+	if exists {
+		return l
+	}
+
+	l = &rasterLayers{
+		style:         style,
+		fills:         vector.NewRasterizer(raster_tile_size, raster_tile_size),
+		strokes:       vector.NewRasterizer(raster_tile_size, raster_tile_size),
+		lines:         vector.NewRasterizer(raster_tile_size, raster_tile_size),
+		point_fills:   vector.NewRasterizer(raster_tile_size, raster_tile_size),
+		point_strokes: vector.NewRasterizer(raster_tile_size, raster_tile_size),
+		empty:         make(map[*vector.Rasterizer]bool),
+	}
+
+	for _, v := range []*vector.Rasterizer{l.fills, l.strokes, l.lines, l.point_fills, l.point_strokes} {
+		l.empty[v] = true
+	}
+
+	r.index[style] = l
+	r.layers = append(r.layers, l)
+
+	return l
+}
+
+func (r *tileRasterizer) addPoint(l *rasterLayers, pt orb.Point) {
+
+	px := r.projectPoint(pt)
+
+	radius := l.style.point_radius
+	weight := l.style.point_weight
+
+	if l.style.point_fill.A > 0 {
+		l.addShape(l.point_fills, circle(px, radius), false)
+	}
+
+	if weight > 0 {
+		l.addShape(l.point_strokes, circle(px, radius+weight/2), false)
+		l.addShape(l.point_strokes, circle(px, max(radius-weight/2, 0)), true)
+	}
+}
+
+func (r *tileRasterizer) addPolygon(l *rasterLayers, p orb.Polygon) {
+
+	for i, ring := range p {
+
+		px := r.project(ring)
+
+		if len(px) < 3 {
+			continue
+		}
+
+		if l.style.fill.A > 0 {
+			l.addShape(l.fills, px, i > 0)
+		}
+
+		l.addStroke(l.strokes, px, l.style.outline)
+	}
+}
+
+// addStroke adds the shapes used to stroke 'line' with 'stroke' to 'v'. Dashed strokes are split in to a separate
+// line for each dash.
+func (l *rasterLayers) addStroke(v *vector.Rasterizer, line []orb.Point, stroke rasterStroke) {
+
+	if stroke.weight <= 0 || len(line) == 0 {
+		return
+	}
+
+	if stroke.dashes == nil {
+		closed := len(line) > 3 && line[0] == line[len(line)-1]
+		l.addStrokePath(v, line, stroke, closed)
+		return
+	}
+
+	for _, dash := range dashLine(line, stroke.dashes, stroke.dash_offset) {
+		l.addStrokePath(v, dash, stroke, false)
+	}
+}
+
+// addStrokePath adds 'line' to 'v' as a series of rectangles (one for each segment) with shapes at each vertex
+// to join them and at each end, unless 'closed' is true, for the line's caps.
+func (l *rasterLayers) addStrokePath(v *vector.Rasterizer, line []orb.Point, stroke rasterStroke, closed bool) {
+
+	hw := stroke.weight / 2
+
+	// Remove repeated points since they have no direction
+
+	pts := make([]orb.Point, 0, len(line))
+
+	for _, pt := range line {
+
+		if len(pts) == 0 || pts[len(pts)-1] != pt {
+			pts = append(pts, pt)
+		}
+	}
+
+	if len(pts) == 1 {
+
+		switch stroke.cap {
+		case "round":
+			l.addShape(v, circle(pts[0], hw), false)
+		case "square":
+			pt := pts[0]
+			l.addShape(v, []orb.Point{{pt[0] - hw, pt[1] - hw}, {pt[0] + hw, pt[1] - hw}, {pt[0] + hw, pt[1] + hw}, {pt[0] - hw, pt[1] + hw}}, false)
+		}
+
+		return
+	}
+
+	// The unit direction and offset, perpendicular to the line, for each segment
+
+	count := len(pts) - 1
+	dirs := make([]orb.Point, count)
+	normals := make([]orb.Point, count)
+
+	for i := 0; i < count; i++ {
+
+		dx := pts[i+1][0] - pts[i][0]
+		dy := pts[i+1][1] - pts[i][1]
+		d := math.Hypot(dx, dy)
+
+		dirs[i] = orb.Point{dx / d, dy / d}
+		normals[i] = orb.Point{-dy / d * hw, dx / d * hw}
+	}
+
+	for i := 0; i < count; i++ {
+
+		start := pts[i]
+		end := pts[i+1]
+
+		// Square caps extend the line by half the stroke width
+
+		if !closed && stroke.cap == "square" {
+
+			if i == 0 {
+				start = orb.Point{start[0] - dirs[i][0]*hw, start[1] - dirs[i][1]*hw}
+			}
+
+			if i == count-1 {
+				end = orb.Point{end[0] + dirs[i][0]*hw, end[1] + dirs[i][1]*hw}
+			}
+		}
+
+		n := normals[i]
+
+		quad := []orb.Point{
+			{start[0] + n[0], start[1] + n[1]},
+			{end[0] + n[0], end[1] + n[1]},
+			{end[0] - n[0], end[1] - n[1]},
+			{start[0] - n[0], start[1] - n[1]},
+		}
+
+		l.addShape(v, quad, false)
+	}
+
+	for i := 1; i < count; i++ {
+		l.addJoin(v, pts[i], dirs[i-1], normals[i-1], dirs[i], normals[i], stroke)
+	}
+
+	if closed {
+		l.addJoin(v, pts[0], dirs[count-1], normals[count-1], dirs[0], normals[0], stroke)
+		return
+	}
+
+	if stroke.cap == "round" {
+		l.addShape(v, circle(pts[0], hw), false)
+		l.addShape(v, circle(pts[count], hw), false)
+	}
+}
+
+// addJoin adds the shape used to join two segments of a stroke, with unit directions 'd1' and 'd2' and perpendicular
+// offsets 'n1' and 'n2', meeting at 'pt' to 'v'.
+func (l *rasterLayers) addJoin(v *vector.Rasterizer, pt orb.Point, d1 orb.Point, n1 orb.Point, d2 orb.Point, n2 orb.Point, stroke rasterStroke) {
+
+	hw := stroke.weight / 2
+
+	if stroke.join == "round" {
+
+		if stroke.weight > 1 {
+			l.addShape(v, circle(pt, hw), false)
+		}
+
+		return
+	}
+
+	// The gap between segments is on the outside of the turn, away from the direction of the second segment
+
+	side := 1.0
+
+	if n1[0]*d2[0]+n1[1]*d2[1] > 0 {
+		side = -1.0
+	}
+
+	a := orb.Point{pt[0] + n1[0]*side, pt[1] + n1[1]*side}
+	b := orb.Point{pt[0] + n2[0]*side, pt[1] + n2[1]*side}
+
+	if stroke.join == "miter" {
+
+		// The miter extends to where the outer edges of both segments intersect
+
+		mx := n1[0] + n2[0]
+		my := n1[1] + n2[1]
+		m := math.Hypot(mx, my)
+
+		if m > 0 {
+
+			cos := (mx*n1[0] + my*n1[1]) / (m * hw)
+
+			if cos > 0 && 1/cos <= raster_miter_limit {
+
+				length := hw / cos
+				tip := orb.Point{pt[0] + mx/m*length*side, pt[1] + my/m*length*side}
+
+				l.addShape(v, []orb.Point{pt, a, tip, b}, false)
+				return
+			}
+		}
+	}
+
+	l.addShape(v, []orb.Point{pt, a, b}, false)
+}
+
+// dashLine splits 'line' in to the lines for each dash in the pattern of alternating dash and gap lengths
+// 'dashes', which must have an even number of elements, starting 'offset' pixels in to the pattern.
+func dashLine(line []orb.Point, dashes []float64, offset float64) [][]orb.Point {
+
+	pattern := 0.0
+
+	for _, d := range dashes {
+		pattern += d
+	}
+
+	// Find the position in the pattern that the line starts at
+
+	idx := 0
+	remaining := dashes[0]
+
+	offset = math.Mod(offset, pattern)
+
+	if offset < 0 {
+		offset += pattern
+	}
+
+	for offset > 0 {
+
+		if offset >= remaining {
+			offset -= remaining
+			idx = (idx + 1) % len(dashes)
+			remaining = dashes[idx]
+		} else {
+			remaining -= offset
+			offset = 0
+		}
+	}
+
+	lines := make([][]orb.Point, 0)
+	var current []orb.Point
+
+	if idx%2 == 0 {
+		current = []orb.Point{line[0]}
+	}
+
+	for i := 1; i < len(line); i++ {
+
+		a := line[i-1]
+		b := line[i]
+
+		length := math.Hypot(b[0]-a[0], b[1]-a[1])
+		pos := 0.0
+
+		for length-pos > remaining {
+
+			pos += remaining
+
+			t := pos / length
+			pt := orb.Point{a[0] + (b[0]-a[0])*t, a[1] + (b[1]-a[1])*t}
+
+			if idx%2 == 0 {
+				lines = append(lines, append(current, pt))
+				current = nil
+			} else {
+				current = []orb.Point{pt}
+			}
+
+			idx = (idx + 1) % len(dashes)
+			remaining = dashes[idx]
+		}
+
+		remaining -= length - pos
+
+		if idx%2 == 0 {
+			current = append(current, b)
+		}
+	}
+
+	if len(current) > 0 {
+		lines = append(lines, current)
+	}
+
+	return lines
+}
+
+// addShape adds the closed path 'pts' to 'v'. Shapes are oriented so that their signed area is negative,
+// or positive if 'hole' is true.
+func (l *rasterLayers) addShape(v *vector.Rasterizer, pts []orb.Point, hole bool) {
+
+	if len(pts) < 3 {
+		return
+	}
+
+	area := 0.0
+
+	for i, pt := range pts {
+		next := pts[(i+1)%len(pts)]
+		area += pt[0]*next[1] - next[0]*pt[1]
+	}
+
+	reverse := (area > 0) != hole
+
+	at := func(i int) orb.Point {
+
+		if reverse {
+			return pts[len(pts)-1-i]
+		}
+
+		return pts[i]
+	}
+
+	first := at(0)
+	v.MoveTo(float32(first[0]), float32(first[1]))
+
+	for i := 1; i < len(pts); i++ {
+		pt := at(i)
+		v.LineTo(float32(pt[0]), float32(pt[1]))
+	}
+
+	v.ClosePath()
+	l.empty[v] = false
+}
+
+// project returns 'points' projected in to the pixel coordinates of the rasterizer's tile.
+func (r *tileRasterizer) project(points []orb.Point) []orb.Point {
+
+	px := make([]orb.Point, len(points))
+
+	for i, pt := range points {
+		px[i] = r.projectPoint(pt)
+	}
+
+	return px
+}
+
+func (r *tileRasterizer) projectPoint(pt orb.Point) orb.Point {
+
+	scale := math.Pow(2, float64(r.tile.Z))
+	size := float64(raster_tile_size)
+
+	x := (lngX(pt.Lon())*scale - float64(r.tile.X)) * size
+	y := (latY(pt.Lat())*scale - float64(r.tile.Y)) * size
+
+	return orb.Point{x, y}
+}
+
+// circle returns a polygon approximating a circle centered on 'center' with 'radius'.
+func circle(center orb.Point, radius float64) []orb.Point {
+
+	pts := make([]orb.Point, raster_circle_segments)
+
+	for i := range pts {
+		a := 2 * math.Pi * float64(i) / float64(raster_circle_segments)
+		pts[i] = orb.Point{center[0] + radius*math.Cos(a), center[1] + radius*math.Sin(a)}
+	}
+
+	return pts
+}
+
+// nearestHandler returns an `http.Handler` for serving the feature in 'features' nearest to a location as a GeoJSON
+// FeatureCollection containing zero or one features. The location is read from the required "lat", "lon" and "z" (zoom level)
+// query parameters. Only features within the distance, in pixels at the zoom level, that they are rasterized using 'styles' are
+// considered. The FeatureCollection includes a "show:ids" foreign member containing the "show:id" identifier for the feature.
+func nearestHandler(features *featureCollection, styles *rasterStyles) http.Handler {
+
+	fn := func(rsp http.ResponseWriter, req *http.Request) {
+
+		params := req.URL.Query()
+
+		lat, err := strconv.ParseFloat(params.Get("lat"), 64)
+
+		if err != nil || lat < -90 || lat > 90 {
+			http.Error(rsp, "Invalid lat parameter", http.StatusBadRequest)
+			return
+		}
+
+		lon, err := strconv.ParseFloat(params.Get("lon"), 64)
+
+		if err != nil || lon < -180 || lon > 180 {
+			http.Error(rsp, "Invalid lon parameter", http.StatusBadRequest)
+			return
+		}
+
+		z, err := strconv.Atoi(params.Get("z"))
+
+		if err != nil || z < 0 {
+			http.Error(rsp, "Invalid z parameter", http.StatusBadRequest)
+			return
+		}
+
+		fc := geojson.NewFeatureCollection()
+		ids := make([]string, 0)
+
+		f, id, ok := nearestFeature(features, orb.Point{lon, lat}, z, styles.Buffer())
+
+		if ok {
+			fc.Append(f)
+			ids = append(ids, id)
+		}
+
+		fc.ExtraMembers = geojson.Properties{
+			"show:ids": ids,
+		}
+
+		enc_json, err := fc.MarshalJSON()
+
+		if err != nil {
+			slog.Error("Failed to marshal nearest feature", "error", err)
+			http.Error(rsp, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		rsp.Header().Set("Content-type", "application/json")
+		rsp.Write(enc_json)
+		return
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+// nearestFeature returns the feature in 'features', and its "show:id" identifier, nearest to 'pt' and within
+// 'tolerance' pixels at zoom level 'z'. Points and lines are preferred over polygons containing 'pt'. If more than
+// one polygon contains 'pt' the smallest is returned.
+func nearestFeature(features *featureCollection, pt orb.Point, z int, tolerance float64) (*geojson.Feature, string, bool) {
+
+	// The size of a pixel, in degrees, at zoom level 'z' and latitude of 'pt'
+
+	px_x := 360.0 / (float64(raster_tile_size) * math.Pow(2, float64(z)))
+	px_y := px_x * math.Cos(pt.Lat()*math.Pi/180)
+
+	tol := tolerance * px_x
+
+	b := orb.Bound{
+		Min: orb.Point{pt.Lon() - tol, pt.Lat() - tolerance*px_y},
+		Max: orb.Point{pt.Lon() + tol, pt.Lat() + tolerance*px_y},
+	}
+
+	results := features.Query(&featuresQuery{Bound: &b})
+
+	best := -1
+	best_distance := math.Inf(1)
+	best_area := math.Inf(1)
+
+	for i, f := range results.Features {
+
+		if f.Geometry == nil {
+			continue
+		}
+
+		d := planar.DistanceFrom(f.Geometry, pt)
+		area := 0.0
+
+		if containsPoint(f.Geometry, pt) {
+			d = tol
+			area = planar.Area(f.Geometry)
+		}
+
+		if d > tol {
+			continue
+		}
+
+		if d < best_distance || (d == best_distance && area < best_area) {
+			best = i
+			best_distance = d
+			best_area = area
+		}
+	}
+
+	if best == -1 {
+		return nil, "", false
+	}
+
+	return results.Features[best], results.Ids[best], true
+}
+
+// containsPoint returns true if 'g' is a polygon, or multipolygon, which contains 'pt'.
+func containsPoint(g orb.Geometry, pt orb.Point) bool {
+
+	switch geom := g.(type) {
+	case orb.Polygon:
+		return planar.PolygonContains(geom, pt)
+	case orb.MultiPolygon:
+		return planar.MultiPolygonContains(geom, pt)
+	case orb.Bound:
+		return geom.Contains(pt)
+	case orb.Collection:
+
+		for _, cg := range geom {
+
+			if containsPoint(cg, pt) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// rasterColor parses the first valid CSS colour in 'values', ignoring empty strings, and multiplies its alpha value by
+// 'opacity'. Invalid colours are logged and skipped. If there are no valid colours Leaflet's default colour is used.
+func rasterColor(option string, opacity float64, values ...string) color.NRGBA {
+
+	for _, v := range values {
+
+		if v == "" {
+			continue
+		}
+
+		c, err := parseColor(v, opacity)
+
+		if err != nil {
+			slog.Warn("Invalid colour for raster tiles, ignoring", "option", option, "color", v, "error", err)
+			continue
+		}
+
+		return c
+	}
+
+	c, _ := parseColor(raster_default_color, opacity)
+	return c
+}
+
+// firstString returns the first non-empty string in 'values'.
+func firstString(values ...string) string {
+
+	for _, v := range values {
+
+		if v != "" {
+			return v
+		}
+	}
+
+	return ""
+}
+
+// firstBool returns the value of the first non-nil pointer in 'values' or true if they are all nil.
+func firstBool(values ...*bool) bool {
+
+	for _, v := range values {
+
+		if v != nil {
+			return *v
+		}
+	}
+
+	return true
+}
+
+// firstFloat returns the first non-nil value in 'values' or 'fallback' if all the values are nil.
+func firstFloat(fallback float64, values ...*float64) float64 {
+
+	for _, v := range values {
+
+		if v != nil {
+			return *v
+		}
+	}
+
+	return fallback
+}
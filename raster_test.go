@@ -0,0 +1,323 @@
+package show
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+	"github.com/paulmach/orb/maptile"
+)
+
+// pixelPoint returns the longitude and latitude of the pixel coordinates 'x' and 'y' in the zoom level 0 tile.
+func pixelPoint(x float64, y float64) orb.Point {
+	size := float64(raster_tile_size)
+	return orb.Point{xLng(x / size), yLat(y / size)}
+}
+
+// pixelSquare returns a ring for the square with corners at the pixel coordinates 'min' and 'max' in the zoom level 0 tile.
+func pixelSquare(min float64, max float64) orb.Ring {
+	return orb.Ring{pixelPoint(min, min), pixelPoint(max, min), pixelPoint(max, max), pixelPoint(min, max), pixelPoint(min, min)}
+}
+
+// rasterizeTestGeometry renders 'g' in the zoom level 0 tile using the style defined by the JSON-encoded 'str_style'.
+func rasterizeTestGeometry(t *testing.T, g orb.Geometry, str_style string) image.Image {
+
+	style, err := UnmarshalStyleFromString(str_style)
+
+	if err != nil {
+		t.Fatalf("Failed to parse style, %v", err)
+	}
+
+	rs, err := newRasterStyle(style, nil, nil, style)
+
+	if err != nil {
+		t.Fatalf("Failed to derive raster style, %v", err)
+	}
+
+	r := newTileRasterizer(maptile.New(0, 0, 0))
+	r.AddGeometry(g, rs)
+
+	return r.Render()
+}
+
+func TestRasterize(t *testing.T) {
+
+	red := color.NRGBA{255, 0, 0, 255}
+	green := color.NRGBA{0, 255, 0, 255}
+	blue := color.NRGBA{0, 0, 255, 255}
+	transparent := color.NRGBA{}
+
+	type pixel struct {
+		x     int
+		y     int
+		color color.NRGBA
+	}
+
+	tests := []struct {
+		name   string
+		geom   orb.Geometry
+		style  string
+		pixels []pixel
+	}{
+		{
+			name:  "polygon",
+			geom:  orb.Polygon{pixelSquare(64, 192)},
+			style: `{"fillColor": "#ff0000", "fillOpacity": 1, "stroke": false}`,
+			pixels: []pixel{
+				{128, 128, red},
+				{66, 66, red},
+				{32, 32, transparent},
+				{200, 128, transparent},
+			},
+		},
+		{
+			name:  "polygon with hole",
+			geom:  orb.Polygon{pixelSquare(64, 192), reverseRing(pixelSquare(112, 144))},
+			style: `{"fillColor": "#ff0000", "fillOpacity": 1, "stroke": false}`,
+			pixels: []pixel{
+				{80, 80, red},
+				{128, 128, transparent},
+			},
+		},
+		{
+			name:  "polygon with zero fill opacity",
+			geom:  orb.Polygon{pixelSquare(64, 192)},
+			style: `{"color": "#00ff00", "weight": 4, "fillColor": "#ff0000", "fillOpacity": 0}`,
+			pixels: []pixel{
+				{64, 128, green},
+				{128, 128, transparent},
+			},
+		},
+		{
+			name:  "polygon outline",
+			geom:  orb.Polygon{pixelSquare(64, 192)},
+			style: `{"color": "#00ff00", "weight": 4, "fill": false}`,
+			pixels: []pixel{
+				{64, 128, green},
+				{128, 192, green},
+				{128, 128, transparent},
+				{72, 128, transparent},
+			},
+		},
+		{
+			name:  "line",
+			geom:  orb.LineString{pixelPoint(32, 128.5), pixelPoint(224, 128.5)},
+			style: `{"color": "#00ff00", "weight": 4}`,
+			pixels: []pixel{
+				{128, 128, green},
+				{33, 128, green},
+				{128, 136, transparent},
+				{24, 128, transparent},
+			},
+		},
+		{
+			name:  "dashed line",
+			geom:  orb.LineString{pixelPoint(32, 128.5), pixelPoint(224, 128.5)},
+			style: `{"color": "#00ff00", "weight": 4, "lineCap": "butt", "dashArray": "10 10"}`,
+			pixels: []pixel{
+				{36, 128, green},
+				{47, 128, transparent},
+				{56, 128, green},
+			},
+		},
+		{
+			name:  "point",
+			geom:  pixelPoint(128.5, 128.5),
+			style: `{"fillColor": "#0000ff", "fillOpacity": 1, "radius": 10, "stroke": false}`,
+			pixels: []pixel{
+				{128, 128, blue},
+				{134, 128, blue},
+				{142, 128, transparent},
+			},
+		},
+	}
+
+	for _, test := range tests {
+
+		t.Run(test.name, func(t *testing.T) {
+
+			im := rasterizeTestGeometry(t, test.geom, test.style)
+
+			for _, px := range test.pixels {
+
+				c := color.NRGBAModel.Convert(im.At(px.x, px.y)).(color.NRGBA)
+
+				if c != px.color {
+					t.Errorf("Expected pixel %d,%d to be %v, got %v", px.x, px.y, px.color, c)
+				}
+			}
+		})
+	}
+}
+
+func reverseRing(r orb.Ring) orb.Ring {
+
+	reversed := make(orb.Ring, len(r))
+
+	for i, pt := range r {
+		reversed[len(r)-1-i] = pt
+	}
+
+	return reversed
+}
+
+func TestRasterHandler(t *testing.T) {
+
+	ctx := context.Background()
+
+	f := geojson.NewFeature(orb.Polygon{pixelSquare(64, 192)})
+
+	features, err := newFeatureCollection(ctx, []*geojson.Feature{f}, nil, "")
+
+	if err != nil {
+		t.Fatalf("Failed to create feature collection, %v", err)
+	}
+
+	styles, err := newRasterStyles(nil, nil, nil, nil, nil)
+
+	if err != nil {
+		t.Fatalf("Failed to derive raster styles, %v", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/raster/{z}/{x}/{y}", rasterHandler(features, nil, styles))
+
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{"/raster/0/0/0.png", 200},
+		{"/raster/1/1/1.png", 200},
+		{"/raster/0/0/0.jpg", 400},
+		{"/raster/0/1/0.png", 400},
+		{"/raster/23/0/0.png", 400},
+	}
+
+	for _, test := range tests {
+
+		t.Run(test.path, func(t *testing.T) {
+
+			rsp := httptest.NewRecorder()
+			mux.ServeHTTP(rsp, httptest.NewRequest("GET", test.path, nil))
+
+			if rsp.Code != test.status {
+				t.Fatalf("Expected status code %d, got %d", test.status, rsp.Code)
+			}
+
+			if test.status != 200 {
+				return
+			}
+
+			im, err := png.Decode(bytes.NewReader(rsp.Body.Bytes()))
+
+			if err != nil {
+				t.Fatalf("Failed to decode PNG, %v", err)
+			}
+
+			if im.Bounds().Dx() != raster_tile_size {
+				t.Fatalf("Unexpected tile size, %d", im.Bounds().Dx())
+			}
+
+			// Cached tiles are served with the same ETag
+
+			etag := rsp.Header().Get("ETag")
+
+			req := httptest.NewRequest("GET", test.path, nil)
+			req.Header.Set("If-None-Match", etag)
+
+			rsp = httptest.NewRecorder()
+			mux.ServeHTTP(rsp, req)
+
+			if rsp.Code != http.StatusNotModified {
+				t.Fatalf("Expected status code 304, got %d", rsp.Code)
+			}
+		})
+	}
+
+	// Adding features changes the version of the collection and invalidates cached tiles
+
+	rsp := httptest.NewRecorder()
+	mux.ServeHTTP(rsp, httptest.NewRequest("GET", "/raster/0/0/0.png", nil))
+	etag := rsp.Header().Get("ETag")
+
+	err = features.Add(ctx, geojson.NewFeature(pixelPoint(32, 32)))
+
+	if err != nil {
+		t.Fatalf("Failed to add feature, %v", err)
+	}
+
+	rsp = httptest.NewRecorder()
+	mux.ServeHTTP(rsp, httptest.NewRequest("GET", "/raster/0/0/0.png", nil))
+
+	if rsp.Header().Get("ETag") == etag {
+		t.Fatalf("Expected a new tile after adding features")
+	}
+}
+
+func TestNewRasterStyleInvalidColors(t *testing.T) {
+
+	tests := []struct {
+		name        string
+		style       *LeafletStyle
+		point_style *LeafletStyle
+		stroke      color.NRGBA
+		fill        color.NRGBA
+		point_fill  color.NRGBA
+	}{
+		{
+			name:       "invalid color",
+			style:      &LeafletStyle{Color: "notacolour"},
+			stroke:     color.NRGBA{51, 136, 255, 255},
+			fill:       color.NRGBA{51, 136, 255, 51},
+			point_fill: color.NRGBA{51, 136, 255, 51},
+		},
+		{
+			name:        "invalid fill color",
+			style:       &LeafletStyle{Color: "red", FillColor: "rgb(1, 2)", FillOpacity: Float(1)},
+			point_style: &LeafletStyle{FillColor: "#nope"},
+			stroke:      color.NRGBA{255, 0, 0, 255},
+			fill:        color.NRGBA{255, 0, 0, 255},
+			point_fill:  color.NRGBA{255, 0, 0, 255},
+		},
+		{
+			name:       "hsl color",
+			style:      &LeafletStyle{Color: "hsl(120, 100%, 25%)", FillColor: "navajowhite", FillOpacity: Float(1)},
+			stroke:     color.NRGBA{0, 128, 0, 255},
+			fill:       color.NRGBA{255, 222, 173, 255},
+			point_fill: color.NRGBA{255, 222, 173, 255},
+		},
+	}
+
+	for _, test := range tests {
+
+		t.Run(test.name, func(t *testing.T) {
+
+			styles, err := newRasterStyles(test.style, nil, nil, test.point_style, nil)
+
+			if err != nil {
+				t.Fatalf("Failed to derive raster styles, %v", err)
+			}
+
+			s := styles.default_style
+
+			if s.stroke != test.stroke {
+				t.Errorf("Expected stroke %v, got %v", test.stroke, s.stroke)
+			}
+
+			if s.fill != test.fill {
+				t.Errorf("Expected fill %v, got %v", test.fill, s.fill)
+			}
+
+			if s.point_fill != test.point_fill {
+				t.Errorf("Expected point fill %v, got %v", test.point_fill, s.point_fill)
+			}
+		})
+	}
+}
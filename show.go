@@ -2,29 +2,18 @@ package show
 
 import (
 	"context"
-	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
-	"log"
-	"log/slog"
 	"net/http"
-	"net/url"
 	"os"
-	"strings"
 
 	"github.com/paulmach/orb/geojson"
-	"github.com/sfomuseum/go-geojson-show/static/www"
-	"github.com/sfomuseum/go-http-protomaps"
+	"github.com/sfomuseum/go-flags/multi"
 	www_show "github.com/sfomuseum/go-www-show/v2"
 	"github.com/tidwall/gjson"
-	wasm_js "github.com/whosonfirst/go-whosonfirst-format-wasm/static/javascript"
-	"github.com/whosonfirst/go-whosonfirst-format-wasm/static/wasm"
 )
 
-const leaflet_osm_tile_url = "https://tile.openstreetmap.org/{z}/{x}/{y}.png"
-const protomaps_api_tile_url string = "https://api.protomaps.com/tiles/v3/{z}/{x}/{y}.mvt?key={key}"
-
 func Run(ctx context.Context) error {
 	fs := DefaultFlagSet()
 	return RunWithFlagSet(ctx, fs)
@@ -40,197 +29,186 @@ func RunWithFlagSet(ctx context.Context, fs *flag.FlagSet) error {
 
 	fs_uris := fs.Args()
 
-	features := make([]*geojson.Feature, 0)
+	dataset_uris, err := flagValue[multi.KeyValueString](fs, "dataset")
 
-	append_features := func(r io.Reader) error {
+	if err != nil {
+		return err
+	}
 
-		body, err := io.ReadAll(r)
+	if len(dataset_uris) > 0 {
 
-		if err != nil {
-			return fmt.Errorf("Failed to read body, %w", err)
+		if len(fs_uris) > 0 {
+			return fmt.Errorf("Positional arguments can not be combined with the -dataset flag")
 		}
 
-		type_rsp := gjson.GetBytes(body, "type")
-
-		switch type_rsp.String() {
-		case "Feature":
-
-			f, err := geojson.UnmarshalFeature(body)
+		ds, err := datasetsFromFlags(ctx, opts, dataset_uris)
 
-			if err != nil {
-				return fmt.Errorf("Failed to unmarshal Feature, %w", err)
-			}
+		if err != nil {
+			return fmt.Errorf("Failed to derive datasets, %w", err)
+		}
 
-			features = append(features, f)
+		opts.Datasets = ds
+		return RunWithOptions(ctx, opts)
+	}
 
-		case "FeatureCollection":
+	features, err := readFeatures(fs_uris...)
 
-			other_fc, err := geojson.UnmarshalFeatureCollection(body)
+	if err != nil {
+		return err
+	}
 
-			if err != nil {
-				return fmt.Errorf("Failed to unmarshal record as FeatureCollection, %w", err)
-			}
+	opts.Features = features
 
-			for _, f := range other_fc.Features {
-				features = append(features, f)
-			}
+	return RunWithOptions(ctx, opts)
+}
 
-		default:
-			return fmt.Errorf("Invalid type, %s", type_rsp.String())
-		}
+// RunWithOptions starts a local web server, and opens a browser, to display the features (or named datasets) defined in 'opts'.
+func RunWithOptions(ctx context.Context, opts *RunOptions) error {
 
-		return nil
-	}
+	mux := http.NewServeMux()
 
-	stdin := false
+	if opts.Datasets != nil {
 
-	if len(fs_uris) == 1 && fs_uris[0] == "-" {
-		stdin = true
-	}
+		mux.Handle(datasets_prefix+"/", http.StripPrefix(datasets_prefix, opts.Datasets))
+		mux.Handle("/{$}", http.RedirectHandler(datasets_prefix+"/", http.StatusFound))
 
-	if stdin {
+	} else {
 
-		err := append_features(os.Stdin)
+		show_handler, err := NewHandler(ctx, opts)
 
 		if err != nil {
-			return fmt.Errorf("Failed to append features, %v", err)
+			return fmt.Errorf("Failed to create show handler, %w", err)
 		}
 
-	} else {
+		mux.Handle("/", show_handler)
+	}
 
-		for _, path := range fs_uris {
+	www_show_opts := &www_show.RunOptions{
+		Port:    opts.Port,
+		Browser: opts.Browser,
+		Mux:     mux,
+	}
 
-			r, err := os.Open(path)
+	return www_show.RunWithOptions(ctx, www_show_opts)
+}
 
-			if err != nil {
-				return fmt.Errorf("Failed to open %s for reading, %v", path, err)
-			}
+// datasetsFromFlags returns a new `Datasets` instance derived from a list of "{NAME}={PATH}" flag values. Each dataset
+// inherits the map configuration defined in 'opts'.
+func datasetsFromFlags(ctx context.Context, opts *RunOptions, kv_uris multi.KeyValueString) (*Datasets, error) {
 
-			defer r.Close()
+	names := make([]string, 0)
+	paths := make(map[string][]string)
 
-			err = append_features(r)
+	for _, kv := range kv_uris {
 
-			if err != nil {
-				return fmt.Errorf("Failed to append features, %v", err)
-			}
-		}
-	}
+		name := kv.Key()
+		path := kv.Value().(string)
 
-	opts.Features = features
+		_, exists := paths[name]
 
-	return RunWithOptions(ctx, opts)
-}
+		if !exists {
+			names = append(names, name)
+			paths[name] = make([]string, 0)
+		}
 
-func RunWithOptions(ctx context.Context, opts *RunOptions) error {
+		paths[name] = append(paths[name], path)
+	}
 
-	mux := http.NewServeMux()
+	ds := NewDatasets()
 
-	www_fs := http.FS(www.FS)
-	mux.Handle("/", http.FileServer(www_fs))
+	for _, name := range names {
 
-	wasm_fs := http.FS(wasm.FS)
-	wasm_handler := http.FileServer(wasm_fs)
+		features, err := readFeatures(paths[name]...)
 
-	wasm_js_fs := http.FS(wasm_js.FS)
-	wasm_js_handler := http.FileServer(wasm_js_fs)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read features for dataset '%s', %w", name, err)
+		}
 
-	mux.Handle("/javascript/wasm/", http.StripPrefix("/javascript/wasm/", wasm_js_handler))
-	mux.Handle("/wasm/", http.StripPrefix("/wasm/", wasm_handler))
+		ds_opts := *opts
+		ds_opts.Features = features
 
-	fc := geojson.NewFeatureCollection()
-	fc.Features = opts.Features
-	data_handler := dataHandler(fc)
+		err = ds.Add(ctx, name, &ds_opts)
 
-	mux.Handle("/features.geojson", data_handler)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to add dataset '%s', %w", name, err)
+		}
+	}
 
-	//
+	return ds, nil
+}
 
-	map_cfg := &mapConfig{
-		Provider:        opts.MapProvider,
-		TileURL:         opts.MapTileURI,
-		Style:           opts.Style,
-		PointStyle:      opts.PointStyle,
-		LabelProperties: opts.LabelProperties,
-	}
+// readFeatures reads and returns all the GeoJSON features contained in 'uris'. Each URI may be a GeoJSON Feature
+// or FeatureCollection. If the only URI is "-" then data will be read from STDIN.
+func readFeatures(uris ...string) ([]*geojson.Feature, error) {
 
-	if map_provider == "protomaps" {
+	features := make([]*geojson.Feature, 0)
 
-		u, err := url.Parse(opts.MapTileURI)
+	append_features := func(r io.Reader) error {
+
+		body, err := io.ReadAll(r)
 
 		if err != nil {
-			log.Fatalf("Failed to parse Protomaps tile URL, %w", err)
+			return fmt.Errorf("Failed to read body, %w", err)
 		}
 
-		switch u.Scheme {
-		case "file":
+		type_rsp := gjson.GetBytes(body, "type")
+
+		switch type_rsp.String() {
+		case "Feature":
 
-			mux_url, mux_handler, err := protomaps.FileHandlerFromPath(u.Path, "")
+			f, err := geojson.UnmarshalFeature(body)
 
 			if err != nil {
-				log.Fatalf("Failed to determine absolute path for '%s', %v", opts.MapTileURI, err)
+				return fmt.Errorf("Failed to unmarshal Feature, %w", err)
 			}
 
-			mux.Handle(mux_url, mux_handler)
-			map_cfg.TileURL = mux_url
-
-		case "api":
-			key := u.Host
-			map_cfg.TileURL = strings.Replace(protomaps_api_tile_url, "{key}", key, 1)
-		}
+			features = append(features, f)
 
-		map_cfg.Protomaps = &protomapsConfig{
-			Theme: opts.ProtomapsTheme,
-		}
-	}
+		case "FeatureCollection":
 
-	map_cfg_handler := mapConfigHandler(map_cfg)
+			other_fc, err := geojson.UnmarshalFeatureCollection(body)
 
-	mux.Handle("/map.json", map_cfg_handler)
+			if err != nil {
+				return fmt.Errorf("Failed to unmarshal record as FeatureCollection, %w", err)
+			}
 
-	www_show_opts := &www_show.RunOptions{
-		Port:    opts.Port,
-		Browser: opts.Browser,
-		Mux:     mux,
-	}
+			for _, f := range other_fc.Features {
+				features = append(features, f)
+			}
 
-	return www_show.RunWithOptions(ctx, www_show_opts)
-}
+		default:
+			return fmt.Errorf("Invalid type, %s", type_rsp.String())
+		}
 
-func dataHandler(fc *geojson.FeatureCollection) http.Handler {
+		return nil
+	}
 
-	fn := func(rsp http.ResponseWriter, req *http.Request) {
+	if len(uris) == 1 && uris[0] == "-" {
 
-		enc_json, err := fc.MarshalJSON()
+		err := append_features(os.Stdin)
 
 		if err != nil {
-			http.Error(rsp, "Internal server error", http.StatusInternalServerError)
-			return
+			return nil, fmt.Errorf("Failed to append features, %v", err)
 		}
 
-		rsp.Header().Set("Content-type", "application/json")
-		rsp.Write(enc_json)
-		return
+		return features, nil
 	}
 
-	return http.HandlerFunc(fn)
-}
-
-func mapConfigHandler(cfg *mapConfig) http.Handler {
+	for _, path := range uris {
 
-	fn := func(rsp http.ResponseWriter, req *http.Request) {
+		r, err := os.Open(path)
 
-		rsp.Header().Set("Content-type", "application/json")
+		if err != nil {
+			return nil, fmt.Errorf("Failed to open %s for reading, %v", path, err)
+		}
 
-		enc := json.NewEncoder(rsp)
-		err := enc.Encode(cfg)
+		err = append_features(r)
+		r.Close()
 
 		if err != nil {
-			slog.Error("Failed to encode map config", "error", err)
-			http.Error(rsp, "Internal server error", http.StatusInternalServerError)
+			return nil, fmt.Errorf("Failed to append features, %v", err)
 		}
-
-		return
 	}
 
-	return http.HandlerFunc(fn)
+	return features, nil
 }
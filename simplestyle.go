@@ -0,0 +1,227 @@
+package show
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+)
+
+// The ways in which simplestyle-spec properties are applied to features, relative to other styles.
+const (
+	// Simplestyle-spec properties take precedence over the default styles and rules-based styles.
+	simplestyle_feature string = "feature"
+	// Simplestyle-spec properties are only used for style options not defined by the default styles or rules-based styles.
+	simplestyle_style string = "style"
+	// Simplestyle-spec properties are ignored.
+	simplestyle_none string = "none"
+)
+
+// The radius, in pixels, of point markers for each simplestyle-spec "marker-size" value.
+var simplestyle_marker_sizes = map[string]float64{
+	"small":  6,
+	"medium": 10,
+	"large":  14,
+}
+
+// simpleStyle returns the `LeafletStyle` derived from the simplestyle-spec properties of 'f' or nil if it has none.
+// The "stroke", "stroke-width", "stroke-opacity", "fill" and "fill-opacity" properties are mapped to Leaflet path
+// options. For point features the "marker-color" and "marker-size" properties are mapped to the colour and radius of
+// the circle marker. Properties with invalid values are ignored. For details see: https://github.com/mapbox/simplestyle-spec
+func simpleStyle(f *geojson.Feature) *LeafletStyle {
+
+	s := &LeafletStyle{}
+	props := f.Properties
+
+	string_value := func(k string) string {
+
+		v, ok := props[k].(string)
+
+		if !ok {
+			return ""
+		}
+
+		return strings.TrimSpace(v)
+	}
+
+	float_value := func(k string, max_v float64) *float64 {
+
+		v, ok := numericValue(props[k])
+
+		if !ok || v < 0 {
+			return nil
+		}
+
+		return Float(min(v, max_v))
+	}
+
+	s.Color = string_value("stroke")
+	s.Weight = float_value("stroke-width", math.Inf(1))
+	s.Opacity = float_value("stroke-opacity", 1)
+	s.FillColor = string_value("fill")
+	s.FillOpacity = float_value("fill-opacity", 1)
+
+	switch f.Geometry.(type) {
+	case orb.Point, orb.MultiPoint:
+
+		marker_color := string_value("marker-color")
+
+		if marker_color != "" {
+
+			// Mapbox tools omit the leading "#" for marker colours
+
+			if !strings.HasPrefix(marker_color, "#") && isHexColor(marker_color) {
+				marker_color = "#" + marker_color
+			}
+
+			s.FillColor = marker_color
+
+			if s.Color == "" {
+				s.Color = marker_color
+			}
+		}
+
+		radius, ok := simplestyle_marker_sizes[string_value("marker-size")]
+
+		if ok {
+			s.Radius = Float(radius)
+		}
+	}
+
+	if *s == (LeafletStyle{}) {
+		return nil
+	}
+
+	return s
+}
+
+// withoutStyle returns a copy of 's' with any options that are set in 'base' removed, or nil if no options remain.
+func withoutStyle(s *LeafletStyle, base *LeafletStyle) *LeafletStyle {
+
+	if s == nil {
+		return nil
+	}
+
+	if base == nil {
+		return s
+	}
+
+	c := *s
+
+	if base.Stroke != nil {
+		c.Stroke = nil
+	}
+
+	if base.Color != "" {
+		c.Color = ""
+	}
+
+	if base.Weight != nil {
+		c.Weight = nil
+	}
+
+	if base.Opacity != nil {
+		c.Opacity = nil
+	}
+
+	if base.LineCap != "" {
+		c.LineCap = ""
+	}
+
+	if base.LineJoin != "" {
+		c.LineJoin = ""
+	}
+
+	if base.DashArray != "" {
+		c.DashArray = ""
+	}
+
+	if base.DashOffset != "" {
+		c.DashOffset = ""
+	}
+
+	if base.Fill != nil {
+		c.Fill = nil
+	}
+
+	if base.FillColor != "" {
+		c.FillColor = ""
+	}
+
+	if base.FillOpacity != nil {
+		c.FillOpacity = nil
+	}
+
+	if base.FillRule != "" {
+		c.FillRule = ""
+	}
+
+	if base.Radius != nil {
+		c.Radius = nil
+	}
+
+	if base.ClassName != "" {
+		c.ClassName = ""
+	}
+
+	if base.Interactive != nil {
+		c.Interactive = nil
+	}
+
+	if base.BubblingMouseEvents != nil {
+		c.BubblingMouseEvents = nil
+	}
+
+	if base.Pane != "" {
+		c.Pane = ""
+	}
+
+	if base.SmoothFactor != nil {
+		c.SmoothFactor = nil
+	}
+
+	if base.NoClip != nil {
+		c.NoClip = nil
+	}
+
+	if base.Icon != "" {
+		c.Icon = ""
+	}
+
+	if c == (LeafletStyle{}) {
+		return nil
+	}
+
+	return &c
+}
+
+// isHexColor returns true if 's' is a three or six character hexadecimal colour without a leading "#".
+func isHexColor(s string) bool {
+
+	if len(s) != 3 && len(s) != 6 {
+		return false
+	}
+
+	for _, r := range strings.ToLower(s) {
+
+		if !strings.ContainsRune("0123456789abcdef", r) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// validateSimpleStyle returns an error if 'mode' is not a valid way of applying simplestyle-spec properties. An
+// empty string is valid.
+func validateSimpleStyle(mode string) error {
+
+	switch mode {
+	case "", simplestyle_feature, simplestyle_style, simplestyle_none:
+		return nil
+	default:
+		return fmt.Errorf("Invalid simplestyle mode, '%s'", mode)
+	}
+}
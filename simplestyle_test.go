@@ -0,0 +1,48 @@
+package show
+
+import (
+	"testing"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+)
+
+func TestSimpleStyle(t *testing.T) {
+
+	tests := []struct {
+		name       string
+		geom       orb.Geometry
+		properties geojson.Properties
+		expected   string
+	}{
+		{"none", orb.Point{0, 0}, geojson.Properties{"name": "SFO"}, "null"},
+		{"fill", orb.Polygon{}, geojson.Properties{"fill": "#ff0000", "fill-opacity": 0.5}, `{"fillColor":"#ff0000","fillOpacity":0.5}`},
+		{"zero fill opacity", orb.Polygon{}, geojson.Properties{"fill-opacity": 0}, `{"fillOpacity":0}`},
+		{"zero stroke width", orb.LineString{}, geojson.Properties{"stroke-width": 0, "stroke-opacity": 0}, `{"weight":0,"opacity":0}`},
+		{"clamped opacity", orb.Polygon{}, geojson.Properties{"fill-opacity": 2}, `{"fillOpacity":1}`},
+		{"negative opacity", orb.Polygon{}, geojson.Properties{"fill-opacity": -1}, "null"},
+		{"NaN opacity", orb.Polygon{}, geojson.Properties{"fill-opacity": "NaN", "stroke-width": "Inf"}, "null"},
+		{"marker", orb.Point{0, 0}, geojson.Properties{"marker-color": "ff0000", "marker-size": "small"}, `{"color":"#ff0000","fillColor":"#ff0000","radius":6}`},
+	}
+
+	for _, test := range tests {
+
+		t.Run(test.name, func(t *testing.T) {
+
+			f := geojson.NewFeature(test.geom)
+			f.Properties = test.properties
+
+			s := simpleStyle(f)
+
+			str_style := "null"
+
+			if s != nil {
+				str_style = s.key()
+			}
+
+			if str_style != test.expected {
+				t.Fatalf("Expected %s, got %s", test.expected, str_style)
+			}
+		})
+	}
+}
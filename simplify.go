@@ -0,0 +1,538 @@
+package show
+
+import (
+	"cmp"
+	"math"
+	"slices"
+	"sync"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+	"github.com/paulmach/orb/planar"
+	"github.com/paulmach/orb/simplify"
+)
+
+// The minimum zoom level of each band for which simplified geometries are precomputed. A band is used for all the zoom
+// levels between its minimum zoom level and the minimum zoom level of the next band.
+var simplify_zooms = []int{0, 4, 8, 12}
+
+// The zoom level at, and above, which original (unsimplified) geometries are served.
+const simplify_max_zoom int = 16
+
+// The Douglas-Peucker threshold, in pixels (at the minimum zoom level of a band), used to simplify geometries.
+const simplify_threshold float64 = 0.5
+
+// The number of (progressively halved) thresholds to try when simplifying a ring or polygon produces an invalid
+// geometry before falling back to the original geometry.
+const simplify_attempts int = 4
+
+// simplifyConfig defines configuration details for maps requesting simplified geometries.
+type simplifyConfig struct {
+	// The minimum zoom level of each band for which simplified geometries are available.
+	Zooms []int `json:"zooms"`
+	// The zoom level at, and above, which original (unsimplified) geometries are served.
+	MaxZoom int `json:"max_zoom"`
+}
+
+// simplifiedGeometries contains precomputed, simplified, geometries for each of the zoom bands in simplify_zooms
+// for the features in a featureCollection.
+type simplifiedGeometries struct {
+	mu *sync.RWMutex
+	// bands contains the list of simplified geometries, indexed by feature offset, for each zoom band. A nil
+	// geometry means that the original geometry should be used.
+	bands [][]orb.Geometry
+}
+
+// newSimplifiedGeometries returns a new `simplifiedGeometries` instance for the features in 'features'.
+func newSimplifiedGeometries(features *featureCollection) *simplifiedGeometries {
+
+	s := &simplifiedGeometries{
+		mu:    new(sync.RWMutex),
+		bands: make([][]orb.Geometry, len(simplify_zooms)),
+	}
+
+	s.Update(features)
+	return s
+}
+
+// Update computes simplified geometries for any features in 'features' which have been added since the last
+// time it was called. Since features are only ever appended to a featureCollection existing geometries are not
+// recomputed.
+func (s *simplifiedGeometries) Update(features *featureCollection) {
+
+	fc, _ := features.snapshot()
+
+	s.mu.RLock()
+	count := len(s.bands[0])
+	s.mu.RUnlock()
+
+	if count >= len(fc) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Another caller, with a more recent snapshot, may have updated the bands since the read lock
+	// was released so only append geometries for offsets that are still missing.
+
+	for i, z := range simplify_zooms {
+
+		s_fn := simplify.DouglasPeucker(simplifyThreshold(z))
+
+		for j := len(s.bands[i]); j < len(fc); j++ {
+			s.bands[i] = append(s.bands[i], simplifyGeometry(s_fn, fc[j].Geometry))
+		}
+	}
+}
+
+// Geometry returns the simplified geometry for the feature at 'offset' for zoom level 'z'. It returns false
+// if there is no simplified geometry in which case the original geometry should be used.
+func (s *simplifiedGeometries) Geometry(offset int, z int) (orb.Geometry, bool) {
+
+	band := simplifyBand(z)
+
+	if band == -1 {
+		return nil, false
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if offset >= len(s.bands[band]) {
+		return nil, false
+	}
+
+	g := s.bands[band][offset]
+	return g, g != nil
+}
+
+// Features returns a copy of 'features', whose offsets in a featureCollection are 'offsets', with geometries
+// replaced by their simplified geometries for zoom level 'z'. Features without a simplified geometry are returned as-is.
+func (s *simplifiedGeometries) Features(features []*geojson.Feature, offsets []int, z int) []*geojson.Feature {
+
+	results := make([]*geojson.Feature, len(features))
+
+	for i, f := range features {
+
+		g, ok := s.Geometry(offsets[i], z)
+
+		if !ok {
+			results[i] = f
+			continue
+		}
+
+		simple_f := geojson.NewFeature(g)
+		simple_f.ID = f.ID
+		simple_f.Properties = f.Properties
+
+		results[i] = simple_f
+	}
+
+	return results
+}
+
+// simplifyBand returns the offset in simplify_zooms of the band for zoom level 'z' or -1 if original geometries
+// should be used.
+func simplifyBand(z int) int {
+
+	if z >= simplify_max_zoom {
+		return -1
+	}
+
+	band := 0
+
+	for i, min_z := range simplify_zooms {
+
+		if z >= min_z {
+			band = i
+		}
+	}
+
+	return band
+}
+
+// simplifyThreshold returns the simplification threshold, in decimal degrees, for zoom level 'z'.
+func simplifyThreshold(z int) float64 {
+	return simplify_threshold * 360.0 / (256.0 * math.Pow(2, float64(z)))
+}
+
+// simplifyGeometry returns a simplified copy of 'g' or nil if simplifying 'g' does not remove any vertices. Unlike
+// the `Simplify` method in orb's simplify package rings, polygons and lines are never removed and lines which would be
+// collapsed to fewer than two vertices are left unsimplified. Simplified rings must have at least four vertices and
+// must not self-intersect, and the holes of simplified polygons must not cross each other or their shell and must
+// stay inside it. If a simplified ring or polygon is invalid it is simplified again using progressively lower
+// thresholds and, failing that, left unsimplified. Separate polygons (including the polygons in a MultiPolygon) are
+// still simplified independently so neighbouring polygons may overlap or leave small gaps.
+func simplifyGeometry(s_fn *simplify.DouglasPeuckerSimplifier, g orb.Geometry) orb.Geometry {
+
+	var simple_g orb.Geometry
+
+	switch geom := g.(type) {
+	case orb.LineString:
+		simple_g = simplifyLineString(s_fn, geom)
+	case orb.MultiLineString:
+
+		mls := make(orb.MultiLineString, len(geom))
+
+		for i, ls := range geom {
+			mls[i] = simplifyLineString(s_fn, ls)
+		}
+
+		simple_g = mls
+
+	case orb.Ring:
+		simple_g = simplifyRing(s_fn, geom)
+	case orb.Polygon:
+		simple_g = simplifyPolygon(s_fn, geom)
+	case orb.MultiPolygon:
+
+		mp := make(orb.MultiPolygon, len(geom))
+
+		for i, p := range geom {
+			mp[i] = simplifyPolygon(s_fn, p)
+		}
+
+		simple_g = mp
+
+	case orb.Collection:
+
+		c := make(orb.Collection, len(geom))
+
+		for i, cg := range geom {
+
+			c[i] = simplifyGeometry(s_fn, cg)
+
+			if c[i] == nil {
+				c[i] = cg
+			}
+		}
+
+		simple_g = c
+
+	default:
+		return nil
+	}
+
+	if countVertices(simple_g) == countVertices(g) {
+		return nil
+	}
+
+	return simple_g
+}
+
+func simplifyLineString(s_fn *simplify.DouglasPeuckerSimplifier, ls orb.LineString) orb.LineString {
+
+	simple_ls := s_fn.LineString(orb.Clone(ls).(orb.LineString))
+
+	if len(simple_ls) < 2 {
+		return ls
+	}
+
+	return simple_ls
+}
+
+// simplifyRing returns 'r' simplified using 's_fn', or a lower threshold if the simplified ring is invalid, or 'r'
+// itself if no valid simplified ring can be produced.
+func simplifyRing(s_fn *simplify.DouglasPeuckerSimplifier, r orb.Ring) orb.Ring {
+
+	for _, fn := range simplifiers(s_fn) {
+
+		simple_r := fn.Ring(orb.Clone(r).(orb.Ring))
+
+		if validRing(simple_r) {
+			return simple_r
+		}
+	}
+
+	return r
+}
+
+// simplifyPolygon returns 'p' simplified using 's_fn', or a lower threshold if the simplified polygon is invalid, or 'p'
+// itself if no valid simplified polygon can be produced.
+func simplifyPolygon(s_fn *simplify.DouglasPeuckerSimplifier, p orb.Polygon) orb.Polygon {
+
+	for _, fn := range simplifiers(s_fn) {
+
+		simple_p := make(orb.Polygon, len(p))
+
+		for i, r := range p {
+			simple_p[i] = simplifyRing(fn, r)
+		}
+
+		if validPolygon(simple_p) {
+			return simple_p
+		}
+	}
+
+	return p
+}
+
+// simplifiers returns the list of simplifiers, starting with 's_fn' and halving its threshold each time, to try when
+// simplifying rings and polygons.
+func simplifiers(s_fn *simplify.DouglasPeuckerSimplifier) []*simplify.DouglasPeuckerSimplifier {
+
+	fns := make([]*simplify.DouglasPeuckerSimplifier, simplify_attempts)
+	fns[0] = s_fn
+
+	for i := 1; i < simplify_attempts; i++ {
+		fns[i] = simplify.DouglasPeucker(fns[i-1].Threshold / 2)
+	}
+
+	return fns
+}
+
+// validRing returns true if 'r' is a closed ring with at least four vertices, a non-zero area and no self-intersections.
+func validRing(r orb.Ring) bool {
+
+	if len(r) < 4 || !r.Closed() {
+		return false
+	}
+
+	if planar.Area(r) == 0 {
+		return false
+	}
+
+	return !ringsIntersect(r)
+}
+
+// validPolygon returns true if none of the rings in 'p' intersect (themselves or each other) and all of its holes are
+// inside its shell. It does not check whether holes are nested inside other holes.
+func validPolygon(p orb.Polygon) bool {
+
+	if len(p) == 0 {
+		return true
+	}
+
+	if ringsIntersect(p...) {
+		return false
+	}
+
+	// Since the rings don't intersect a hole is either entirely inside or entirely outside the shell.
+
+	for _, hole := range p[1:] {
+
+		if len(hole) == 0 || !planar.RingContains(p[0], hole[0]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ringSegment is an individual segment of a ring.
+type ringSegment struct {
+	// ring is the offset of the ring (in the list of rings being checked) the segment belongs to.
+	ring int
+	// offset is the offset of the segment in its ring.
+	offset int
+	a      orb.Point
+	b      orb.Point
+	bound  orb.Bound
+}
+
+// ringsIntersect returns true if any of the segments in 'rings' intersect, other than consecutive segments in the same ring
+// which only share a vertex. Segments are compared using a sweep from west to east so only segments whose bounding boxes
+// overlap are tested.
+func ringsIntersect(rings ...orb.Ring) bool {
+
+	segments := make([]ringSegment, 0)
+
+	for i, r := range rings {
+
+		for j := 0; j < len(r)-1; j++ {
+
+			seg := ringSegment{
+				ring:   i,
+				offset: j,
+				a:      r[j],
+				b:      r[j+1],
+				bound:  orb.Bound{Min: r[j], Max: r[j]}.Extend(r[j+1]),
+			}
+
+			segments = append(segments, seg)
+		}
+	}
+
+	slices.SortFunc(segments, func(a ringSegment, b ringSegment) int {
+		return cmp.Compare(a.bound.Min.X(), b.bound.Min.X())
+	})
+
+	active := make([]ringSegment, 0)
+
+	for _, seg := range segments {
+
+		// Remove the segments which end before the current segment starts
+
+		count := 0
+
+		for _, other := range active {
+
+			if other.bound.Max.X() >= seg.bound.Min.X() {
+				active[count] = other
+				count += 1
+			}
+		}
+
+		active = active[:count]
+
+		for _, other := range active {
+
+			if other.bound.Min.Y() > seg.bound.Max.Y() || other.bound.Max.Y() < seg.bound.Min.Y() {
+				continue
+			}
+
+			if other.ring == seg.ring && adjacentSegments(other.offset, seg.offset, len(rings[seg.ring])-1) {
+
+				if segmentsOverlap(other, seg) {
+					return true
+				}
+
+				continue
+			}
+
+			if segmentsIntersect(other.a, other.b, seg.a, seg.b) {
+				return true
+			}
+		}
+
+		active = append(active, seg)
+	}
+
+	return false
+}
+
+// adjacentSegments returns true if the segments at offsets 'i' and 'j', in a ring with 'count' segments, are consecutive.
+func adjacentSegments(i int, j int, count int) bool {
+
+	if i > j {
+		i, j = j, i
+	}
+
+	return j-i == 1 || (i == 0 && j == count-1)
+}
+
+// segmentsOverlap returns true if the consecutive segments 'a' and 'b' double back on each other, overlapping along
+// more than their shared vertex.
+func segmentsOverlap(a ringSegment, b ringSegment) bool {
+
+	var shared, p, q orb.Point
+
+	switch {
+	case a.b.Equal(b.a):
+		shared, p, q = a.b, a.a, b.b
+	case a.a.Equal(b.b):
+		shared, p, q = a.a, a.b, b.a
+	default:
+		return segmentsIntersect(a.a, a.b, b.a, b.b)
+	}
+
+	if orientation(p, shared, q) != 0 {
+		return false
+	}
+
+	dot := (p.X()-shared.X())*(q.X()-shared.X()) + (p.Y()-shared.Y())*(q.Y()-shared.Y())
+	return dot > 0
+}
+
+// segmentsIntersect returns true if the segments 'p1'-'p2' and 'p3'-'p4' intersect or touch.
+func segmentsIntersect(p1 orb.Point, p2 orb.Point, p3 orb.Point, p4 orb.Point) bool {
+
+	o1 := orientation(p1, p2, p3)
+	o2 := orientation(p1, p2, p4)
+	o3 := orientation(p3, p4, p1)
+	o4 := orientation(p3, p4, p2)
+
+	if o1 != o2 && o3 != o4 {
+		return true
+	}
+
+	switch {
+	case o1 == 0 && onSegment(p1, p2, p3):
+		return true
+	case o2 == 0 && onSegment(p1, p2, p4):
+		return true
+	case o3 == 0 && onSegment(p3, p4, p1):
+		return true
+	case o4 == 0 && onSegment(p3, p4, p2):
+		return true
+	default:
+		return false
+	}
+}
+
+// orientation returns 1 if 'a', 'b' and 'c' turn counter-clockwise, -1 if they turn clockwise and 0 if they are collinear.
+func orientation(a orb.Point, b orb.Point, c orb.Point) int {
+
+	v := (b.X()-a.X())*(c.Y()-a.Y()) - (b.Y()-a.Y())*(c.X()-a.X())
+
+	switch {
+	case v > 0:
+		return 1
+	case v < 0:
+		return -1
+	default:
+		return 0
+	}
+}
+
+// onSegment returns true if 'p', which is collinear with 'a' and 'b', lies within the bounding box of segment 'a'-'b'.
+func onSegment(a orb.Point, b orb.Point, p orb.Point) bool {
+	return p.X() >= min(a.X(), b.X()) && p.X() <= max(a.X(), b.X()) && p.Y() >= min(a.Y(), b.Y()) && p.Y() <= max(a.Y(), b.Y())
+}
+
+// countVertices returns the total number of vertices in 'g'.
+func countVertices(g orb.Geometry) int {
+
+	switch geom := g.(type) {
+	case orb.Point:
+		return 1
+	case orb.MultiPoint:
+		return len(geom)
+	case orb.LineString:
+		return len(geom)
+	case orb.Ring:
+		return len(geom)
+	case orb.MultiLineString:
+
+		count := 0
+
+		for _, ls := range geom {
+			count += len(ls)
+		}
+
+		return count
+
+	case orb.Polygon:
+
+		count := 0
+
+		for _, r := range geom {
+			count += len(r)
+		}
+
+		return count
+
+	case orb.MultiPolygon:
+
+		count := 0
+
+		for _, p := range geom {
+			count += countVertices(p)
+		}
+
+		return count
+
+	case orb.Collection:
+
+		count := 0
+
+		for _, cg := range geom {
+			count += countVertices(cg)
+		}
+
+		return count
+	}
+
+	return 0
+}
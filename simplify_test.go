@@ -0,0 +1,252 @@
+package show
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+	"github.com/paulmach/orb/simplify"
+)
+
+func TestSimplifyGeometry(t *testing.T) {
+
+	s_fn := simplify.DouglasPeucker(1.0)
+
+	tests := []struct {
+		name     string
+		geom     orb.Geometry
+		vertices int
+	}{
+		{"line", orb.LineString{{0, 0}, {1, 0.1}, {2, 0}, {3, 0.1}, {4, 0}}, 2},
+		{"unchanged line", orb.LineString{{0, 0}, {4, 0}}, -1},
+		{"collapsed ring", orb.Ring{{0, 0}, {0.1, 0}, {0.1, 0.1}, {0, 0.1}, {0, 0}}, -1},
+		{"point", orb.Point{1, 1}, -1},
+	}
+
+	for _, test := range tests {
+
+		t.Run(test.name, func(t *testing.T) {
+
+			g := simplifyGeometry(s_fn, test.geom)
+
+			if test.vertices == -1 {
+
+				if g != nil {
+					t.Fatalf("Expected geometry to be left unsimplified, got %v", g)
+				}
+
+				return
+			}
+
+			if g == nil {
+				t.Fatalf("Expected simplified geometry")
+			}
+
+			if countVertices(g) != test.vertices {
+				t.Fatalf("Expected %d vertices, got %d", test.vertices, countVertices(g))
+			}
+		})
+	}
+}
+
+func TestSimplifyGeometryTopology(t *testing.T) {
+
+	// Simplifying this ring (with a threshold of 3) removes its last vertex so that the closing segment crosses the second
+	crossing_ring := orb.Ring{{3, 0}, {4, 6}, {8, 3}, {7, 9}, {4, 9}, {3, 0}}
+
+	// Simplifying this polygon's shell (with a threshold of 1) removes the bump containing the hole so the hole is left outside the shell
+	escaping_hole := orb.Polygon{
+		{{0, 0}, {10, 0}, {10, 10}, {5, 10.4}, {0, 10}, {0, 0}},
+		{{4.5, 10.1}, {5, 10.3}, {5.5, 10.1}, {4.5, 10.1}},
+	}
+
+	tests := []struct {
+		name      string
+		geom      orb.Geometry
+		threshold float64
+	}{
+		{"self-intersecting ring", crossing_ring, 3},
+		{"self-intersecting polygon", orb.Polygon{crossing_ring}, 3},
+		{"hole outside shell", escaping_hole, 1},
+		{"multipolygon", orb.MultiPolygon{escaping_hole, orb.Polygon{crossing_ring}}, 1},
+	}
+
+	for _, test := range tests {
+
+		t.Run(test.name, func(t *testing.T) {
+
+			s_fn := simplify.DouglasPeucker(test.threshold)
+
+			// Ensure that simplifying each ring independently does produce an invalid geometry
+
+			if validGeometry(simplifyRings(s_fn, test.geom)) {
+				t.Fatalf("Expected simplifying rings independently to produce an invalid geometry")
+			}
+
+			g := simplifyGeometry(s_fn, test.geom)
+
+			if g == nil {
+				g = test.geom
+			}
+
+			if !validGeometry(g) {
+				t.Fatalf("Expected simplified geometry to be valid, got %v", g)
+			}
+		})
+	}
+}
+
+func TestValidPolygon(t *testing.T) {
+
+	shell := orb.Ring{{0, 0}, {10, 0}, {10, 10}, {0, 10}, {0, 0}}
+
+	tests := []struct {
+		name     string
+		polygon  orb.Polygon
+		expected bool
+	}{
+		{"shell", orb.Polygon{shell}, true},
+		{"hole", orb.Polygon{shell, {{2, 2}, {4, 2}, {4, 4}, {2, 2}}}, true},
+		{"hole outside", orb.Polygon{shell, {{12, 2}, {14, 2}, {14, 4}, {12, 2}}}, false},
+		{"hole crossing shell", orb.Polygon{shell, {{8, 2}, {12, 2}, {12, 4}, {8, 2}}}, false},
+		{"holes crossing", orb.Polygon{shell, {{2, 2}, {6, 2}, {6, 6}, {2, 2}}, {{5, 1}, {7, 1}, {5, 3}, {5, 1}}}, false},
+		{"bow tie", orb.Polygon{{{0, 0}, {10, 10}, {10, 0}, {0, 10}, {0, 0}}}, false},
+		{"spike", orb.Polygon{{{0, 0}, {10, 0}, {10, 10}, {10, 5}, {0, 10}, {0, 0}}}, false},
+		{"touching", orb.Polygon{{{0, 0}, {10, 0}, {5, 5}, {10, 10}, {0, 10}, {5, 5}, {0, 0}}}, false},
+	}
+
+	for _, test := range tests {
+
+		t.Run(test.name, func(t *testing.T) {
+
+			ok := validPolygon(test.polygon)
+
+			if ok != test.expected {
+				t.Fatalf("Expected valid to be %t", test.expected)
+			}
+		})
+	}
+}
+
+// simplifyRings returns a copy of 'g' with each of its rings simplified independently, without any validation other than
+// leaving rings which would collapse to fewer than four vertices unsimplified.
+func simplifyRings(s_fn *simplify.DouglasPeuckerSimplifier, g orb.Geometry) orb.Geometry {
+
+	simplify_ring := func(r orb.Ring) orb.Ring {
+
+		simple_r := s_fn.Ring(orb.Clone(r).(orb.Ring))
+
+		if len(simple_r) < 4 {
+			return r
+		}
+
+		return simple_r
+	}
+
+	switch geom := g.(type) {
+	case orb.Ring:
+		return simplify_ring(geom)
+	case orb.Polygon:
+
+		p := make(orb.Polygon, len(geom))
+
+		for i, r := range geom {
+			p[i] = simplify_ring(r)
+		}
+
+		return p
+
+	case orb.MultiPolygon:
+
+		mp := make(orb.MultiPolygon, len(geom))
+
+		for i, p := range geom {
+			mp[i] = simplifyRings(s_fn, p).(orb.Polygon)
+		}
+
+		return mp
+	}
+
+	return g
+}
+
+// validGeometry returns true if all the rings and polygons in 'g' are valid.
+func validGeometry(g orb.Geometry) bool {
+
+	switch geom := g.(type) {
+	case orb.Ring:
+		return validRing(geom)
+	case orb.Polygon:
+
+		for _, r := range geom {
+
+			if !validRing(r) {
+				return false
+			}
+		}
+
+		return validPolygon(geom)
+
+	case orb.MultiPolygon:
+
+		for _, p := range geom {
+
+			if !validGeometry(p) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+func TestSimplifiedGeometriesConcurrentUpdate(t *testing.T) {
+
+	ctx := context.Background()
+
+	features, err := newFeatureCollection(ctx, nil, nil, "")
+
+	if err != nil {
+		t.Fatalf("Failed to create feature collection, %v", err)
+	}
+
+	s := newSimplifiedGeometries(features)
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 8; i++ {
+
+		wg.Add(1)
+
+		go func(i int) {
+
+			defer wg.Done()
+
+			for j := 0; j < 50; j++ {
+
+				f := geojson.NewFeature(orb.LineString{{0, 0}, {float64(i), 0.001}, {float64(j), 0}})
+				err := features.Add(ctx, f)
+
+				if err != nil {
+					t.Errorf("Failed to add feature, %v", err)
+					return
+				}
+
+				s.Update(features)
+			}
+		}(i)
+	}
+
+	wg.Wait()
+
+	s.Update(features)
+
+	for i := range simplify_zooms {
+
+		if len(s.bands[i]) != features.Count() {
+			t.Fatalf("Expected %d geometries in band %d, got %d", features.Count(), i, len(s.bands[i]))
+		}
+	}
+}
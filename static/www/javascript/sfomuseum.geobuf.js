@@ -0,0 +1,396 @@
+var sfomuseum = sfomuseum || {};
+
+// Decoder for Geobuf messages produced by the show server. Geobuf is a compact binary
+// encoding of GeoJSON data using Protocol Buffers. For details see: https://github.com/mapbox/geobuf
+
+sfomuseum.geobuf = (function(){
+
+    var geometry_types = [
+	'Point',
+	'MultiPoint',
+	'LineString',
+	'Polygon',
+	'MultiLineString',
+	'MultiPolygon',
+	'GeometryCollection',
+    ];
+
+    // A minimal Protocol Buffers reader.
+
+    var reader = function(buf){
+
+	var bytes = new Uint8Array(buf);
+	var view = new DataView(bytes.buffer, bytes.byteOffset, bytes.byteLength);
+	var text = new TextDecoder("utf-8");
+
+	var r = {
+
+	    pos: 0,
+	    length: bytes.length,
+
+	    varint: function(){
+
+		// Use arithmetic rather than bitwise operators which are limited to 32 bits.
+
+		var v = 0;
+		var mul = 1;
+		var b;
+
+		do {
+		    b = bytes[r.pos++];
+		    v += (b & 0x7f) * mul;
+		    mul *= 128;
+		} while (b & 0x80);
+
+		return v;
+	    },
+
+	    svarint: function(){
+		var v = r.varint();
+		return (v % 2 == 1) ? (v + 1) / -2 : v / 2;
+	    },
+
+	    double: function(){
+		var v = view.getFloat64(r.pos, true);
+		r.pos += 8;
+		return v;
+	    },
+
+	    string: function(){
+		var end = r.varint() + r.pos;
+		var v = text.decode(bytes.subarray(r.pos, end));
+		r.pos = end;
+		return v;
+	    },
+
+	    packed: function(read){
+
+		var end = r.varint() + r.pos;
+		var values = [];
+
+		while (r.pos < end){
+		    values.push(read());
+		}
+
+		return values;
+	    },
+
+	    // Read the fields of a message ending at 'end', calling 'fn' with the field number of each field.
+
+	    fields: function(end, fn){
+
+		while (r.pos < end){
+
+		    var tag = r.varint();
+		    var field = Math.floor(tag / 8);
+		    var wire_type = tag & 0x7;
+
+		    var pos = r.pos;
+		    fn(field);
+
+		    if (r.pos == pos){
+			r.skip(wire_type);
+		    }
+		}
+	    },
+
+	    // Read an embedded message, calling 'fn' with the field number of each field.
+
+	    message: function(fn){
+		var end = r.varint() + r.pos;
+		r.fields(end, fn);
+	    },
+
+	    skip: function(wire_type){
+
+		switch (wire_type){
+		    case 0:
+			r.varint();
+			break;
+		    case 1:
+			r.pos += 8;
+			break;
+		    case 2:
+			r.pos += r.varint();
+			break;
+		    case 5:
+			r.pos += 4;
+			break;
+		    default:
+			throw new Error("Unsupported wire type " + wire_type);
+		}
+	    },
+	};
+
+	return r;
+    };
+
+    var self = {
+
+	// Decode the Geobuf message in 'buf' (an ArrayBuffer) and return a GeoJSON object.
+
+	decode: function(buf){
+
+	    var r = reader(buf);
+
+	    var keys = [];
+	    var dim = 2;
+	    var e = Math.pow(10, 6);
+
+	    var obj;
+
+	    var read_value = function(){
+
+		var v;
+
+		r.message(function(field){
+
+		    switch (field){
+			case 1:
+			    v = r.string();
+			    break;
+			case 2:
+			    v = r.double();
+			    break;
+			case 3:
+			    v = r.varint();
+			    break;
+			case 4:
+			    v = -r.varint();
+			    break;
+			case 5:
+			    v = Boolean(r.varint());
+			    break;
+			case 6:
+			    v = JSON.parse(r.string());
+			    break;
+		    }
+		});
+
+		return v;
+	    };
+
+	    var assign_properties = function(target, values, indices){
+
+		for (var i=0; i < indices.length; i += 2){
+		    target[ keys[ indices[i] ] ] = values[ indices[i+1] ];
+		}
+	    };
+
+	    var read_line = function(coords, start, count, closed){
+
+		var line = [];
+		var prev = new Array(dim).fill(0);
+
+		for (var i=0; i < count; i++){
+
+		    var pt = [];
+
+		    for (var j=0; j < dim; j++){
+			prev[j] += coords[start + (i * dim) + j];
+			pt.push(prev[j] / e);
+		    }
+
+		    line.push(pt);
+		}
+
+		if (closed && line.length){
+		    line.push(line[0].slice());
+		}
+
+		return line;
+	    };
+
+	    var read_multi_line = function(coords, lengths, closed){
+
+		if (! lengths){
+		    return [ read_line(coords, 0, coords.length / dim, closed) ];
+		}
+
+		var lines = [];
+		var start = 0;
+
+		for (const count of lengths){
+		    lines.push(read_line(coords, start, count, closed));
+		    start += count * dim;
+		}
+
+		return lines;
+	    };
+
+	    var read_multi_polygon = function(coords, lengths){
+
+		if (! lengths){
+		    return [ [ read_line(coords, 0, coords.length / dim, true) ] ];
+		}
+
+		var polygons = [];
+		var start = 0;
+		var j = 1;
+
+		for (var i=0; i < lengths[0]; i++){
+
+		    var rings = [];
+		    var count_rings = lengths[j++];
+
+		    for (var k=0; k < count_rings; k++){
+			var count = lengths[j++];
+			rings.push(read_line(coords, start, count, true));
+			start += count * dim;
+		    }
+
+		    polygons.push(rings);
+		}
+
+		return polygons;
+	    };
+
+	    var read_geometry = function(){
+
+		var type = 0;
+		var lengths;
+		var coords = [];
+		var geometries = [];
+
+		r.message(function(field){
+
+		    switch (field){
+			case 1:
+			    type = r.varint();
+			    break;
+			case 2:
+			    lengths = r.packed(r.varint);
+			    break;
+			case 3:
+			    coords = r.packed(r.svarint);
+			    break;
+			case 4:
+			    geometries.push(read_geometry());
+			    break;
+		    }
+		});
+
+		var geom = {
+		    type: geometry_types[type],
+		};
+
+		switch (geom.type){
+		    case 'Point':
+			geom.coordinates = coords.slice(0, dim).map((c) => c / e);
+			break;
+		    case 'MultiPoint':
+		    case 'LineString':
+			geom.coordinates = read_line(coords, 0, coords.length / dim, false);
+			break;
+		    case 'MultiLineString':
+			geom.coordinates = read_multi_line(coords, lengths, false);
+			break;
+		    case 'Polygon':
+			geom.coordinates = read_multi_line(coords, lengths, true);
+			break;
+		    case 'MultiPolygon':
+			geom.coordinates = read_multi_polygon(coords, lengths);
+			break;
+		    case 'GeometryCollection':
+			geom.geometries = geometries;
+			break;
+		}
+
+		return geom;
+	    };
+
+	    var read_feature = function(){
+
+		var f = {
+		    type: 'Feature',
+		    geometry: null,
+		    properties: {},
+		};
+
+		var values = [];
+
+		r.message(function(field){
+
+		    switch (field){
+			case 1:
+			    f.geometry = read_geometry();
+			    break;
+			case 11:
+			    f.id = r.string();
+			    break;
+			case 12:
+			    f.id = r.svarint();
+			    break;
+			case 13:
+			    values.push(read_value());
+			    break;
+			case 14:
+			    assign_properties(f.properties, values, r.packed(r.varint));
+			    break;
+			case 15:
+			    assign_properties(f, values, r.packed(r.varint));
+			    break;
+		    }
+		});
+
+		return f;
+	    };
+
+	    var read_feature_collection = function(){
+
+		var fc = {
+		    type: 'FeatureCollection',
+		    features: [],
+		};
+
+		var values = [];
+
+		r.message(function(field){
+
+		    switch (field){
+			case 1:
+			    fc.features.push(read_feature());
+			    break;
+			case 13:
+			    values.push(read_value());
+			    break;
+			case 15:
+			    assign_properties(fc, values, r.packed(r.varint));
+			    break;
+		    }
+		});
+
+		return fc;
+	    };
+
+	    r.fields(r.length, function(field){
+
+		switch (field){
+		    case 1:
+			keys.push(r.string());
+			break;
+		    case 2:
+			dim = r.varint();
+			break;
+		    case 3:
+			e = Math.pow(10, r.varint());
+			break;
+		    case 4:
+			obj = read_feature_collection();
+			break;
+		    case 5:
+			obj = read_feature();
+			break;
+		    case 6:
+			obj = read_geometry();
+			break;
+		}
+	    });
+
+	    return obj;
+	},
+    };
+
+    return self;
+
+})();
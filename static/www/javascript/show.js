@@ -3,17 +3,43 @@ window.addEventListener("load", function load(event){
     // Null Island
     var map = L.map('map').setView([0.0, 0.0], 12);
 
+    // All the layers used to render features are added to this group so that they can be
+    // hidden, as a whole, when aggregated cells are being shown.
+    
+    var features_group = L.layerGroup();
+    features_group.addTo(map);
+
+    // The map configuration, assigned when the map is initialized.
+    var map_cfg = {};
+    
+    // The Leaflet layer for each feature rendered as a vector layer indexed by "show:id", the layer
+    // (or overlay) used to highlight the selected feature and the "show:id" of the selected feature.
+    
+    var feature_layers = {};
+    var selected_layer;
+    var selected_overlay;
+    var selected_id;
+    
+    // The DOM id of the element containing the raw record for the feature identified by 'show_id'. Feature
+    // identifiers are prefixed so they can't collide with other elements in the page (for example "map").
+    
+    var raw_element_id = function(show_id){
+	return "show-feature-" + show_id;
+    };
+    
     var select = function(show_id){
 
 	unselect();
 	
-	var el = document.getElementById(show_id);
+	var el = document.getElementById(raw_element_id(show_id));
 	
 	if (el){
 	    el.setAttribute("class", "selected");
 	    el.scrollIntoView();
 	}
-	
+
+	selected_id = show_id;
+	highlight_layer(show_id);
     };
     
     var unselect = function(){
@@ -23,143 +49,1451 @@ window.addEventListener("load", function load(event){
 	if (current){
 	    current.classList.remove("selected");
 	}
+
+	if (selected_layer){
+
+	    var el = (selected_layer.getElement) ? selected_layer.getElement() : null;
+
+	    if (el){
+		el.classList.remove("show-selected-marker");
+	    }
+	    
+	    if (selected_layer.setStyle && geojson_layer && geojson_layer.hasLayer(selected_layer)){
+		geojson_layer.resetStyle(selected_layer);
+	    }
+	    
+	    selected_layer = null;
+	}
+
+	if (selected_overlay){
+	    features_group.removeLayer(selected_overlay);
+	    selected_overlay = null;
+	}
+
+	selected_id = null;
+    };
+
+    // Apply the selected style to the vector layer for the feature identified by 'show_id', if present.
+    // Default (icon) markers can not be styled.
+    
+    var highlight_layer = function(show_id){
+
+	var layer = feature_layers[show_id];
+
+	if (! layer){
+	    return;
+	}
+
+	// Markers (for example icons) can not be styled so they are highlighted using a CSS class instead
+	
+	if (! layer.setStyle){
+
+	    var el = (layer.getElement) ? layer.getElement() : null;
+
+	    if (el){
+		el.classList.add("show-selected-marker");
+		selected_layer = layer;
+	    }
+	    
+	    return;
+	}
+	
+	layer.setStyle(map_cfg.selected_style || {});
+	layer.bringToFront();
+
+	selected_layer = layer;
     };
 
+    // Draw the feature (or FeatureCollection) 'f' on top of the map using the selected style. This is used
+    // to highlight features rendered as vector or raster tiles.
+    
+    var highlight_feature = function(f){
+
+	if (selected_overlay){
+	    features_group.removeLayer(selected_overlay);
+	}
+	
+	selected_overlay = L.geoJSON(f, {
+	    interactive: false,
+	    style: function(feature){
+		return ensure_pane(Object.assign(geometry_style(map_cfg, feature), map_cfg.selected_style || {}));
+	    },
+	    pointToLayer: function(feature, latlng){
+		return L.circleMarker(latlng);
+	    },
+	});
+
+	selected_overlay.addTo(features_group);
+    };
+    
     map.on("click", function(e){
 	unselect();
     });
+
+    // Fetch the (server-rendered HTML) label for the feature identified by 'show_id' and display it in a popup. If 'layer'
+    // is defined the popup is bound to it (so subsequent clicks are handled by Leaflet), otherwise it is opened at 'latlng'.
     
-    var init = function(cfg) {
+    var show_label = function(cfg, show_id, latlng, layer){
+
+	if (! cfg.labels){
+	    return;
+	}
 	
-	fetch("/features.geojson")
-	    .then((rsp) => rsp.json())
-	    .then((f) => {
+	fetch(cfg.labels.url + encodeURIComponent(show_id) + ".html")
+	    .then((rsp) => {
 
-		var features = f.features;
-		var count = features.length;
-		
-		for (var i=0; i < count; i++){
-		    var show_id = "show-" + (i+1);
-		    f.features[i]["properties"]["show:id"] = show_id;
+		if (! rsp.ok){
+		    throw new Error(rsp.status + " " + rsp.statusText);
 		}
+
+		return rsp.text();
+	    })
+	    .then((html) => {
+
+		// Features without a label return an empty response
 		
-		var raw_el = document.querySelector("#raw");
+		if (! html){
+		    return;
+		}
+
+		var popup = L.popup({ className: "show-popup" }).setContent(html);
 		
-		var format = function(show_id, str){
-		    
-		    // Remember: wof_format is defined by the /wasm/wof_format.wasm binary.
-			// Details below.
-			
-			wof_format(str).then((rsp) => {
-			    append(show_id, rsp);
-			}).catch((err) => {
-			    console.warn("Unable to format feature", err, str);
-			    append(show_id, str);
-			});
-		};
+		if (layer){
+		    layer.bindPopup(popup).openPopup(latlng);
+		    return;
+		}
+
+		popup.setLatLng(latlng).openOn(map);
 		
-		var append = function(show_id, str) {
-		    var pre = document.createElement("pre");
-		    pre.setAttribute("id", show_id);
-		    pre.appendChild(document.createTextNode(str));		    
-		    raw_el.appendChild(pre);
-		};
+	    }).catch((err) => {
+		console.error("Failed to retrieve label", show_id, err);
+	    });
+    };
+
+    // Return an L.marker for 'latlng' using the icon named by style.icon, or null if there is no such icon. SVG
+    // icons are filled using the style's fill colour (or colour).
+    
+    var icon_marker = function(cfg, style, latlng){
+
+	if (! cfg.icons){
+	    return null;
+	}
+	
+	var details = cfg.icons.icons[style.icon];
+
+	if (! details){
+	    return null;
+	}
+
+	var url = cfg.icons.url + encodeURIComponent(style.icon);
+
+	if (details.colorable){
+	    var color = style.fillColor || style.color || "#3388ff";
+	    url = url + "?" + new URLSearchParams({ color: color });
+	}
+	
+	var size = cfg.icons.size;
+	
+	var icon = L.icon({
+	    iconUrl: url,
+	    iconSize: [ size, size ],
+	    iconAnchor: [ size * details.anchor[0], size * details.anchor[1] ],
+	    popupAnchor: [ 0, -size * details.anchor[1] ],
+	    className: style.className || "",
+	});
+
+	return L.marker(latlng, {
+	    icon: icon,
+	    interactive: (style.interactive !== false),
+	});
+    };
+    
+    // Return the list of dash and gap lengths defined by the Leaflet "dashArray" option 'v', or an empty
+    // list (a solid line) if 'v' is undefined.
+    
+    var dash_array = function(v){
+
+	if (! v){
+	    return [];
+	}
+
+	return String(v).split(/[, ]+/).filter((d) => d != "").map(Number);
+    };
+    
+    // Return the first argument which is a number, so that explicit zero values (for example a "fillOpacity"
+    // of 0) are not replaced by defaults.
+    
+    var first_number = function(){
+
+	for (var i = 0; i < arguments.length; i++){
+
+	    if (typeof(arguments[i]) == "number"){
+		return arguments[i];
+	    }
+	}
+
+	return undefined;
+    };
+    
+    // Return the default Leaflet options for 'feature' according to its geometry type. Line and polygon styles
+    // are merged on top of cfg.style. Points use cfg.point_style.
+    
+    var geometry_style = function(cfg, feature){
+
+	var type = (feature.geometry) ? feature.geometry.type : null;
+	
+	switch (type){
+	    case "Point":
+	    case "MultiPoint":
+		return Object.assign({}, cfg.point_style || {});
+	    case "LineString":
+	    case "MultiLineString":
+		return Object.assign({}, cfg.style || {}, cfg.line_style || {});
+	    case "Polygon":
+	    case "MultiPolygon":
+		return Object.assign({}, cfg.style || {}, cfg.polygon_style || {});
+	    default:
+		return Object.assign({}, cfg.style || {});
+	}
+    };
+    
+    // Return the Leaflet options for 'feature' derived from the default options for its geometry type and
+    // the style assigned to the feature by the server, if present.
+    
+    var feature_style = function(cfg, feature){
+	return ensure_pane(Object.assign(geometry_style(cfg, feature), feature["show:style"] || {}));
+    };
+
+    // Create the map pane named by style.pane, if it doesn't already exist, and return 'style'. Leaflet
+    // expects the panes that paths are added to to exist already.
+    
+    var ensure_pane = function(style){
+
+	if (style.pane && ! map.getPane(style.pane)){
+	    map.createPane(style.pane);
+	}
+
+	return style;
+    };
+    
+    var fit_bounds = function(bounds){
+	
+	var sw = bounds[0];
+	var ne = bounds[1];
+	
+	if ((sw[0] == ne[0]) && (sw[1] == ne[1])){
+	    map.setView(sw, 12);
+	} else {
+	    map.fitBounds(bounds);
+	}
+    };
+
+    // Render features using (server-side) vector tiles. This is used for large collections where
+    // fetching and rendering all the features at once would overwhelm the browser.
+    
+    var init_tiles = function(cfg) {
+
+	// Features may be assigned a style of their own by the server which is encoded as a JSON string
+	// in their "show:style" property. Resolved styles are cached by geometry type and that string.
+	
+	var resolved = new Map();
+
+	var point_base = Object.assign({}, cfg.point_style || {});
+	var line_base = Object.assign({}, cfg.style || {}, cfg.line_style || {});
+	var polygon_base = Object.assign({}, cfg.style || {}, cfg.polygon_style || {});
+	
+	var resolve_style = function(f){
+
+	    var key = f.geomType + ":" + (f.props["show:style"] || "");
+
+	    if (resolved.has(key)){
+		return resolved.get(key);
+	    }
+
+	    var feature_style = (f.props["show:style"]) ? JSON.parse(f.props["show:style"]) : {};
+
+	    var base = (f.geomType == 2) ? line_base : polygon_base;
+	    
+	    var style = Object.assign({}, cfg.style || {}, feature_style);
+	    var path_style = Object.assign({}, base, feature_style);
+	    var point_style = Object.assign({}, point_base, feature_style);
+	    
+	    // Leaflet's default path options
+	    var color = path_style.color || "#3388ff";
+	    var fill_color = path_style.fillColor || color;
+	    var weight = first_number(path_style.weight, 3);
+	    var opacity = first_number(path_style.opacity, 1.0);
+	    var fill_opacity = first_number(path_style.fillOpacity, 0.2);
+
+	    // Strokes and fills are disabled by making them transparent
+	    
+	    if (path_style.stroke === false){
+		opacity = 0;
+	    }
+
+	    if (path_style.fill === false){
+		fill_opacity = 0;
+	    }
+
+	    // Points fall back to the options in cfg.style
+	    var default_color = style.color || "#3388ff";
+
+	    var point_weight = first_number(point_style.weight, style.weight, 3);
+	    var point_opacity = first_number(point_style.fillOpacity, style.fillOpacity, 0.2);
+
+	    if (point_style.stroke === false){
+		point_weight = 0;
+	    }
+
+	    if (point_style.fill === false){
+		point_opacity = 0;
+	    }
+	    
+	    var r = {
+		color: color,
+		fill_color: fill_color,
+		weight: weight,
+		opacity: opacity,
+		fill_opacity: fill_opacity,
+		line_cap: path_style.lineCap || "round",
+		line_join: path_style.lineJoin || "round",
+		dash: dash_array(path_style.dashArray),
+		point_radius: first_number(point_style.radius, 10),
+		point_fill: point_style.fillColor || point_style.color || style.fillColor || default_color,
+		point_stroke: point_style.color || default_color,
+		point_weight: point_weight,
+		point_opacity: point_opacity,
+	    };
+
+	    resolved.set(key, r);
+	    return r;
+	};
+	
+	var paint_rules = [
+	    {
+		dataLayer: cfg.vector_tiles.layer,
+		symbolizer: new protomapsL.PolygonSymbolizer({
+		    fill: (z, f) => resolve_style(f).fill_color,
+		    opacity: (z, f) => resolve_style(f).fill_opacity,
+		}),
+		filter: function(z, f){ return f.geomType == 3; },
+	    },
+	    {
+		dataLayer: cfg.vector_tiles.layer,
+		symbolizer: new protomapsL.LineSymbolizer({
+		    color: (z, f) => resolve_style(f).color,
+		    width: (z, f) => resolve_style(f).weight,
+		    opacity: (z, f) => resolve_style(f).opacity,
+		    lineCap: (z, f) => resolve_style(f).line_cap,
+		    lineJoin: (z, f) => resolve_style(f).line_join,
+		    // Dashed lines are drawn using the dash colour and width
+		    dash: (z, f) => resolve_style(f).dash,
+		    dashColor: (z, f) => resolve_style(f).color,
+		    dashWidth: (z, f) => resolve_style(f).weight,
+		}),
+		filter: function(z, f){ return f.geomType == 3 || f.geomType == 2; },
+	    },
+	    {
+		dataLayer: cfg.vector_tiles.layer,
+		symbolizer: new protomapsL.CircleSymbolizer({
+		    radius: (z, f) => resolve_style(f).point_radius,
+		    fill: (z, f) => resolve_style(f).point_fill,
+		    stroke: (z, f) => resolve_style(f).point_stroke,
+		    width: (z, f) => resolve_style(f).point_weight,
+		    opacity: (z, f) => resolve_style(f).point_opacity,
+		}),
+		filter: function(z, f){ return f.geomType == 1; },
+	    },
+	];
+	
+	var tiles_layer = protomapsL.leafletLayer({
+	    url: cfg.vector_tiles.tile_url,
+	    maxDataZoom: cfg.vector_tiles.max_data_zoom,
+	    paintRules: paint_rules,
+	    labelRules: [],
+	    pane: "overlayPane",
+	});
+
+	tiles_layer.addTo(features_group);
+
+	var raw_el = document.querySelector("#raw");
+
+	map.on("click", function(e){
+
+	    if (! map.hasLayer(features_group)){
+		return;
+	    }
+	    
+	    var results = tiles_layer.queryTileFeaturesDebug(e.latlng.lng, e.latlng.lat);
+	    var props;
+	    
+	    for (const features of results.values()){
 		
-		if (raw_el){
-		    
-		    // Remember: Both sfomuseum.wasm.fetch and the WASM binary are imported and registered
-		    // in show.go. For details see: https://github.com/whosonfirst/go-whosonfirst-format-wasm
-		    
-		    sfomuseum.wasm.fetch("/wasm/wof_format.wasm").then(rsp => {
-			
-			var features = f.features;
-			var count = features.length;
-			
-			for (var i=0; i < count; i++){
-			    
-			    var show_id = features[i]["properties"]["show:id"];
-			    var this_f = structuredClone(features[i]);
-			    
-			    delete(this_f["properties"]["show:id"]);
-			    var str_f = JSON.stringify(this_f);
-			    
-			    format(show_id, str_f);
-			}
-			
-		    }).catch((err) => {
-			console.warn("Unable to load wof_format.wasm", err);
-			var str_f = JSON.stringify(f, "", " ");		    
-			append(0, str_f);
-		    });
+		for (const r of features){
 		    
+		    if (r.layerName == cfg.vector_tiles.layer){
+			props = r.feature.props;
+			break;
+		    }
 		}
 
-		var geojson_args = {
-		    onEachFeature: function (feature, layer) {
-
-			layer.on("click", function(e){			    
-			    var show_id = feature["properties"]["show:id"];
-			    select(show_id);
-			});
-
-			var label_props = cfg.label_properties;
-
-			if (label_props){
-			    var count_props = label_props.length;
-			    
-			    if (count_props > 0) {
-				
-				var label_text = [];
-				
-				for (var i=0; i < count_props; i++){
-				    
-				    var prop = label_props[i];
-				    var value = feature.properties[ prop ];
-				    
-				    label_text.push("<strong>" + prop + "</strong> " + value);
-				}
-				
-				if (label_text.length > 0){ 
-				    layer.bindPopup(label_text.join("<br />"));
-				}
-			    }
-			    
-			}
+		if (props){
+		    break;
+		}
+	    }
+
+	    if (! props){
+		return;
+	    }
+
+	    var show_id = props["show:id"];
+	    
+	    if (raw_el){
+		raw_el.replaceChildren();
+		var pre = append_raw(raw_el, show_id);
+		fetch_raw(pre, show_id);
+	    }
+	    
+	    select(show_id);
+
+	    // Vector tiles contain clipped and simplified geometries so fetch the complete
+	    // record in order to highlight the selected feature.
+	    
+	    fetch("features/" + encodeURIComponent(show_id) + ".geojson")
+		.then((rsp) => rsp.json())
+		.then((f) => {
+
+		    if (show_id == selected_id){
+			highlight_feature(f);
 		    }
-		};
+		    
+		}).catch((err) => {
+		    console.error("Failed to highlight feature", show_id, err);
+		});
+	    
+	    show_label(cfg, show_id, e.latlng);
+	});
+	
+	if (cfg.bounds){
+	    var b = cfg.bounds;
+	    fit_bounds([ [ b[1], b[0] ], [ b[3], b[2] ] ]);
+	}
+    };
+    
+    // Render features using (server-rendered) raster tiles. This is used for collections which are too
+    // large even for vector tiles. Clicking on the map queries the server for the nearest feature.
+    
+    var init_raster = function(cfg) {
 
-		if (cfg.style){
-		    geojson_args.style = cfg.style;
-		}
+	var raster_layer = L.tileLayer(cfg.raster.tile_url, {
+	    maxZoom: cfg.raster.max_zoom,
+	    pane: "overlayPane",
+	});
+
+	raster_layer.addTo(features_group);
+
+	var raw_el = document.querySelector("#raw");
+
+	map.on("click", function(e){
+
+	    if (! map.hasLayer(features_group)){
+		return;
+	    }
+	    
+	    var params = new URLSearchParams({
+		lat: e.latlng.lat,
+		lon: L.Util.wrapNum(e.latlng.lng, [-180, 180], true),
+		z: Math.floor(map.getZoom()),
+	    });
 
-		if (cfg.point_style) {
+	    fetch(cfg.raster.nearest_url + "?" + params.toString())
+		.then((rsp) => rsp.json())
+		.then((f) => {
 
-		    geojson_args.pointToLayer = function (feature, latlng) {
-			return L.circleMarker(latlng, cfg.point_style);
+		    if (! f.features.length){
+			return;
 		    }
+
+		    var show_id = f["show:ids"][0];
+
+		    // The nearest feature is returned as a complete record
 		    
-		}
+		    if (raw_el){
+			raw_el.replaceChildren();
+			var pre = append_raw(raw_el, show_id);
+			format_raw(pre, f.features[0]);
+		    }
+		    
+		    select(show_id);
+		    highlight_feature(f.features[0]);
+		    
+		    show_label(cfg, show_id, e.latlng);
+		    
+		}).catch((err) => {
+		    console.error("Failed to query nearest feature", err);
+		});
+	});
+	
+	if (cfg.bounds){
+	    var b = cfg.bounds;
+	    fit_bounds([ [ b[1], b[0] ], [ b[3], b[2] ] ]);
+	}
+    };
+    
+    var geojson_layer;
+    
+    var wasm_promise;
 
-		var geojson_layer = L.geoJSON(f, geojson_args);
-		geojson_layer.addTo(map);
-		
-		var bounds = whosonfirst.spelunker.geojson.derive_bounds(f);
-		
-		var sw = bounds[0];
-		var ne = bounds[1];
-		
-		if ((sw[0] == ne[0]) && (sw[1] == ne[1])){
-		    map.setView(sw, 12);
-		} else {
-		    map.fitBounds(bounds);
-		}
-		
+    // Remember: Both sfomuseum.wasm.fetch and the WASM binary are imported and registered
+    // in show.go. For details see: https://github.com/whosonfirst/go-whosonfirst-format-wasm
+    
+    var load_wasm = function(){
+
+	if (! wasm_promise){
+	    wasm_promise = sfomuseum.wasm.fetch("wasm/wof_format.wasm");
+	}
+
+	return wasm_promise;
+    };
+    
+    var raw_observer;
+    
+    // Append an empty element for the feature identified by 'show_id' to 'raw_el'.
+    
+    var append_raw = function(raw_el, show_id) {
+	var pre = document.createElement("pre");
+	pre.setAttribute("id", raw_element_id(show_id));
+	pre.setAttribute("data-show-id", show_id);
+	raw_el.appendChild(pre);
+	return pre;
+    };
+
+    // Format the string representation of feature 'f' and assign it to 'pre'.
+    
+    var format_raw = function(pre, f){
+
+	var str_f = JSON.stringify(f);
+
+	var assign = function(str){
+	    pre.replaceChildren(document.createTextNode(str));
+	};
+	
+	// Remember: wof_format is defined by the /wasm/wof_format.wasm binary.
+	// Details above.
+	
+	load_wasm().then((rsp) => {
+	    return wof_format(str_f);
+	}).then((rsp) => {
+	    assign(rsp);
+	}).catch((err) => {
+	    console.warn("Unable to format feature", err);
+	    assign(JSON.stringify(f, "", " "));
+	});
+    };
+
+    // Fetch the complete record for the feature identified by 'show_id' and assign its
+    // (formatted) string representation to 'pre'.
+    
+    var fetch_raw = function(pre, show_id){
+
+	if (pre.getAttribute("data-loaded")){
+	    return;
+	}
+
+	pre.setAttribute("data-loaded", "true");
+	
+	fetch("features/" + encodeURIComponent(show_id) + ".geojson")
+	    .then((rsp) => rsp.json())
+	    .then((f) => {
+		format_raw(pre, f);
 	    }).catch((err) => {
-		console.error("Failed to render features", err);
+		console.error("Failed to retrieve feature", show_id, err);
+		pre.removeAttribute("data-loaded");
 	    });
     };
 
-    fetch("/map.json")
+    // IntersectionObserver callback to fetch complete records as they are scrolled in to view.
+    
+    var load_raw = function(entries, observer){
+
+	for (const e of entries){
+
+	    if (! e.isIntersecting){
+		continue;
+	    }
+
+	    observer.unobserve(e.target);
+	    fetch_raw(e.target, e.target.getAttribute("data-show-id"));
+	}
+    };
+    
+    // Render the features in 'f' replacing any features which have been rendered already.
+    
+    var render_features = function(cfg, f) {
+
+	var features = f.features;
+	var count = features.length;
+
+	// The server assigns each feature a "show:id" identifier in the "show:ids" foreign member and,
+	// optionally, a style of its own in the "show:styles" foreign member.
+	var show_ids = f["show:ids"];
+	var show_styles = f["show:styles"];
+	
+	for (var i=0; i < count; i++){
+
+	    var show_id = (show_ids) ? show_ids[i] : "show-" + (i+1);
+	    
+	    if (! f.features[i]["properties"]){
+		f.features[i]["properties"] = {};
+	    }
+	    
+	    f.features[i]["properties"]["show:id"] = show_id;
+
+	    if (show_styles && show_styles[i]){
+		f.features[i]["show:style"] = show_styles[i];
+	    }
+	}
+	
+	var raw_el = document.querySelector("#raw");
+
+	// Features with simplified (or Geobuf-encoded, which are rounded) geometries or without properties
+	// are not complete records so fetch those from the server as they are scrolled in to view.
+	
+	var lazy = cfg.lazy_properties || cfg.simplify || (cfg.transport == "geobuf");
+	
+	if (raw_el){
+
+	    raw_el.replaceChildren();
+
+	    if (raw_observer){
+		raw_observer.disconnect();
+		raw_observer = null;
+	    }
+	    
+	    if (lazy){
+		raw_observer = new IntersectionObserver(load_raw, { root: raw_el });
+	    }
+	    
+	    for (var i=0; i < count; i++){
+		
+		var show_id = features[i]["properties"]["show:id"];
+		var pre = append_raw(raw_el, show_id);
+
+		if (lazy){
+		    pre.appendChild(document.createTextNode(show_id));
+		    raw_observer.observe(pre);
+		    continue;
+		}
+		
+		var this_f = structuredClone(features[i]);
+		delete(this_f["properties"]["show:id"]);
+		
+		format_raw(pre, this_f);
+	    }
+	}
+	
+	var layer_group;
+	feature_layers = {};
+	
+	var geojson_args = {
+	    onEachFeature: function (feature, layer) {
+
+		var show_id = feature["properties"]["show:id"];
+		feature_layers[show_id] = layer;
+		
+		layer.on("click", function(e){			    
+
+		    select(show_id);
+
+		    if (! layer.getPopup()){
+			var latlng = (layer.getLatLng) ? layer.getLatLng() : e.latlng;
+			show_label(cfg, show_id, latlng, layer);
+		    }
+		});
+
+		if (cfg.hover_style && layer.setStyle){
+
+		    layer.on("mouseover", function(e){
+
+			if (show_id != selected_id){
+			    layer.setStyle(cfg.hover_style);
+			}
+		    });
+
+		    layer.on("mouseout", function(e){
+
+			if (show_id != selected_id){
+			    layer_group.resetStyle(layer);
+			}
+		    });
+		}
+	    }
+	};
+	
+	geojson_args.style = function(feature){
+	    return feature_style(cfg, feature);
+	};
+	
+	geojson_args.pointToLayer = function (feature, latlng) {
+
+	    var style = feature_style(cfg, feature);
+	    
+	    if (style.icon){
+
+		var marker = icon_marker(cfg, style, latlng);
+
+		if (marker){
+		    return marker;
+		}
+	    }
+	    
+	    if (cfg.point_style || feature["show:style"]){
+		return L.circleMarker(latlng, feature_style(cfg, feature));
+	    }
+
+	    return L.marker(latlng);
+	};
+
+	if (geojson_layer){
+	    features_group.removeLayer(geojson_layer);
+	}
+
+	selected_layer = null;
+	
+	layer_group = L.geoJSON(f, geojson_args);
+	geojson_layer = layer_group;
+	geojson_layer.addTo(features_group);
+
+	// Features are re-rendered when the map is moved (in viewport and cluster modes)
+	// so make sure the selected feature stays highlighted.
+	
+	if (selected_id){
+	    highlight_layer(selected_id);
+	}
+    };
+
+    // Return the URL for requesting features, appending any additional query parameters in 'params'.
+    
+    var features_url = function(cfg, params){
+
+	params = new URLSearchParams(params || {});
+
+	// Labels are rendered by the server so features are requested without any properties
+
+	if (cfg.lazy_properties){
+	    params.set("properties", "");
+	}
+
+	if (cfg.simplify){
+	    params.set("z", Math.floor(map.getZoom()));
+	}
+	
+	var path = (cfg.transport == "geobuf") ? "features.pbf" : "features.geojson";
+	
+	var q = params.toString();
+	return (q) ? path + "?" + q : path;
+    };
+
+    // Fetch the features at 'url' decoding them according to the transport advertised in cfg.transport.
+    // Returns a Promise which resolves to a GeoJSON FeatureCollection.
+    
+    var fetch_features = function(cfg, url){
+
+	return fetch(url).then((rsp) => {
+
+	    if (! rsp.ok){
+		throw new Error(rsp.statusText);
+	    }
+	    
+	    if (cfg.transport == "geobuf"){
+		return rsp.arrayBuffer().then((buf) => sfomuseum.geobuf.decode(buf));
+	    }
+
+	    return rsp.json();
+	});
+    };
+    
+    // Only request (and render) the features inside the current map viewport, updating them
+    // whenever the map is moved. This is used for collections which are too large to fetch all
+    // at once but not large enough to warrant vector tiles.
+    
+    var init_viewport = function(cfg) {
+
+	var request_count = 0;
+	
+	var refresh = function(){
+
+	    request_count += 1;
+	    var this_request = request_count;
+	    
+	    var b = map.getBounds();
+	    var bbox = [ b.getWest(), b.getSouth(), b.getEast(), b.getNorth() ].join(",");
+	    
+	    fetch_features(cfg, features_url(cfg, { bbox: bbox }))
+		.then((f) => {
+
+		    // The map has been moved since this request was made
+		    if (this_request != request_count){
+			return;
+		    }
+		    
+		    render_features(cfg, f);
+		    
+		}).catch((err) => {
+		    console.error("Failed to render features", err);
+		});
+	};
+
+	map.on("moveend", refresh);
+	
+	if (cfg.bounds){
+	    var b = cfg.bounds;
+	    fit_bounds([ [ b[1], b[0] ], [ b[3], b[2] ] ]);
+	}
+
+	refresh();
+    };
+    
+    var clusters_layer;
+
+    // Render (server-side) clusters of point features for the current map viewport and zoom level,
+    // updating them whenever the map is moved. Clicking on a cluster zooms the map to the level at
+    // which the cluster breaks apart. Individual points, and non-point features, are rendered as usual.
+    
+    var init_clusters = function(cfg) {
+
+	var request_count = 0;
+
+	var render_clusters = function(f){
+
+	    var features = [];
+	    var ids = [];
+	    var styles = [];
+	    
+	    if (clusters_layer){
+		features_group.removeLayer(clusters_layer);
+	    }
+
+	    clusters_layer = L.layerGroup();
+	    
+	    var count = f.features.length;
+	    
+	    for (var i=0; i < count; i++){
+
+		var feature = f.features[i];
+		var props = feature.properties || {};
+		
+		if (! props["show:cluster"]){
+		    features.push(feature);
+		    ids.push(f["show:ids"][i]);
+		    styles.push((f["show:styles"]) ? f["show:styles"][i] : null);
+		    continue;
+		}
+		
+		var coords = feature.geometry.coordinates;
+		var latlng = L.latLng(coords[1], coords[0]);
+
+		var point_count = props["show:count"];
+		var size = 30 + (Math.min(String(point_count).length, 5) * 6);
+
+		// Use textContent rather than markup since (raw) feature properties are not trusted
+		
+		var count_el = document.createElement("span");
+		count_el.textContent = point_count;
+		
+		var icon = L.divIcon({
+		    html: count_el,
+		    className: "show-cluster",
+		    iconSize: L.point(size, size),
+		});
+
+		var marker = L.marker(latlng, { icon: icon });
+		var expansion_zoom = props["show:expansion_zoom"];
+		
+		marker.on("click", function(latlng, zoom){
+		    return function(e){
+			map.setView(latlng, zoom);
+		    };
+		}(latlng, expansion_zoom));
+
+		clusters_layer.addLayer(marker);
+	    }
+
+	    f.features = features;
+	    f["show:ids"] = ids;
+	    f["show:styles"] = styles;
+	    
+	    render_features(cfg, f);
+	    clusters_layer.addTo(features_group);
+	};
+	
+	var refresh = function(){
+
+	    request_count += 1;
+	    var this_request = request_count;
+	    
+	    var b = map.getBounds();
+	    var bbox = [ b.getWest(), b.getSouth(), b.getEast(), b.getNorth() ].join(",");
+
+	    var params = new URLSearchParams({
+		z: Math.floor(map.getZoom()),
+		bbox: bbox,
+	    });
+	    
+	    if (cfg.lazy_properties){
+		params.set("properties", "");
+	    }
+	    
+	    fetch_features(cfg, cfg.clusters.url + "?" + params.toString())
+		.then((f) => {
+
+		    // The map has been moved since this request was made
+		    if (this_request != request_count){
+			return;
+		    }
+
+		    render_clusters(f);
+		    
+		}).catch((err) => {
+		    console.error("Failed to render clusters", err);
+		});
+	};
+
+	map.on("moveend", refresh);
+	
+	if (cfg.bounds){
+	    var b = cfg.bounds;
+	    fit_bounds([ [ b[1], b[0] ], [ b[3], b[2] ] ]);
+	}
+
+	refresh();
+    };
+    
+    // Return the minimum zoom level of the band of simplified geometries for zoom level 'z'
+    // or -1 if original geometries are served.
+    
+    var simplify_band = function(cfg, z){
+
+	if (z >= cfg.simplify.max_zoom){
+	    return -1;
+	}
+
+	var band = 0;
+	
+	for (const min_z of cfg.simplify.zooms){
+
+	    if (z >= min_z){
+		band = min_z;
+	    }
+	}
+
+	return band;
+    };
+    
+    // A graduated colour ramp (ColorBrewer's YlOrRd) for aggregated cells.
+    
+    var aggregate_ramp = [ "#ffffb2", "#fecc5c", "#fd8d3c", "#f03b20", "#bd0026" ];
+
+    // Return the colour for an aggregated cell containing 'count' points where the cell with the
+    // most points contains 'max_count' points. Counts are divided in to equal intervals.
+    
+    var aggregate_color = function(count, max_count){
+
+	if (max_count <= 1){
+	    return aggregate_ramp[ aggregate_ramp.length - 1 ];
+	}
+	
+	var idx = Math.floor((count - 1) / (max_count) * aggregate_ramp.length);
+	return aggregate_ramp[ Math.min(idx, aggregate_ramp.length - 1) ];
+    };
+
+    // Aggregate point features in to hexagonal or square cells, for the current map viewport and
+    // zoom level, and add a control to toggle between features and aggregated cells.
+    
+    var init_aggregate = function(cfg) {
+
+	var aggregate_type = cfg.aggregate.type || "";
+	var aggregate_layer;
+	var request_count = 0;
+
+	var refresh = function(){
+
+	    request_count += 1;
+	    var this_request = request_count;
+	    
+	    if (! aggregate_type){
+
+		if (aggregate_layer){
+		    aggregate_layer.remove();
+		    aggregate_layer = null;
+		}
+
+		if (! map.hasLayer(features_group)){
+		    features_group.addTo(map);
+		}
+		
+		return;
+	    }
+
+	    var b = map.getBounds();
+	    var bbox = [ b.getWest(), b.getSouth(), b.getEast(), b.getNorth() ].join(",");
+
+	    var params = new URLSearchParams({
+		type: aggregate_type,
+		z: Math.floor(map.getZoom()),
+		bbox: bbox,
+	    });
+
+	    fetch(cfg.aggregate.url + "?" + params.toString())
+		.then((rsp) => rsp.json())
+		.then((f) => {
+
+		    // The map has been moved, or the aggregation type changed, since this request was made
+		    if (this_request != request_count){
+			return;
+		    }
+
+		    var max_count = f["show:max_count"];
+		    
+		    var layer = L.geoJSON(f, {
+			style: function(feature){
+			    return {
+				color: "#ffffff",
+				weight: 1,
+				fillColor: aggregate_color(feature.properties["show:count"], max_count),
+				fillOpacity: 0.7,
+			    };
+			},
+			onEachFeature: function(feature, layer){
+
+			    var props = feature.properties;
+			    var label = [ "<strong>count</strong> " + Number(props["show:count"]) ];
+
+			    if (props["show:sum"] != undefined){
+				label.push("<strong>sum</strong> " + Number(props["show:sum"]));
+				label.push("<strong>mean</strong> " + Number(props["show:mean"]).toFixed(2));
+			    }
+
+			    layer.bindPopup(label.join("<br />"));
+			},
+		    });
+
+		    if (aggregate_layer){
+			aggregate_layer.remove();
+		    }
+
+		    aggregate_layer = layer;
+		    aggregate_layer.addTo(map);
+
+		    features_group.remove();
+		    
+		}).catch((err) => {
+		    console.error("Failed to render aggregated cells", err);
+		});
+	};
+
+	var control = L.control({ position: "topright" });
+
+	control.onAdd = function(){
+
+	    var el = L.DomUtil.create("div", "leaflet-bar show-aggregate");
+	    var select = L.DomUtil.create("select", "", el);
+
+	    var options = [
+		[ "", "Features" ],
+		[ "hex", "Hexagons" ],
+		[ "grid", "Grid" ],
+	    ];
+
+	    for (const o of options){
+		var opt = L.DomUtil.create("option", "", select);
+		opt.setAttribute("value", o[0]);
+		opt.appendChild(document.createTextNode(o[1]));
+	    }
+
+	    select.value = aggregate_type;
+	    
+	    select.onchange = function(){
+		aggregate_type = select.value;
+		refresh();
+	    };
+
+	    L.DomEvent.disableClickPropagation(el);
+	    return el;
+	};
+
+	control.addTo(map);
+	map.on("moveend", refresh);
+	
+	if (aggregate_type){
+	    refresh();
+	}
+    };
+    
+    // The font used to draw (and measure) permanent map labels. This needs to match the .show-map-label
+    // rules in show.css.
+    var map_label_font = "12px sans-serif";
+    var map_label_height = 16;
+
+    // The minimum space, in pixels, between map labels and the distance between point labels and their point.
+    var map_label_padding = 4;
+    var map_label_offset = 8;
+    
+    // Draw permanent text labels for features in the current map viewport. Labels are drawn next to points,
+    // inside polygons and along lines. The server returns labels for larger features first and labels which
+    // would overlap a label that has already been drawn are skipped.
+    
+    var init_map_labels = function(cfg) {
+
+	map.createPane("show-map-labels");
+	
+	var labels_layer = L.layerGroup();
+	labels_layer.addTo(features_group);
+
+	var measure_ctx = document.createElement("canvas").getContext("2d");
+	measure_ctx.font = map_label_font;
+	
+	var request_count = 0;
+
+	// Return the center point and angle (in degrees) of a label 'width' pixels wide drawn along the middle of
+	// the visible part of the line 'latlngs', or null if the visible part of the line is too short.
+	
+	var line_placement = function(latlngs, width, bounds){
+
+	    var segments = [];
+	    var length = 0;
+
+	    for (var i = 1; i < latlngs.length; i++){
+
+		var a = map.latLngToContainerPoint(latlngs[i - 1]);
+		var b = map.latLngToContainerPoint(latlngs[i]);
+		
+		var clipped = L.LineUtil.clipSegment(a, b, bounds, false, false);
+
+		if (! clipped){
+		    continue;
+		}
+
+		var d = clipped[0].distanceTo(clipped[1]);
+
+		if (d > 0){
+		    segments.push([ clipped[0], clipped[1], d ]);
+		    length += d;
+		}
+	    }
+
+	    if (length < width + (map_label_padding * 2)){
+		return null;
+	    }
+
+	    var target = length / 2;
+	    
+	    for (const seg of segments){
+
+		if (target > seg[2]){
+		    target -= seg[2];
+		    continue;
+		}
+
+		var a = seg[0];
+		var b = seg[1];
+		var t = target / seg[2];
+
+		var angle = Math.atan2(b.y - a.y, b.x - a.x) * 180 / Math.PI;
+
+		// Keep text upright
+		
+		if (angle > 90){
+		    angle -= 180;
+		} else if (angle < -90){
+		    angle += 180;
+		}
+		
+		return {
+		    point: L.point(a.x + (b.x - a.x) * t, a.y + (b.y - a.y) * t),
+		    angle: angle,
+		};
+	    }
+
+	    return null;
+	};
+
+	var render = function(f){
+
+	    labels_layer.clearLayers();
+
+	    var size = map.getSize();
+	    var bounds = L.bounds(L.point(0, 0), size);
+	    var placed = [];
+	    
+	    for (const feature of f.features){
+
+		var text = String(feature.properties["show:label"]);
+		var coords = feature.geometry.coordinates;
+		
+		var width = Math.ceil(measure_ctx.measureText(text).width);
+		var height = map_label_height;
+		
+		var center;
+		var angle = 0;
+		
+		switch (feature.properties["show:placement"]){
+		    case "line":
+
+			var latlngs = coords.map((c) => L.latLng(c[1], c[0]));
+			var placement = line_placement(latlngs, width, bounds);
+
+			if (! placement){
+			    continue;
+			}
+
+			center = placement.point;
+			angle = placement.angle;
+			break;
+			
+		    default:
+
+			center = map.latLngToContainerPoint(L.latLng(coords[1], coords[0]));
+
+			// Labels for points are drawn to the right of the point
+			
+			if (feature.properties["show:placement"] == "point"){
+			    center = center.add(L.point(map_label_offset + (width / 2), 0));
+			}
+		}
+
+		// The (axis-aligned) bounding box of the rotated label
+		
+		var rad = angle * Math.PI / 180;
+		var box_w = Math.abs(width * Math.cos(rad)) + Math.abs(height * Math.sin(rad));
+		var box_h = Math.abs(width * Math.sin(rad)) + Math.abs(height * Math.cos(rad));
+
+		var half = L.point((box_w / 2) + map_label_padding, (box_h / 2) + map_label_padding);
+		var box = L.bounds(center.subtract(half), center.add(half));
+
+		if (! bounds.contains(center)){
+		    continue;
+		}
+
+		var collides = false;
+
+		for (const other of placed){
+
+		    if (box.overlaps(other)){
+			collides = true;
+			break;
+		    }
+		}
+
+		if (collides){
+		    continue;
+		}
+
+		placed.push(box);
+		
+		// Use textContent rather than markup since labels are derived from (untrusted) feature properties
+		
+		var el = document.createElement("span");
+		el.textContent = text;
+
+		if (angle){
+		    el.style.transform = "rotate(" + angle + "deg)";
+		}
+		
+		var icon = L.divIcon({
+		    html: el,
+		    className: "show-map-label",
+		    iconSize: L.point(width, height),
+		    iconAnchor: L.point(width / 2, height / 2),
+		});
+
+		var marker = L.marker(map.containerPointToLatLng(center), {
+		    icon: icon,
+		    pane: "show-map-labels",
+		    interactive: false,
+		    keyboard: false,
+		});
+
+		labels_layer.addLayer(marker);
+	    }
+	};
+	
+	var refresh = function(){
+
+	    request_count += 1;
+	    var this_request = request_count;
+
+	    var b = map.getBounds();
+	    var bbox = [ b.getWest(), b.getSouth(), b.getEast(), b.getNorth() ].join(",");
+
+	    var params = new URLSearchParams({
+		z: Math.floor(map.getZoom()),
+		bbox: bbox,
+	    });
+
+	    fetch(cfg.map_labels.url + "?" + params.toString())
+		.then((rsp) => {
+
+		    if (! rsp.ok){
+			throw new Error(rsp.status + " " + rsp.statusText);
+		    }
+
+		    return rsp.json();
+		})
+		.then((f) => {
+
+		    // The map has been moved since this request was made
+		    if (this_request != request_count){
+			return;
+		    }
+
+		    render(f);
+		    
+		}).catch((err) => {
+		    console.error("Failed to render map labels", err);
+		});
+	};
+
+	map.on("zoomstart", function(){
+	    labels_layer.clearLayers();
+	});
+	
+	map.on("moveend", refresh);
+	refresh();
+    };
+    
+    // Draw a legend for features coloured by property value. The classes, and their colours, are
+    // calculated by the server.
+    
+    var init_legend = function(cfg) {
+
+	var format_number = function(n){
+	    return n.toLocaleString(undefined, { maximumFractionDigits: 2 });
+	};
+	
+	var control = L.control({ position: "bottomright" });
+
+	control.onAdd = function(){
+
+	    var el = L.DomUtil.create("div", "show-legend");
+	    
+	    var title = L.DomUtil.create("div", "show-legend-title", el);
+	    title.appendChild(document.createTextNode(cfg.choropleth.property));
+
+	    for (const c of cfg.choropleth.classes){
+
+		var label;
+
+		if (c.other){
+		    label = "Other";
+		} else if (c.value !== undefined){
+		    label = c.value;
+		} else if (c.min == c.max){
+		    label = format_number(c.min);
+		} else {
+		    label = format_number(c.min) + " – " + format_number(c.max);
+		}
+		
+		var row = L.DomUtil.create("div", "show-legend-class", el);
+		
+		var swatch = L.DomUtil.create("span", "show-legend-swatch", row);
+		swatch.style.backgroundColor = c.color;
+
+		row.appendChild(document.createTextNode(label + " (" + c.count.toLocaleString() + ")"));
+	    }
+	    
+	    L.DomEvent.disableClickPropagation(el);
+	    L.DomEvent.disableScrollPropagation(el);
+	    return el;
+	};
+
+	control.addTo(map);
+    };
+    
+    var init = function(cfg) {
+
+	map_cfg = cfg;
+
+	if (cfg.aggregate){
+	    init_aggregate(cfg);
+	}
+	
+	if (cfg.choropleth){
+	    init_legend(cfg);
+	}
+
+	if (cfg.map_labels){
+	    init_map_labels(cfg);
+	}
+
+	if (cfg.raster){
+	    init_raster(cfg);
+	    return;
+	}
+	
+	if (cfg.clusters){
+	    init_clusters(cfg);
+	    return;
+	}
+	
+	if (cfg.vector_tiles){
+	    init_tiles(cfg);
+	    return;
+	}
+
+	if (cfg.viewport_queries){
+	    init_viewport(cfg);
+	    return;
+	}
+	
+	var request_count = 0;
+	var current_band;
+	
+	var load = function(fit){
+
+	    request_count += 1;
+	    var this_request = request_count;
+
+	    if (cfg.simplify){
+		current_band = simplify_band(cfg, Math.floor(map.getZoom()));
+	    }
+	    
+	    fetch_features(cfg, features_url(cfg))
+		.then((f) => {
+
+		    // The map has been zoomed since this request was made
+		    if (this_request != request_count){
+			return;
+		    }
+		    
+		    render_features(cfg, f);
+
+		    if (fit){
+			var bounds = whosonfirst.spelunker.geojson.derive_bounds(f);
+			fit_bounds(bounds);
+		    }
+		    
+		}).catch((err) => {
+		    console.error("Failed to render features", err);
+		});
+	};
+
+	// Reload features whenever the map is zoomed in to a different band of simplified geometries.
+	
+	if (cfg.simplify){
+
+	    map.on("zoomend", function(){
+
+		if (simplify_band(cfg, Math.floor(map.getZoom())) != current_band){
+		    load(false);
+		}
+	    });
+	}
+	
+	load(true);
+    };
+
+    fetch("map.json")
 	.then((rsp) => rsp.json())
 	.then((cfg) => {
 
@@ -1,21 +1,278 @@
 package show
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
 )
 
-// LeafletStyle is a struct containing details for decorating GeoJSON features and markers
+// The names that can be assigned to the "pane" style option. Pane names are used as (part of) CSS class names.
+var re_style_pane = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_-]*$`)
+
+// The default style applied to the currently selected feature.
+var default_selected_style = &LeafletStyle{
+	Color:   "#ff7800",
+	Weight:  Float(5),
+	Opacity: Float(1),
+}
+
+// LeafletStyle is a struct containing details for decorating GeoJSON features and markers. It models the complete
+// set of Leaflet path options (https://leafletjs.com/reference.html#path-option), the polyline options "smoothFactor"
+// and "noClip", the radius of circle markers and the icon used to render point features as markers.
+// Empty strings and nil options are treated as unset and Leaflet's defaults are used instead. Boolean and numeric
+// options are pointers so that explicit false and zero values (for example a "fillOpacity" of 0) are preserved. Use
+// the `Float` and `Bool` functions to set them.
 type LeafletStyle struct {
-	Color       string  `json:"color,omitempty"`
-	FillColor   string  `json:"fillColor,omitempty"`
-	Weight      float64 `json:"weight,omitempty"`
-	Opacity     float64 `json:"opacity,omitempty"`
-	Radius      float64 `json:"radius,omitempty"`
-	FillOpacity float64 `json:"fillOpacity,omitempty"`
+	// Stroke signals whether to draw the outlines of paths and circle markers.
+	Stroke *bool `json:"stroke,omitempty"`
+	// Color is the stroke colour.
+	Color string `json:"color,omitempty"`
+	// Weight is the stroke width in pixels.
+	Weight *float64 `json:"weight,omitempty"`
+	// Opacity is the stroke opacity, between 0 and 1.
+	Opacity *float64 `json:"opacity,omitempty"`
+	// LineCap is the shape used at the end of strokes: "butt", "round" or "square".
+	LineCap string `json:"lineCap,omitempty"`
+	// LineJoin is the shape used at the corners of strokes: "miter", "round" or "bevel".
+	LineJoin string `json:"lineJoin,omitempty"`
+	// DashArray is a comma or space separated list of dash and gap lengths, in pixels, defining the stroke's dash pattern.
+	DashArray string `json:"dashArray,omitempty"`
+	// DashOffset is the distance, in pixels, in to the dash pattern to start the stroke's dash pattern.
+	DashOffset string `json:"dashOffset,omitempty"`
+	// Fill signals whether to fill paths and circle markers.
+	Fill *bool `json:"fill,omitempty"`
+	// FillColor is the fill colour. If empty `Color` is used.
+	FillColor string `json:"fillColor,omitempty"`
+	// FillOpacity is the fill opacity, between 0 and 1.
+	FillOpacity *float64 `json:"fillOpacity,omitempty"`
+	// FillRule defines how the inside of a shape is determined: "nonzero" or "evenodd".
+	FillRule string `json:"fillRule,omitempty"`
+	// Radius is the radius, in pixels, of circle markers.
+	Radius *float64 `json:"radius,omitempty"`
+	// ClassName is a custom CSS class name assigned to the element for paths and circle markers.
+	ClassName string `json:"className,omitempty"`
+	// Interactive signals whether paths and circle markers emit mouse events (for example when clicked).
+	Interactive *bool `json:"interactive,omitempty"`
+	// BubblingMouseEvents signals whether mouse events on paths and circle markers are propagated to the map.
+	BubblingMouseEvents *bool `json:"bubblingMouseEvents,omitempty"`
+	// Pane is the name of the map pane that paths and circle markers are added to. Panes which don't exist are created.
+	Pane string `json:"pane,omitempty"`
+	// SmoothFactor is how much to simplify lines and polygons, in pixels, at each zoom level.
+	SmoothFactor *float64 `json:"smoothFactor,omitempty"`
+	// NoClip signals whether clipping lines and polygons to the map viewport is disabled.
+	NoClip *bool `json:"noClip,omitempty"`
+	// Icon is the name of the icon used to render point features as markers rather than circle markers. SVG icons
+	// are filled using `FillColor` or `Color`.
+	Icon string `json:"icon,omitempty"`
+}
+
+// UnmarshalJSON decodes 'b' in to 's' reporting unknown options, and options with invalid types or values, as errors.
+func (s *LeafletStyle) UnmarshalJSON(b []byte) error {
+
+	// Use a type without the UnmarshalJSON method to prevent infinite recursion
+
+	type leafletStyle LeafletStyle
+
+	var v leafletStyle
+
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(&v)
+
+	if err != nil {
+		return styleDecodeError(err)
+	}
+
+	style := LeafletStyle(v)
+
+	err = style.validate()
+
+	if err != nil {
+		return err
+	}
+
+	*s = style
+	return nil
+}
+
+// validate returns an error if any of the options in 's' have invalid values.
+func (s *LeafletStyle) validate() error {
+
+	for k, v := range map[string]*float64{"weight": s.Weight, "radius": s.Radius, "smoothFactor": s.SmoothFactor} {
+
+		if v != nil && *v < 0 {
+			return fmt.Errorf("Invalid value for style option '%s', must not be negative", k)
+		}
+	}
+
+	for k, v := range map[string]*float64{"opacity": s.Opacity, "fillOpacity": s.FillOpacity} {
+
+		if v != nil && (*v < 0 || *v > 1) {
+			return fmt.Errorf("Invalid value for style option '%s', must be between 0 and 1", k)
+		}
+	}
+
+	enums := []struct {
+		name   string
+		value  string
+		values []string
+	}{
+		{"lineCap", s.LineCap, []string{"butt", "round", "square"}},
+		{"lineJoin", s.LineJoin, []string{"miter", "round", "bevel"}},
+		{"fillRule", s.FillRule, []string{"nonzero", "evenodd"}},
+	}
+
+	for _, e := range enums {
+
+		if e.value != "" && !slices.Contains(e.values, e.value) {
+			return fmt.Errorf("Invalid value for style option '%s', '%s'. Valid options are: %s", e.name, e.value, strings.Join(e.values, ", "))
+		}
+	}
+
+	if s.Pane != "" && !re_style_pane.MatchString(s.Pane) {
+		return fmt.Errorf("Invalid value for style option 'pane', '%s'. Pane names may only contain letters, numbers, '-' and '_'", s.Pane)
+	}
+
+	_, err := parseDashArray(s.DashArray)
+
+	if err != nil {
+		return fmt.Errorf("Invalid value for style option 'dashArray', %w", err)
+	}
+
+	if s.DashOffset != "" {
+
+		_, err := strconv.ParseFloat(strings.TrimSpace(s.DashOffset), 64)
+
+		if err != nil {
+			return fmt.Errorf("Invalid value for style option 'dashOffset', '%s' is not a number", s.DashOffset)
+		}
+	}
+
+	return nil
+}
+
+// key returns a string which uniquely identifies the options in 's', suitable for use as a map key.
+func (s *LeafletStyle) key() string {
+
+	enc, err := json.Marshal(s)
+
+	if err != nil {
+		return fmt.Sprintf("%#v", s)
+	}
+
+	return string(enc)
+}
+
+// parseDashArray parses 'v', a comma or space separated list of non-negative numbers, returning nil if 'v' is empty.
+func parseDashArray(v string) ([]float64, error) {
+
+	fields := strings.FieldsFunc(v, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t'
+	})
+
+	if len(fields) == 0 {
+		return nil, nil
+	}
+
+	dashes := make([]float64, len(fields))
+
+	for i, f := range fields {
+
+		d, err := strconv.ParseFloat(f, 64)
+
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("'%s' is not a non-negative number", f)
+		}
+
+		dashes[i] = d
+	}
+
+	return dashes, nil
+}
+
+// jsonSyntaxError returns a more useful version of 'err' if it is a JSON syntax error, or 'err' otherwise.
+func jsonSyntaxError(err error) error {
+
+	var syntax_err *json.SyntaxError
+
+	switch {
+	case errors.As(err, &syntax_err):
+		return fmt.Errorf("Invalid JSON at offset %d, %w", syntax_err.Offset, err)
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return fmt.Errorf("Invalid JSON, unexpected end of input")
+	default:
+		return err
+	}
+}
+
+// styleDecodeError returns a more useful version of 'err', an error returned while decoding a `LeafletStyle`, for
+// unknown options and options with the wrong type.
+func styleDecodeError(err error) error {
+
+	var type_err *json.UnmarshalTypeError
+
+	if errors.As(err, &type_err) {
+
+		if type_err.Field == "" {
+			return fmt.Errorf("Invalid style, expected an object but got %s", type_err.Value)
+		}
+
+		expected := "a " + type_err.Type.Kind().String()
+
+		switch type_err.Type.Kind() {
+		case reflect.Float64:
+			expected = "a number"
+		case reflect.Bool:
+			expected = "a boolean"
+		}
+
+		return fmt.Errorf("Invalid value for style option '%s', expected %s but got %s", type_err.Field, expected, type_err.Value)
+	}
+
+	msg := err.Error()
+
+	if !strings.HasPrefix(msg, "json: unknown field ") {
+		return fmt.Errorf("Invalid style, %w", err)
+	}
+
+	name, unquote_err := strconv.Unquote(strings.TrimPrefix(msg, "json: unknown field "))
+
+	if unquote_err != nil {
+		return fmt.Errorf("Invalid style, %w", err)
+	}
+
+	// Suggest a valid option for common mistakes like "fill-color" or "fillcolor"
+
+	normalize := func(k string) string {
+		k = strings.ToLower(k)
+		return strings.NewReplacer("-", "", "_", "").Replace(k)
+	}
+
+	t := reflect.TypeOf(LeafletStyle{})
+
+	for i := 0; i < t.NumField(); i++ {
+
+		opt, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+
+		if normalize(opt) == normalize(name) {
+			return fmt.Errorf("Unknown style option '%s', did you mean '%s'?", name, opt)
+		}
+	}
+
+	return fmt.Errorf("Unknown style option '%s'", name)
 }
 
 // UnmarshalStyle derives a `LeafletStyle` instance from 'raw'. If 'raw' starts with "{" then it is treated as
@@ -45,29 +302,365 @@ func UnmarshalStyle(raw string) (*LeafletStyle, error) {
 
 // UnmarshalStyleFromString derives a `LeafletStyle` instance from 'raw'.
 func UnmarshalStyleFromString(raw string) (*LeafletStyle, error) {
+	return UnmarshalStyleFromReader(strings.NewReader(raw))
+}
+
+// UnmarshalStyleFromString derives a `LeafletStyle` instance from the body of 'r'. Unknown options, and options with
+// invalid types or values, are reported as errors.
+func UnmarshalStyleFromReader(r io.Reader) (*LeafletStyle, error) {
 
 	var s *LeafletStyle
 
-	err := json.Unmarshal([]byte(raw), &s)
+	dec := json.NewDecoder(r)
+	err := dec.Decode(&s)
 
 	if err != nil {
-		return nil, err
+		return nil, jsonSyntaxError(err)
 	}
 
 	return s, nil
 }
 
-// UnmarshalStyleFromString derives a `LeafletStyle` instance from the body of 'r'.
-func UnmarshalStyleFromReader(r io.Reader) (*LeafletStyle, error) {
+// Float returns a pointer to 'v', for setting the numeric options of a `LeafletStyle` instance. For example
+// `&LeafletStyle{FillOpacity: Float(0.5)}`.
+func Float(v float64) *float64 {
+	return &v
+}
 
-	var s *LeafletStyle
+// Bool returns a pointer to 'v', for setting the boolean options of a `LeafletStyle` instance. For example
+// `&LeafletStyle{Stroke: Bool(false)}`.
+func Bool(v bool) *bool {
+	return &v
+}
 
-	dec := json.NewDecoder(r)
-	err := dec.Decode(&s)
+// mergeStyles returns a new `LeafletStyle` instance containing the properties of 'base' overridden by any properties
+// that are set in 'override'. Either argument may be nil.
+func mergeStyles(base *LeafletStyle, override *LeafletStyle) *LeafletStyle {
+
+	s := &LeafletStyle{}
+
+	if base != nil {
+		*s = *base
+	}
+
+	if override == nil {
+		return s
+	}
+
+	if override.Stroke != nil {
+		s.Stroke = override.Stroke
+	}
+
+	if override.Color != "" {
+		s.Color = override.Color
+	}
+
+	if override.Weight != nil {
+		s.Weight = override.Weight
+	}
+
+	if override.Opacity != nil {
+		s.Opacity = override.Opacity
+	}
+
+	if override.LineCap != "" {
+		s.LineCap = override.LineCap
+	}
+
+	if override.LineJoin != "" {
+		s.LineJoin = override.LineJoin
+	}
+
+	if override.DashArray != "" {
+		s.DashArray = override.DashArray
+	}
+
+	if override.DashOffset != "" {
+		s.DashOffset = override.DashOffset
+	}
+
+	if override.Fill != nil {
+		s.Fill = override.Fill
+	}
+
+	if override.FillColor != "" {
+		s.FillColor = override.FillColor
+	}
+
+	if override.FillOpacity != nil {
+		s.FillOpacity = override.FillOpacity
+	}
+
+	if override.FillRule != "" {
+		s.FillRule = override.FillRule
+	}
+
+	if override.Radius != nil {
+		s.Radius = override.Radius
+	}
+
+	if override.ClassName != "" {
+		s.ClassName = override.ClassName
+	}
+
+	if override.Interactive != nil {
+		s.Interactive = override.Interactive
+	}
+
+	if override.BubblingMouseEvents != nil {
+		s.BubblingMouseEvents = override.BubblingMouseEvents
+	}
+
+	if override.Pane != "" {
+		s.Pane = override.Pane
+	}
+
+	if override.SmoothFactor != nil {
+		s.SmoothFactor = override.SmoothFactor
+	}
+
+	if override.NoClip != nil {
+		s.NoClip = override.NoClip
+	}
+
+	if override.Icon != "" {
+		s.Icon = override.Icon
+	}
+
+	return s
+}
+
+// featureStyler returns the style for an individual feature or nil if the feature should be rendered using the
+// default style for the map.
+type featureStyler func(f *geojson.Feature) *LeafletStyle
+
+// newFeatureStyler returns a `featureStyler` combining the rules-based styles, choropleth colours, simplestyle-spec
+// properties, icons and style callback defined by 'opts', relative to the default styles 'style' and 'point_style' (and
+// the line and polygon styles in 'opts'), or nil if all features should be rendered using the default styles. Icons
+// matching the value of `opts.IconProperty` in 'icons' are merged on top of the styles derived from properties and the
+// style returned by `opts.StyleFunc` is merged on top of all the other styles. 'choropleth' and 'icons' may be nil.
+func newFeatureStyler(opts *RunOptions, style *LeafletStyle, point_style *LeafletStyle, choropleth *choropleth, icons *iconSet) (featureStyler, error) {
+
+	styler, err := newPropertiesStyler(opts, style, point_style, choropleth)
 
 	if err != nil {
 		return nil, err
 	}
 
-	return s, nil
+	if icons != nil && opts.IconProperty != "" {
+		styler = composeStylers(styler, icons.styler(opts.IconProperty))
+	}
+
+	if opts.StyleFunc != nil {
+		styler = composeStylers(styler, opts.StyleFunc)
+	}
+
+	return styler, nil
+}
+
+// composeStylers returns a `featureStyler` which merges the style returned by 'override' on top of the style returned
+// by 'base'. Either argument may be nil.
+func composeStylers(base featureStyler, override featureStyler) featureStyler {
+
+	if base == nil {
+		return override
+	}
+
+	if override == nil {
+		return base
+	}
+
+	fn := func(f *geojson.Feature) *LeafletStyle {
+
+		base_style := base(f)
+		override_style := override(f)
+
+		if override_style == nil {
+			return base_style
+		}
+
+		if base_style == nil {
+			return override_style
+		}
+
+		return mergeStyles(base_style, override_style)
+	}
+
+	return fn
+}
+
+// newPropertiesStyler returns a `featureStyler` combining the rules-based styles, choropleth colours and simplestyle-spec
+// properties defined by 'opts', relative to the default styles 'style' and 'point_style' (and the line and polygon styles
+// in 'opts'), or nil if all features should be rendered using the default styles. Choropleth colours are merged on top of rules-based styles. 'choropleth' may be nil.
+func newPropertiesStyler(opts *RunOptions, style *LeafletStyle, point_style *LeafletStyle, choropleth *choropleth) (featureStyler, error) {
+
+	err := validateSimpleStyle(opts.SimpleStyle)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var rules_styler featureStyler
+
+	if opts.StyleRules != nil && len(opts.StyleRules.Rules) > 0 {
+		rules_styler = opts.StyleRules.styler()
+	}
+
+	// The style derived from the rules and choropleth classes a feature matches
+
+	var base_styler featureStyler
+
+	switch {
+	case choropleth == nil:
+		base_styler = rules_styler
+	case rules_styler == nil:
+		base_styler = choropleth.Style
+	default:
+
+		base_styler = func(f *geojson.Feature) *LeafletStyle {
+
+			rule_style := rules_styler(f)
+			choropleth_style := choropleth.Style(f)
+
+			if choropleth_style == nil {
+				return rule_style
+			}
+
+			return mergeStyles(rule_style, choropleth_style)
+		}
+	}
+
+	mode := opts.SimpleStyle
+
+	if mode == "" || mode == simplestyle_none {
+		return base_styler, nil
+	}
+
+	fn := func(f *geojson.Feature) *LeafletStyle {
+
+		var base_style *LeafletStyle
+
+		if base_styler != nil {
+			base_style = base_styler(f)
+		}
+
+		simple_style := simpleStyle(f)
+
+		if simple_style == nil {
+			return base_style
+		}
+
+		if mode == simplestyle_style {
+
+			// Only use the simplestyle-spec options which aren't already defined by the
+			// default style for the feature or the style derived from rules and choropleth classes.
+
+			var default_style *LeafletStyle
+
+			switch f.Geometry.(type) {
+			case orb.Point, orb.MultiPoint:
+				default_style = point_style
+			case orb.LineString, orb.MultiLineString:
+				default_style = mergeStyles(style, opts.LineStyle)
+			case orb.Polygon, orb.MultiPolygon:
+				default_style = mergeStyles(style, opts.PolygonStyle)
+			default:
+				default_style = style
+			}
+
+			simple_style = withoutStyle(simple_style, mergeStyles(default_style, base_style))
+
+			if simple_style == nil {
+				return base_style
+			}
+
+			return mergeStyles(simple_style, base_style)
+		}
+
+		return mergeStyles(base_style, simple_style)
+	}
+
+	return fn, nil
+}
+
+// featureStyles caches the styles derived by a `featureStyler` for each feature in a `featureCollection`. Since
+// feature collections are append-only styles are stored by offset and only derived for features added since the
+// last update.
+type featureStyles struct {
+	mu     *sync.RWMutex
+	styler featureStyler
+	styles []*LeafletStyle
+}
+
+// newFeatureStyles returns a new `featureStyles` instance deriving styles for 'features' using 'styler'.
+func newFeatureStyles(features *featureCollection, styler featureStyler) *featureStyles {
+
+	s := &featureStyles{
+		mu:     new(sync.RWMutex),
+		styler: styler,
+		styles: make([]*LeafletStyle, 0),
+	}
+
+	s.Update(features)
+	return s
+}
+
+// Update derives the styles for any features in 'features' which have been added since the last update.
+func (s *featureStyles) Update(features *featureCollection) {
+
+	fc, _ := features.snapshot()
+
+	s.mu.RLock()
+	count := len(s.styles)
+	s.mu.RUnlock()
+
+	if count >= len(fc) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Another caller, with a more recent snapshot, may have updated the styles since the read lock
+	// was released so only derive styles for offsets that are still missing.
+
+	for i := len(s.styles); i < len(fc); i++ {
+		s.styles = append(s.styles, s.styler(fc[i]))
+	}
+}
+
+// Style returns the style for the feature at 'offset' or nil if it should be rendered using the default style.
+func (s *featureStyles) Style(offset int) *LeafletStyle {
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if offset < 0 || offset >= len(s.styles) {
+		return nil
+	}
+
+	return s.styles[offset]
+}
+
+// Styles returns the list of styles for the features at 'offsets'. Items are nil for features which should be
+// rendered using the default style. If none of the features have a style of their own then nil is returned.
+func (s *featureStyles) Styles(offsets []int) []*LeafletStyle {
+
+	styles := make([]*LeafletStyle, len(offsets))
+	found := false
+
+	for i, offset := range offsets {
+
+		styles[i] = s.Style(offset)
+
+		if styles[i] != nil {
+			found = true
+		}
+	}
+
+	if !found {
+		return nil
+	}
+
+	return styles
 }
@@ -0,0 +1,300 @@
+package show
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/paulmach/orb/geojson"
+)
+
+// StyleRules is a rules-based style definition which resolves individual features to `LeafletStyle` instances
+// according to their properties. For example:
+//
+//	{
+//		"default": { "color": "#666666", "weight": 1 },
+//		"rules": [
+//			{ "match": { "wof:placetype": "building" }, "style": { "color": "#ff0000" } },
+//			{ "match": { "wof:placetype": [ "county", "region" ] }, "style": { "color": "#00ff00" } },
+//			{ "range": { "height": { "gt": 50 } }, "style": { "weight": 4 } }
+//		]
+//	}
+//
+// Rules are tested in order and the style for the first rule that a feature matches is used, merged on top of the
+// default style. Features which do not match any rules are assigned the default style.
+type StyleRules struct {
+	// Default is the style for features which do not match any rules. It may be nil.
+	Default *LeafletStyle `json:"default,omitempty"`
+	// Rules is the list of rules to test features against, in order.
+	Rules []*StyleRule `json:"rules"`
+}
+
+// StyleRule is an individual rule in a `StyleRules` definition. A feature matches a rule if it matches all
+// of the rule's "match" and "range" conditions.
+type StyleRule struct {
+	// Match is a dictionary of property names and the value, or list of values (any one of which must match),
+	// that a feature's property must be equal to. Numeric values are compared numerically, other values are
+	// compared as strings. A null value matches features where the property is absent.
+	Match map[string]any `json:"match,omitempty"`
+	// Range is a dictionary of property names and the numeric range that a feature's property must fall within.
+	Range map[string]*StyleRange `json:"range,omitempty"`
+	// Style is the style assigned to features matching the rule.
+	Style *LeafletStyle `json:"style"`
+}
+
+// StyleRange defines a numeric range for a `StyleRule`. All the bounds that are present must be satisfied.
+type StyleRange struct {
+	GreaterThan        *float64 `json:"gt,omitempty"`
+	GreaterThanOrEqual *float64 `json:"gte,omitempty"`
+	LessThan           *float64 `json:"lt,omitempty"`
+	LessThanOrEqual    *float64 `json:"lte,omitempty"`
+}
+
+// UnmarshalStyleRules derives a `StyleRules` instance from 'raw'. If 'raw' starts with "{" then it is treated as
+// a JSON-encoded string, otherwise it is treated as a local path on disk. If the definition does not contain a "rules"
+// property it is treated as a plain `LeafletStyle` definition and returned as the default style of a `StyleRules`
+// instance with no rules. This allows the same value to be used for either kind of definition.
+func UnmarshalStyleRules(raw string) (*StyleRules, error) {
+
+	raw = strings.TrimSpace(raw)
+
+	if len(raw) == 0 {
+		return nil, fmt.Errorf("Empty style definition")
+	}
+
+	if string(raw[0]) == "{" {
+		return UnmarshalStyleRulesFromString(raw)
+	}
+
+	r, err := os.Open(raw)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer r.Close()
+
+	return UnmarshalStyleRulesFromReader(r)
+}
+
+// UnmarshalStyleRulesFromString derives a `StyleRules` instance from 'raw'.
+func UnmarshalStyleRulesFromString(raw string) (*StyleRules, error) {
+	return UnmarshalStyleRulesFromReader(strings.NewReader(raw))
+}
+
+// UnmarshalStyleRulesFromReader derives a `StyleRules` instance from the body of 'r'.
+func UnmarshalStyleRulesFromReader(r io.Reader) (*StyleRules, error) {
+
+	var doc map[string]json.RawMessage
+
+	dec := json.NewDecoder(r)
+	err := dec.Decode(&doc)
+
+	if err != nil {
+		return nil, jsonSyntaxError(err)
+	}
+
+	enc_doc, err := json.Marshal(doc)
+
+	if err != nil {
+		return nil, err
+	}
+
+	_, has_rules := doc["rules"]
+	_, has_default := doc["default"]
+
+	if !has_rules && has_default {
+		return nil, fmt.Errorf("Invalid style rules, missing 'rules' property")
+	}
+
+	if !has_rules {
+
+		s, err := UnmarshalStyleFromString(string(enc_doc))
+
+		if err != nil {
+			return nil, err
+		}
+
+		rules := &StyleRules{
+			Default: s,
+			Rules:   make([]*StyleRule, 0),
+		}
+
+		return rules, nil
+	}
+
+	var rules *StyleRules
+
+	rules_dec := json.NewDecoder(bytes.NewReader(enc_doc))
+	rules_dec.DisallowUnknownFields()
+
+	err = rules_dec.Decode(&rules)
+
+	if err != nil {
+		return nil, fmt.Errorf("Invalid style rules, %w", err)
+	}
+
+	err = rules.validate()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+// Style returns the style for 'f': the style of the first rule that 'f' matches merged on top of the default style,
+// or the default style if 'f' does not match any rules.
+func (r *StyleRules) Style(f *geojson.Feature) *LeafletStyle {
+
+	rule := r.match(f)
+
+	if rule == nil {
+		return r.Default
+	}
+
+	return mergeStyles(r.Default, rule.Style)
+}
+
+// styler returns a `featureStyler` for 'r' which returns nil for features which do not match any rules, since
+// those features are rendered using the default style. Merged styles are derived once and shared by all the
+// features matching each rule.
+func (r *StyleRules) styler() featureStyler {
+
+	merged := make(map[*StyleRule]*LeafletStyle)
+
+	for _, rule := range r.Rules {
+		merged[rule] = mergeStyles(r.Default, rule.Style)
+	}
+
+	fn := func(f *geojson.Feature) *LeafletStyle {
+
+		rule := r.match(f)
+
+		if rule == nil {
+			return nil
+		}
+
+		return merged[rule]
+	}
+
+	return fn
+}
+
+// match returns the first rule in 'r' that 'f' matches or nil.
+func (r *StyleRules) match(f *geojson.Feature) *StyleRule {
+
+	for _, rule := range r.Rules {
+
+		if rule.Matches(f) {
+			return rule
+		}
+	}
+
+	return nil
+}
+
+func (r *StyleRules) validate() error {
+
+	for i, rule := range r.Rules {
+
+		if rule == nil {
+			return fmt.Errorf("Rule at offset %d is empty", i)
+		}
+
+		if rule.Style == nil {
+			return fmt.Errorf("Rule at offset %d is missing a style", i)
+		}
+
+		if len(rule.Match) == 0 && len(rule.Range) == 0 {
+			return fmt.Errorf("Rule at offset %d has no match or range conditions", i)
+		}
+
+		for k, v := range rule.Range {
+
+			if v == nil || (v.GreaterThan == nil && v.GreaterThanOrEqual == nil && v.LessThan == nil && v.LessThanOrEqual == nil) {
+				return fmt.Errorf("Range condition for '%s' in rule at offset %d has no bounds", k, i)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Matches returns true if 'f' matches all of the "match" and "range" conditions in 'rule'.
+func (rule *StyleRule) Matches(f *geojson.Feature) bool {
+
+	for k, expected := range rule.Match {
+
+		if !matchesStyleValue(f.Properties[k], expected) {
+			return false
+		}
+	}
+
+	for k, rng := range rule.Range {
+
+		v, ok := numericValue(f.Properties[k])
+
+		if !ok || !rng.Contains(v) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Contains returns true if 'v' satisfies all the bounds defined by 'rng'.
+func (rng *StyleRange) Contains(v float64) bool {
+
+	if rng.GreaterThan != nil && !(v > *rng.GreaterThan) {
+		return false
+	}
+
+	if rng.GreaterThanOrEqual != nil && !(v >= *rng.GreaterThanOrEqual) {
+		return false
+	}
+
+	if rng.LessThan != nil && !(v < *rng.LessThan) {
+		return false
+	}
+
+	if rng.LessThanOrEqual != nil && !(v <= *rng.LessThanOrEqual) {
+		return false
+	}
+
+	return true
+}
+
+// matchesStyleValue returns true if the property value 'v' matches 'expected'. If 'expected' is a list then 'v'
+// must match any one of its items.
+func matchesStyleValue(v any, expected any) bool {
+
+	switch e := expected.(type) {
+	case []any:
+
+		for _, item := range e {
+
+			if matchesStyleValue(v, item) {
+				return true
+			}
+		}
+
+		return false
+
+	case nil:
+		return v == nil
+	case float64:
+
+		n, ok := numericValue(v)
+		return ok && n == e
+	}
+
+	if v == nil {
+		return false
+	}
+
+	return fmt.Sprintf("%v", v) == fmt.Sprintf("%v", expected)
+}
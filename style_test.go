@@ -0,0 +1,142 @@
+package show
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+)
+
+func TestMergeStylesZeroValues(t *testing.T) {
+
+	base, err := UnmarshalStyleFromString(`{"weight": 3, "opacity": 0.5, "fillOpacity": 0.5, "radius": 8}`)
+
+	if err != nil {
+		t.Fatalf("Failed to parse base style, %v", err)
+	}
+
+	tests := []struct {
+		override string
+		expected string
+	}{
+		{`{}`, `{"weight":3,"opacity":0.5,"fillOpacity":0.5,"radius":8}`},
+		{`{"fillOpacity": 0}`, `{"weight":3,"opacity":0.5,"fillOpacity":0,"radius":8}`},
+		{`{"opacity": 0, "weight": 0}`, `{"weight":0,"opacity":0,"fillOpacity":0.5,"radius":8}`},
+		{`{"radius": 0}`, `{"weight":3,"opacity":0.5,"fillOpacity":0.5,"radius":0}`},
+		{`{"smoothFactor": 0, "noClip": false}`, `{"weight":3,"opacity":0.5,"fillOpacity":0.5,"radius":8,"smoothFactor":0,"noClip":false}`},
+		{`{"bubblingMouseEvents": false, "pane": "boundaries"}`, `{"weight":3,"opacity":0.5,"fillOpacity":0.5,"radius":8,"bubblingMouseEvents":false,"pane":"boundaries"}`},
+	}
+
+	for _, test := range tests {
+
+		t.Run(test.override, func(t *testing.T) {
+
+			override, err := UnmarshalStyleFromString(test.override)
+
+			if err != nil {
+				t.Fatalf("Failed to parse override style, %v", err)
+			}
+
+			merged := mergeStyles(base, override).key()
+
+			if merged != test.expected {
+				t.Fatalf("Expected %s, got %s", test.expected, merged)
+			}
+		})
+	}
+}
+
+func TestUnmarshalStyleValidation(t *testing.T) {
+
+	tests := []struct {
+		style    string
+		expected string
+	}{
+		{`{"color": "#ff0000", "weight": 2}`, ""},
+		{`{"pane": "boundaries", "smoothFactor": 2, "noClip": true, "bubblingMouseEvents": false}`, ""},
+		{`{"fill-color": "#ff0000"}`, "Unknown style option 'fill-color', did you mean 'fillColor'?"},
+		{`{"smooth_factor": 1}`, "Unknown style option 'smooth_factor', did you mean 'smoothFactor'?"},
+		{`{"weight": -1}`, "Invalid value for style option 'weight', must not be negative"},
+		{`{"smoothFactor": -1}`, "Invalid value for style option 'smoothFactor', must not be negative"},
+		{`{"fillOpacity": 2}`, "Invalid value for style option 'fillOpacity', must be between 0 and 1"},
+		{`{"noClip": "yes"}`, "Invalid value for style option 'noClip', expected a boolean but got string"},
+		{`{"pane": "my pane"}`, "Invalid value for style option 'pane', 'my pane'. Pane names may only contain letters, numbers, '-' and '_'"},
+		{`{"pane": "<b>"}`, "Invalid value for style option 'pane', '<b>'. Pane names may only contain letters, numbers, '-' and '_'"},
+	}
+
+	for _, test := range tests {
+
+		t.Run(test.style, func(t *testing.T) {
+
+			_, err := UnmarshalStyleFromString(test.style)
+
+			if test.expected == "" {
+
+				if err != nil {
+					t.Fatalf("Failed to parse style, %v", err)
+				}
+
+				return
+			}
+
+			if err == nil {
+				t.Fatalf("Expected style to fail validation")
+			}
+
+			if err.Error() != test.expected {
+				t.Fatalf("Expected error '%s', got '%s'", test.expected, err.Error())
+			}
+		})
+	}
+}
+
+func TestFeatureStylesConcurrentUpdate(t *testing.T) {
+
+	ctx := context.Background()
+
+	features, err := newFeatureCollection(ctx, nil, nil, "")
+
+	if err != nil {
+		t.Fatalf("Failed to create feature collection, %v", err)
+	}
+
+	styler := func(f *geojson.Feature) *LeafletStyle {
+		return &LeafletStyle{Color: "#ff0000"}
+	}
+
+	styles := newFeatureStyles(features, styler)
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 8; i++ {
+
+		wg.Add(1)
+
+		go func(i int) {
+
+			defer wg.Done()
+
+			for j := 0; j < 50; j++ {
+
+				err := features.Add(ctx, geojson.NewFeature(orb.Point{float64(i), float64(j)}))
+
+				if err != nil {
+					t.Errorf("Failed to add feature, %v", err)
+					return
+				}
+
+				styles.Update(features)
+			}
+		}(i)
+	}
+
+	wg.Wait()
+
+	styles.Update(features)
+
+	if len(styles.styles) != features.Count() {
+		t.Fatalf("Expected %d styles, got %d", features.Count(), len(styles.styles))
+	}
+}
@@ -0,0 +1,204 @@
+package show
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/encoding/mvt"
+	"github.com/paulmach/orb/geojson"
+	"github.com/paulmach/orb/maptile"
+	"github.com/paulmach/orb/simplify"
+)
+
+// The name of the layer containing features in Mapbox Vector Tiles.
+const tiles_layer_name string = "features"
+
+// The default number of features above which the web application will render features using vector tiles.
+const default_vector_tile_threshold int = 10000
+
+// The maximum zoom level for which vector tiles are produced. Tiles for higher zoom levels are "over-zoomed" by the
+// web application.
+const tiles_max_zoom maptile.Zoom = 16
+
+// The number of pixels, in tile extent units, to buffer tiles by when clipping geometries.
+const tiles_buffer float64 = 64
+
+// The Douglas-Peucker threshold, in tile extent units, used to simplify geometries.
+const tiles_simplify_threshold float64 = 1.0
+
+// vectorTilesConfig defines configuration details for maps rendering features using vector tiles.
+type vectorTilesConfig struct {
+	// A relative URI template for requesting vector tiles.
+	TileURL string `json:"tile_url"`
+	// The name of the layer in vector tiles containing features.
+	Layer string `json:"layer"`
+	// The maximum zoom level for which vector tiles are produced.
+	MaxDataZoom int `json:"max_data_zoom"`
+}
+
+// tilesHandler returns an `http.Handler` for serving the features in 'features' as Mapbox Vector Tiles. Only
+// the "show:id" property and the properties listed in 'properties' are included in each tile. If 'styles' is not
+// nil features with a style of their own also include a JSON-encoded "show:style" property. The handler
+// expects to be registered with a "/tiles/{z}/{x}/{y}" pattern where the final "{y}" element ends in ".mvt".
+func tilesHandler(features *featureCollection, styles *featureStyles, properties []string) http.Handler {
+
+	fn := func(rsp http.ResponseWriter, req *http.Request) {
+
+		t, err := tileFromRequest(req)
+
+		if err != nil {
+			http.Error(rsp, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		body, err := renderTile(features, styles, t, properties)
+
+		if err != nil {
+			slog.Error("Failed to render tile", "z", t.Z, "x", t.X, "y", t.Y, "error", err)
+			http.Error(rsp, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		enc_body := newEncodedBody(body, "application/vnd.mapbox-vector-tile")
+		enc_body.ServeHTTP(rsp, req)
+		return
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+// tileFromRequest derives a `maptile.Tile` instance from the {z}, {x} and {y} path values in 'req'.
+func tileFromRequest(req *http.Request) (maptile.Tile, error) {
+
+	var t maptile.Tile
+
+	str_y, ok := strings.CutSuffix(req.PathValue("y"), ".mvt")
+
+	if !ok {
+		return t, fmt.Errorf("Invalid tile extension")
+	}
+
+	z, err := strconv.ParseUint(req.PathValue("z"), 10, 32)
+
+	if err != nil || maptile.Zoom(z) > tiles_max_zoom {
+		return t, fmt.Errorf("Invalid zoom level")
+	}
+
+	x, err := strconv.ParseUint(req.PathValue("x"), 10, 32)
+
+	if err != nil {
+		return t, fmt.Errorf("Invalid x coordinate")
+	}
+
+	y, err := strconv.ParseUint(str_y, 10, 32)
+
+	if err != nil {
+		return t, fmt.Errorf("Invalid y coordinate")
+	}
+
+	t = maptile.New(uint32(x), uint32(y), maptile.Zoom(z))
+
+	if !t.Valid() {
+		return t, fmt.Errorf("Invalid tile")
+	}
+
+	return t, nil
+}
+
+// renderTile cuts, clips and simplifies the features in 'features' which intersect 't' and returns
+// the result encoded as a Mapbox Vector Tile.
+func renderTile(features *featureCollection, styles *featureStyles, t maptile.Tile, properties []string) ([]byte, error) {
+
+	if styles != nil {
+		styles.Update(features)
+	}
+
+	b := t.Bound()
+
+	pad := (b.Max.X() - b.Min.X()) * tiles_buffer / float64(mvt.DefaultExtent)
+	b = b.Pad(pad)
+
+	q := &featuresQuery{
+		Bound: &b,
+	}
+
+	results := features.Query(q)
+
+	fc := geojson.NewFeatureCollection()
+
+	for i, f := range results.Features {
+
+		// Geometries are projected in place so make sure to work with a copy.
+
+		tile_f := geojson.NewFeature(orb.Clone(f.Geometry))
+		tile_f.ID = f.ID
+		tile_f.Properties = tileProperties(f, results.Ids[i], properties)
+
+		if styles != nil {
+
+			style := styles.Style(results.Offsets[i])
+
+			if style != nil {
+
+				enc_style, err := json.Marshal(style)
+
+				if err != nil {
+					return nil, fmt.Errorf("Failed to marshal style for %s, %w", results.Ids[i], err)
+				}
+
+				tile_f.Properties["show:style"] = string(enc_style)
+			}
+		}
+
+		fc.Append(tile_f)
+	}
+
+	layers := mvt.NewLayers(map[string]*geojson.FeatureCollection{
+		tiles_layer_name: fc,
+	})
+
+	layers.ProjectToTile(t)
+
+	clip_bound := orb.Bound{
+		Min: orb.Point{-tiles_buffer, -tiles_buffer},
+		Max: orb.Point{float64(mvt.DefaultExtent) + tiles_buffer, float64(mvt.DefaultExtent) + tiles_buffer},
+	}
+
+	layers.Clip(clip_bound)
+	layers.Simplify(simplify.DouglasPeucker(tiles_simplify_threshold))
+	layers.RemoveEmpty(1.0, 1.0)
+
+	return mvt.Marshal(layers)
+}
+
+// tileProperties returns the subset of properties for 'f' to include in vector tiles. Values which
+// can not be encoded in vector tiles (for example lists and dictionaries) are encoded as strings.
+func tileProperties(f *geojson.Feature, show_id string, properties []string) geojson.Properties {
+
+	props := geojson.Properties{
+		"show:id": show_id,
+	}
+
+	for _, k := range properties {
+
+		v, exists := f.Properties[k]
+
+		if !exists {
+			continue
+		}
+
+		switch v.(type) {
+		case string, bool, float64, float32, int, int64, int32, uint, uint64, uint32:
+			props[k] = v
+		default:
+			props[k] = fmt.Sprintf("%v", v)
+		}
+	}
+
+	return props
+}
@@ -0,0 +1,141 @@
+package show
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/encoding/mvt"
+	"github.com/paulmach/orb/geojson"
+	"github.com/paulmach/orb/maptile"
+)
+
+func TestTilesHandler(t *testing.T) {
+
+	ctx := context.Background()
+
+	sfo := geojson.NewFeature(orb.Point{-122.386166, 37.616407})
+	sfo.Properties["name"] = "SFO"
+	sfo.Properties["wof:hierarchy"] = []any{map[string]any{"country_id": 85633793}}
+
+	ams := geojson.NewFeature(orb.Polygon{{{4, 51}, {6, 51}, {6, 53}, {4, 53}, {4, 51}}})
+	ams.Properties["name"] = "AMS"
+
+	features, err := newFeatureCollection(ctx, []*geojson.Feature{sfo, ams}, nil, "")
+
+	if err != nil {
+		t.Fatalf("Failed to create feature collection, %v", err)
+	}
+
+	styler := func(f *geojson.Feature) *LeafletStyle {
+
+		if f.Properties.MustString("name", "") != "SFO" {
+			return nil
+		}
+
+		return &LeafletStyle{Color: "#ff0000"}
+	}
+
+	styles := newFeatureStyles(features, styler)
+
+	mux := http.NewServeMux()
+	mux.Handle("/tiles/{z}/{x}/{y}", tilesHandler(features, styles, []string{"name", "wof:hierarchy"}))
+
+	sfo_tile := maptile.At(orb.Point{-122.386166, 37.616407}, 10)
+
+	tests := []struct {
+		path   string
+		status int
+		names  []string
+	}{
+		{"/tiles/0/0/0.mvt", 200, []string{"SFO", "AMS"}},
+		{fmt.Sprintf("/tiles/10/%d/%d.mvt", sfo_tile.X, sfo_tile.Y), 200, []string{"SFO"}},
+		{"/tiles/10/0/0.mvt", 200, []string{}},
+		{"/tiles/0/0/0.png", 400, nil},
+		{"/tiles/17/0/0.mvt", 400, nil},
+		{"/tiles/1/2/0.mvt", 400, nil},
+		{"/tiles/a/0/0.mvt", 400, nil},
+	}
+
+	for _, test := range tests {
+
+		t.Run(test.path, func(t *testing.T) {
+
+			rsp := httptest.NewRecorder()
+			mux.ServeHTTP(rsp, httptest.NewRequest("GET", test.path, nil))
+
+			if rsp.Code != test.status {
+				t.Fatalf("Expected status code %d, got %d", test.status, rsp.Code)
+			}
+
+			if test.status != 200 {
+				return
+			}
+
+			if rsp.Header().Get("Content-Type") != "application/vnd.mapbox-vector-tile" {
+				t.Fatalf("Unexpected content type, %s", rsp.Header().Get("Content-Type"))
+			}
+
+			layers, err := mvt.Unmarshal(rsp.Body.Bytes())
+
+			if err != nil {
+				t.Fatalf("Failed to decode tile, %v", err)
+			}
+
+			names := make(map[string]geojson.Properties)
+
+			for _, l := range layers {
+
+				if l.Name != tiles_layer_name {
+					t.Fatalf("Unexpected layer %s", l.Name)
+				}
+
+				for _, f := range l.Features {
+					names[f.Properties.MustString("name", "")] = f.Properties
+				}
+			}
+
+			if len(names) != len(test.names) {
+				t.Fatalf("Expected %d features, got %d", len(test.names), len(names))
+			}
+
+			for _, name := range test.names {
+
+				props, exists := names[name]
+
+				if !exists {
+					t.Fatalf("Expected tile to contain %s", name)
+				}
+
+				if props.MustString("show:id", "") == "" {
+					t.Fatalf("Expected %s to have a show:id property", name)
+				}
+			}
+
+			// Lists are encoded as strings and only features with a style of their own have a show:style property
+
+			props, exists := names["SFO"]
+
+			if !exists {
+				return
+			}
+
+			if props.MustString("wof:hierarchy", "") != "[map[country_id:85633793]]" {
+				t.Fatalf("Unexpected wof:hierarchy property, %v", props["wof:hierarchy"])
+			}
+
+			if props.MustString("show:style", "") != `{"color":"#ff0000"}` {
+				t.Fatalf("Unexpected show:style property, %v", props["show:style"])
+			}
+
+			ams_props, exists := names["AMS"]
+
+			if exists && ams_props["show:style"] != nil {
+				t.Fatalf("Unexpected show:style property for AMS")
+			}
+		})
+	}
+}
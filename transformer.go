@@ -0,0 +1,147 @@
+package show
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+	"github.com/paulmach/orb/planar"
+)
+
+// FeatureTransformer is an interface for modifying GeoJSON features before they are served. Transformers are applied
+// when features are first loaded and again whenever features are added to a running `Handler`.
+type FeatureTransformer interface {
+	// Transform returns zero or more features derived from 'f'. Returning an empty list will cause 'f' to be dropped
+	// and returning more than one feature will cause 'f' to be expanded.
+	Transform(context.Context, *geojson.Feature) ([]*geojson.Feature, error)
+}
+
+// FeatureTransformerFunc is a function that implements the `FeatureTransformer` interface.
+type FeatureTransformerFunc func(context.Context, *geojson.Feature) ([]*geojson.Feature, error)
+
+// Transform invokes 'fn' with 'f'.
+func (fn FeatureTransformerFunc) Transform(ctx context.Context, f *geojson.Feature) ([]*geojson.Feature, error) {
+	return fn(ctx, f)
+}
+
+// TransformFeatures applies each transformer in 'transformers', in order, to 'features' returning the final list of features.
+func TransformFeatures(ctx context.Context, features []*geojson.Feature, transformers ...FeatureTransformer) ([]*geojson.Feature, error) {
+
+	for idx, tr := range transformers {
+
+		transformed := make([]*geojson.Feature, 0, len(features))
+
+		for _, f := range features {
+
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			default:
+				// pass
+			}
+
+			new_features, err := tr.Transform(ctx, f)
+
+			if err != nil {
+				return nil, fmt.Errorf("Transformer at offset %d failed, %w", idx, err)
+			}
+
+			transformed = append(transformed, new_features...)
+		}
+
+		features = transformed
+	}
+
+	return features, nil
+}
+
+// NewRenamePropertiesTransformer returns a `FeatureTransformer` that renames feature properties using the
+// old-name-to-new-name mapping defined in 'names'. Properties that are not present are ignored.
+func NewRenamePropertiesTransformer(names map[string]string) FeatureTransformer {
+
+	fn := func(ctx context.Context, f *geojson.Feature) ([]*geojson.Feature, error) {
+
+		if len(f.Properties) == 0 {
+			return []*geojson.Feature{f}, nil
+		}
+
+		new_f := cloneFeature(f, f.Geometry)
+
+		for old_name, new_name := range names {
+
+			v, exists := new_f.Properties[old_name]
+
+			if !exists {
+				continue
+			}
+
+			delete(new_f.Properties, old_name)
+			new_f.Properties[new_name] = v
+		}
+
+		return []*geojson.Feature{new_f}, nil
+	}
+
+	return FeatureTransformerFunc(fn)
+}
+
+// NewCentroidTransformer returns a `FeatureTransformer` that replaces each feature's geometry with its centroid.
+func NewCentroidTransformer() FeatureTransformer {
+
+	fn := func(ctx context.Context, f *geojson.Feature) ([]*geojson.Feature, error) {
+
+		if f.Geometry == nil {
+			return []*geojson.Feature{f}, nil
+		}
+
+		pt, _ := planar.CentroidArea(f.Geometry)
+		return []*geojson.Feature{cloneFeature(f, pt)}, nil
+	}
+
+	return FeatureTransformerFunc(fn)
+}
+
+// NewBoundsTransformer returns a `FeatureTransformer` that replaces each feature's geometry with its bounding box.
+// Point geometries are left as-is.
+func NewBoundsTransformer() FeatureTransformer {
+
+	fn := func(ctx context.Context, f *geojson.Feature) ([]*geojson.Feature, error) {
+
+		if f.Geometry == nil {
+			return []*geojson.Feature{f}, nil
+		}
+
+		switch f.Geometry.(type) {
+		case orb.Point:
+			return []*geojson.Feature{f}, nil
+		}
+
+		poly := f.Geometry.Bound().ToPolygon()
+		return []*geojson.Feature{cloneFeature(f, poly)}, nil
+	}
+
+	return FeatureTransformerFunc(fn)
+}
+
+// cloneFeature returns a copy of 'f' (including a copy of its properties) with 'geom' as its geometry. If 'f' has a bounding
+// box it is copied when 'geom' is the same as the geometry of 'f' and recomputed from 'geom' otherwise. Foreign members are
+// not copied because `geojson.Feature` does not retain them when features are decoded.
+func cloneFeature(f *geojson.Feature, geom orb.Geometry) *geojson.Feature {
+
+	new_f := geojson.NewFeature(geom)
+	new_f.ID = f.ID
+	new_f.Properties = f.Properties.Clone()
+
+	if f.BBox != nil {
+
+		if geom != nil && f.Geometry != nil && orb.Equal(geom, f.Geometry) {
+			new_f.BBox = slices.Clone(f.BBox)
+		} else if geom != nil {
+			new_f.BBox = geojson.NewBBox(geom.Bound())
+		}
+	}
+
+	return new_f
+}
@@ -0,0 +1,82 @@
+package show
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+)
+
+func TestTransformersCloneFeatures(t *testing.T) {
+
+	ctx := context.Background()
+
+	tests := []struct {
+		name        string
+		transformer FeatureTransformer
+		geom_type   string
+		bbox        geojson.BBox
+	}{
+		// Bounding boxes are copied (including any elevation values) when the geometry is unchanged
+		{"rename", NewRenamePropertiesTransformer(map[string]string{"name": "wof:name"}), "Polygon", geojson.BBox{0, 0, 0, 3, 3, 10}},
+		// and derived from the new geometry otherwise
+		{"centroid", NewCentroidTransformer(), "Point", geojson.BBox{2, 1, 2, 1}},
+		{"bounds", NewBoundsTransformer(), "Polygon", geojson.BBox{0, 0, 3, 3}},
+	}
+
+	for _, test := range tests {
+
+		t.Run(test.name, func(t *testing.T) {
+
+			f := geojson.NewFeature(orb.Polygon{{{0, 0}, {3, 0}, {3, 3}, {0, 0}}})
+			f.ID = "102527513"
+			f.BBox = geojson.BBox{0, 0, 0, 3, 3, 10}
+			f.Properties["name"] = "SFO"
+
+			features, err := TransformFeatures(ctx, []*geojson.Feature{f}, test.transformer)
+
+			if err != nil {
+				t.Fatalf("Failed to transform feature, %v", err)
+			}
+
+			if len(features) != 1 {
+				t.Fatalf("Expected a single feature, got %d", len(features))
+			}
+
+			new_f := features[0]
+
+			if new_f == f {
+				t.Fatalf("Expected a copy of the original feature")
+			}
+
+			if new_f.Geometry.GeoJSONType() != test.geom_type {
+				t.Fatalf("Expected a %s geometry, got %s", test.geom_type, new_f.Geometry.GeoJSONType())
+			}
+
+			if new_f.ID != f.ID {
+				t.Fatalf("Expected ID %v, got %v", f.ID, new_f.ID)
+			}
+
+			if !slices.Equal(new_f.BBox, test.bbox) {
+				t.Fatalf("Expected bounding box %v, got %v", test.bbox, new_f.BBox)
+			}
+
+			// Modifying the copy must not modify the original feature
+
+			new_f.BBox[0] = -1
+			new_f.Properties["modified"] = true
+
+			if f.BBox[0] != 0 {
+				t.Fatalf("Bounding box of original feature was modified")
+			}
+
+			_, exists := f.Properties["modified"]
+
+			if exists {
+				t.Fatalf("Properties of original feature were modified")
+			}
+		})
+	}
+}